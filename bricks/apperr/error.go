@@ -1,16 +1,17 @@
 package apperr
 
-import "errors"
+import errbrick "github.com/demeero/bricks/errbrick"
 
+// The errors are the errbrick ones, so the services returning either are handled alike, e.g. by the httpsrv error handler.
 var (
 	// ErrInvalidData is returned when the input data is invalid.
-	ErrInvalidData = errors.New("invalid data")
+	ErrInvalidData = errbrick.ErrInvalidData
 	// ErrNotFound is returned when the requested resource is not found.
-	ErrNotFound = errors.New("not found")
+	ErrNotFound = errbrick.ErrNotFound
 	// ErrConflict is returned when the requested resource already exists or some another data conflict occurs.
-	ErrConflict = errors.New("conflict")
+	ErrConflict = errbrick.ErrConflict
 	// ErrForbidden is returned when the user is not authorized to perform the requested action.
-	ErrForbidden = errors.New("forbidden")
+	ErrForbidden = errbrick.ErrForbidden
 	// ErrUnauthorized is returned when the user is not correctly authenticated.
-	ErrUnauthorized = errors.New("unauthorized")
+	ErrUnauthorized = errbrick.ErrUnauthorized
 )
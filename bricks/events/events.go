@@ -0,0 +1,78 @@
+// Package events is the contract of the chat events shared by the producers and the consumers.
+// The event types are versioned: a breaking change of the payload adds the new version type
+// instead of changing the existing one, the unversioned aliases point to the current versions.
+package events
+
+import (
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// Topics of the chat events.
+const (
+	// TopicMsgSent is the topic of the messages sent by the users, published by ws-sender.
+	TopicMsgSent = "msg_sent"
+	// TopicMsgStored is the topic of the messages stored in the history, published by the history writer.
+	TopicMsgStored = "msg_stored"
+)
+
+// ReplayedKey is the metadata key of the msg_stored events republished by the history replay.
+// The consumers delivering the messages to the users skip them, the projections handle them as usual.
+const ReplayedKey = "replayed"
+
+// SetReplayed marks the event as replayed.
+func SetReplayed(md message.Metadata) {
+	md.Set(ReplayedKey, "true")
+}
+
+// Replayed reports whether the event is replayed.
+func Replayed(md message.Metadata) bool {
+	return md.Get(ReplayedKey) == "true"
+}
+
+// User is the author of the message.
+// The events carry only the user ID, the profile is resolved by the consumers at read time.
+type User struct {
+	ID string `json:"id"`
+}
+
+// MsgSentV1 is the message sent by the user to the chat room.
+type MsgSentV1 struct {
+	ChatRoomID string `json:"chat_room_id"`
+	// PendingID is the ID the client assigned to the message before it was stored.
+	PendingID string    `json:"pending_id"`
+	Msg       string    `json:"msg"`
+	User      User      `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MsgStoredV1 is the message stored in the history.
+type MsgStoredV1 struct {
+	MsgID string `json:"msg_id"`
+	// Seq is the sequence number of the message in the room.
+	Seq int64 `json:"seq"`
+	MsgSentV1
+}
+
+// MsgSent is the current version of the msg_sent event.
+type MsgSent = MsgSentV1
+
+// MsgStored is the current version of the msg_stored event.
+type MsgStored = MsgStoredV1
+
+// NewMsgSent creates the msg_sent event of the message sent now.
+func NewMsgSent(chatRoomID, pendingID, msg, userID string) MsgSent {
+	return MsgSent{
+		ChatRoomID: chatRoomID,
+		PendingID:  pendingID,
+		Msg:        msg,
+		User:       User{ID: userID},
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+// NewMsgStored creates the msg_stored event of the sent message stored with the ID and the sequence number.
+func NewMsgStored(msgID string, seq int64, sent MsgSent) MsgStored {
+	return MsgStored{MsgID: msgID, Seq: seq, MsgSentV1: sent}
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The golden payloads are the published contract. A test failing here means the change breaks
+// the producers or the consumers still running the previous version, so add the new version type instead.
+
+var sentV1 = MsgSentV1{
+	ChatRoomID: "room-1",
+	PendingID:  "pending-1",
+	Msg:        "hello",
+	User:       User{ID: "user-1"},
+	CreatedAt:  time.Date(2023, 11, 20, 10, 0, 0, 123000000, time.UTC),
+}
+
+func TestMsgSentV1_Compatibility(t *testing.T) {
+	golden, err := os.ReadFile("testdata/msg_sent.v1.json")
+	require.NoError(t, err)
+
+	var decoded MsgSentV1
+	require.NoError(t, json.Unmarshal(golden, &decoded))
+	assert.Equal(t, sentV1, decoded)
+
+	encoded, err := json.Marshal(sentV1)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(golden), string(encoded))
+}
+
+func TestMsgStoredV1_Compatibility(t *testing.T) {
+	golden, err := os.ReadFile("testdata/msg_stored.v1.json")
+	require.NoError(t, err)
+	stored := NewMsgStored("d0a7b9c2-8790-11ee-b9d1-0242ac120002", 42, sentV1)
+
+	var decoded MsgStoredV1
+	require.NoError(t, json.Unmarshal(golden, &decoded))
+	assert.Equal(t, stored, decoded)
+
+	encoded, err := json.Marshal(stored)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(golden), string(encoded))
+}
+
+func TestMsgSentV1_LegacyUser(t *testing.T) {
+	// the events published before the profiles were resolved at read time carry the user data
+	legacy := `{"chat_room_id":"room-1","msg":"hello","user":{"id":"user-1","email":"john@example.com","first_name":"John"},
+		"created_at":"2023-11-20T10:00:00.123Z"}`
+	var decoded MsgSentV1
+	require.NoError(t, json.Unmarshal([]byte(legacy), &decoded))
+	assert.Equal(t, User{ID: "user-1"}, decoded.User)
+}
+
+func TestNewMsgSent(t *testing.T) {
+	evt := NewMsgSent("room-1", "pending-1", "hello", "user-1")
+	assert.Equal(t, "user-1", evt.User.ID)
+	assert.Equal(t, time.UTC, evt.CreatedAt.Location())
+	assert.WithinDuration(t, time.Now(), evt.CreatedAt, time.Minute)
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// SentAtKey is the metadata key of the time the user sent the message, in Unix milliseconds.
+// It is stamped on the msg_sent event and carried over to the msg_stored event, so every stage can measure the latency since the send.
+const SentAtKey = "sent_at"
+
+// SetSentAt sets the time the user sent the message.
+func SetSentAt(md message.Metadata, t time.Time) {
+	md.Set(SentAtKey, strconv.FormatInt(t.UnixMilli(), 10))
+}
+
+// SentAt returns the time the user sent the message, false if it is unknown.
+func SentAt(md message.Metadata) (time.Time, bool) {
+	ms, err := strconv.ParseInt(md.Get(SentAtKey), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+// Latency records the time since the user sent the message until it reaches the stage, e.g. it is stored or delivered.
+type Latency struct {
+	hist     metric.Int64Histogram
+	instance attribute.KeyValue
+}
+
+// NewLatency creates the Latency histogram with the name. The measurements are labelled by the service instance.
+func NewLatency(name, description, instance string) (*Latency, error) {
+	hist, err := otel.GetMeterProvider().Meter("bricks/events").
+		Int64Histogram(name, metric.WithDescription(description), metric.WithUnit("ms"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create %s metric: %w", name, err)
+	}
+	return &Latency{hist: hist, instance: semconv.ServiceInstanceID(instance)}, nil
+}
+
+// Record records the latency of the message. The messages without the send time are skipped.
+func (l *Latency) Record(ctx context.Context, md message.Metadata) {
+	sentAt, ok := SentAt(md)
+	if !ok {
+		return
+	}
+	l.hist.Record(ctx, time.Since(sentAt).Milliseconds(), metric.WithAttributes(l.instance))
+}
+
+// Handler records the latency of the messages the handler processed successfully.
+func (l *Latency) Handler(h message.NoPublishHandlerFunc) message.NoPublishHandlerFunc {
+	return func(msg *message.Message) error {
+		if err := h(msg); err != nil {
+			return err
+		}
+		l.Record(msg.Context(), msg.Metadata)
+		return nil
+	}
+}
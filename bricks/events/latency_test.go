@@ -0,0 +1,53 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestLatency_Handler(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	defer otel.SetMeterProvider(sdkmetric.NewMeterProvider())
+
+	l, err := NewLatency("msg_send_to_store_latency", "", "host-1")
+	require.NoError(t, err)
+	var fail bool
+	h := l.Handler(func(*message.Message) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	SetSentAt(msg.Metadata, time.Now().Add(-time.Second))
+	require.NoError(t, h(msg))
+	fail = true
+	require.Error(t, h(msg))
+	fail = false
+	// the message without the send time, e.g. the imported one, is not recorded
+	require.NoError(t, h(message.NewMessage(watermill.NewUUID(), []byte(`{}`))))
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	require.Len(t, rm.ScopeMetrics[0].Metrics, 1)
+	hist := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[int64])
+	require.Len(t, hist.DataPoints, 1)
+	assert.Equal(t, uint64(1), hist.DataPoints[0].Count)
+	assert.GreaterOrEqual(t, hist.DataPoints[0].Sum, int64(1000))
+	instance, ok := hist.DataPoints[0].Attributes.Value("service.instance.id")
+	require.True(t, ok)
+	assert.Equal(t, "host-1", instance.AsString())
+}
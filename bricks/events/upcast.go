@@ -0,0 +1,173 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// VersionKey is the metadata key of the payload version of the event.
+// The events published before the versioning have no version and are of the version 0.
+const VersionKey = "event_version"
+
+// The current payload versions of the topics.
+const (
+	MsgSentVersion   = 1
+	MsgStoredVersion = 1
+)
+
+// UserV0 is the author of the message with the profile data the events carried before the profiles were resolved at read time.
+type UserV0 struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// MsgSentV0 is the unversioned msg_sent event.
+type MsgSentV0 struct {
+	ChatRoomID string    `json:"chat_room_id"`
+	PendingID  string    `json:"pending_id"`
+	Msg        string    `json:"msg"`
+	User       UserV0    `json:"user"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// MsgStoredV0 is the unversioned msg_stored event published before the messages were sequenced.
+type MsgStoredV0 struct {
+	MsgID string `json:"msg_id"`
+	MsgSentV0
+}
+
+func (e MsgSentV0) upcast() MsgSentV1 {
+	// the profile data is dropped, so it does not spread further than the legacy events
+	return MsgSentV1{
+		ChatRoomID: e.ChatRoomID,
+		PendingID:  e.PendingID,
+		Msg:        e.Msg,
+		User:       User{ID: e.User.ID},
+		CreatedAt:  e.CreatedAt,
+	}
+}
+
+// Upcaster transforms the payload of the version it is registered for into the payload of the next version.
+type Upcaster func(payload []byte) ([]byte, error)
+
+// Upcasters transforms the payloads of the older versions into the current version of the topic,
+// so the consumers decode only the current event types.
+type Upcasters struct {
+	current   map[string]int
+	upcasters map[string]map[int]Upcaster
+}
+
+// NewUpcasters creates the Upcasters of the msg_sent and msg_stored events of all supported versions.
+func NewUpcasters() *Upcasters {
+	u := &Upcasters{current: map[string]int{}, upcasters: map[string]map[int]Upcaster{}}
+	u.SetCurrent(TopicMsgSent, MsgSentVersion)
+	u.Register(TopicMsgSent, 0, upcastJSON(func(e MsgSentV0) MsgSentV1 {
+		return e.upcast()
+	}))
+	u.SetCurrent(TopicMsgStored, MsgStoredVersion)
+	u.Register(TopicMsgStored, 0, upcastJSON(func(e MsgStoredV0) MsgStoredV1 {
+		return MsgStoredV1{MsgID: e.MsgID, MsgSentV1: e.MsgSentV0.upcast()}
+	}))
+	return u
+}
+
+func upcastJSON[From, To any](fn func(From) To) Upcaster {
+	return func(payload []byte) ([]byte, error) {
+		var from From
+		if err := json.Unmarshal(payload, &from); err != nil {
+			return nil, fmt.Errorf("failed decode payload: %w", err)
+		}
+		return json.Marshal(fn(from))
+	}
+}
+
+// SetCurrent sets the current version of the topic.
+func (u *Upcasters) SetCurrent(topic string, version int) {
+	u.current[topic] = version
+}
+
+// Register registers the upcaster of the payloads of the topic of the version.
+func (u *Upcasters) Register(topic string, version int, up Upcaster) {
+	if u.upcasters[topic] == nil {
+		u.upcasters[topic] = map[int]Upcaster{}
+	}
+	u.upcasters[topic][version] = up
+}
+
+// Upcast transforms the payload of the version into the current version of the topic.
+// The payloads of the topics without the current version are returned as they are.
+// The payload of a newer version is an error, it is published by a producer that is newer than the consumer.
+func (u *Upcasters) Upcast(topic string, version int, payload []byte) ([]byte, error) {
+	current, ok := u.current[topic]
+	if !ok {
+		return payload, nil
+	}
+	if version > current {
+		return nil, fmt.Errorf("unsupported %s event version %d, the current version is %d", topic, version, current)
+	}
+	for ; version < current; version++ {
+		up, ok := u.upcasters[topic][version]
+		if !ok {
+			return nil, fmt.Errorf("no upcaster of %s event version %d", topic, version)
+		}
+		var err error
+		if payload, err = up(payload); err != nil {
+			return nil, fmt.Errorf("failed upcast %s event version %d: %w", topic, version, err)
+		}
+	}
+	return payload, nil
+}
+
+// UpcastMsg upcasts the payload of the message of the topic in place and sets its version to the current one.
+func (u *Upcasters) UpcastMsg(topic string, msg *message.Message) error {
+	version, err := Version(msg.Metadata)
+	if err != nil {
+		return err
+	}
+	payload, err := u.Upcast(topic, version, msg.Payload)
+	if err != nil {
+		return err
+	}
+	msg.Payload = payload
+	if current, ok := u.current[topic]; ok {
+		SetVersion(msg.Metadata, current)
+	}
+	return nil
+}
+
+// Middleware upcasts the messages of the topic before they reach the handler.
+// The messages that can't be upcasted are not acked, so they are redelivered once the consumer supports their version.
+func (u *Upcasters) Middleware(topic string) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return func(msg *message.Message) ([]*message.Message, error) {
+			if err := u.UpcastMsg(topic, msg); err != nil {
+				return nil, err
+			}
+			return h(msg)
+		}
+	}
+}
+
+// Version returns the payload version of the event from the metadata. The event without the version is of the version 0.
+func Version(md message.Metadata) (int, error) {
+	v := md.Get(VersionKey)
+	if v == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid event version %q", v)
+	}
+	return version, nil
+}
+
+// SetVersion sets the payload version of the event in the metadata.
+func SetVersion(md message.Metadata, version int) {
+	md.Set(VersionKey, strconv.Itoa(version))
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpcasters_Upcast(t *testing.T) {
+	storedV0 := NewMsgStored("d0a7b9c2-8790-11ee-b9d1-0242ac120002", 0, sentV1)
+	tests := []struct {
+		topic   string
+		version int
+		golden  string
+		evt     interface{ Validate() error }
+		want    interface{ Validate() error }
+	}{
+		{topic: TopicMsgSent, version: 0, golden: "testdata/msg_sent.v0.json", evt: &MsgSentV1{}, want: &sentV1},
+		{topic: TopicMsgSent, version: 1, golden: "testdata/msg_sent.v1.json", evt: &MsgSentV1{}, want: &sentV1},
+		{topic: TopicMsgStored, version: 0, golden: "testdata/msg_stored.v0.json", evt: &MsgStoredV1{}, want: &storedV0},
+		{topic: TopicMsgStored, version: 1, golden: "testdata/msg_stored.v1.json", evt: &MsgStoredV1{},
+			want: &MsgStoredV1{MsgID: storedV0.MsgID, Seq: 42, MsgSentV1: sentV1}},
+	}
+	u := NewUpcasters()
+	for _, tt := range tests {
+		t.Run(tt.topic+".v"+strconv.Itoa(tt.version), func(t *testing.T) {
+			golden, err := os.ReadFile(tt.golden)
+			require.NoError(t, err)
+
+			payload, err := u.Upcast(tt.topic, tt.version, golden)
+			require.NoError(t, err)
+			assert.NotContains(t, string(payload), "john@example.com")
+			require.NoError(t, json.Unmarshal(payload, tt.evt))
+			assert.Equal(t, tt.want, tt.evt)
+			assert.NoError(t, tt.evt.Validate())
+		})
+	}
+}
+
+func TestUpcasters_Upcast_Unsupported(t *testing.T) {
+	u := NewUpcasters()
+	_, err := u.Upcast(TopicMsgSent, MsgSentVersion+1, []byte(`{}`))
+	assert.ErrorContains(t, err, "unsupported msg_sent event version 2")
+
+	u.SetCurrent(TopicMsgSent, 3)
+	_, err = u.Upcast(TopicMsgSent, 0, []byte(`{}`))
+	assert.ErrorContains(t, err, "no upcaster of msg_sent event version 1")
+
+	payload, err := u.Upcast("other", 5, []byte(`{}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(payload))
+}
+
+func TestUpcasters_Middleware(t *testing.T) {
+	golden, err := os.ReadFile("testdata/msg_sent.v0.json")
+	require.NoError(t, err)
+
+	var handled *message.Message
+	h := NewUpcasters().Middleware(TopicMsgSent)(func(msg *message.Message) ([]*message.Message, error) {
+		handled = msg
+		return nil, nil
+	})
+
+	_, err = h(message.NewMessage(watermill.NewUUID(), golden))
+	require.NoError(t, err)
+	require.NotNil(t, handled)
+	version, err := Version(handled.Metadata)
+	require.NoError(t, err)
+	assert.Equal(t, MsgSentVersion, version)
+	var evt MsgSentV1
+	require.NoError(t, json.Unmarshal(handled.Payload, &evt))
+	assert.Equal(t, sentV1, evt)
+
+	handled = nil
+	msg := message.NewMessage(watermill.NewUUID(), golden)
+	msg.Metadata.Set(VersionKey, "x")
+	_, err = h(msg)
+	assert.ErrorContains(t, err, `invalid event version "x"`)
+	assert.Nil(t, handled, "the message of the invalid version must not reach the handler")
+}
@@ -0,0 +1,147 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Limits of the event fields.
+const (
+	MaxIDLen  = 128
+	MaxMsgLen = 4096
+)
+
+// FieldError is the reason the event field is invalid. The field is the JSON path of the field in the payload.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// ValidationError is the invalid event of the topic.
+type ValidationError struct {
+	Topic  string
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		reasons = append(reasons, f.Error())
+	}
+	return fmt.Sprintf("invalid %s event: %s", e.Topic, strings.Join(reasons, "; "))
+}
+
+type fieldErrors []FieldError
+
+func (errs *fieldErrors) add(field, reason string) {
+	*errs = append(*errs, FieldError{Field: field, Reason: reason})
+}
+
+func (errs *fieldErrors) id(field, id string, required bool) {
+	switch {
+	case id == "" && required:
+		errs.add(field, "is required")
+	case len(id) > MaxIDLen:
+		errs.add(field, fmt.Sprintf("is longer than %d bytes", MaxIDLen))
+	}
+}
+
+func (errs fieldErrors) err(topic string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Topic: topic, Fields: errs}
+}
+
+func (e MsgSentV1) fieldErrors() fieldErrors {
+	var errs fieldErrors
+	errs.id("chat_room_id", e.ChatRoomID, true)
+	errs.id("pending_id", e.PendingID, false)
+	errs.id("user.id", e.User.ID, true)
+	switch {
+	case strings.TrimSpace(e.Msg) == "":
+		errs.add("msg", "is required")
+	case !utf8.ValidString(e.Msg):
+		errs.add("msg", "is not valid UTF-8")
+	case utf8.RuneCountInString(e.Msg) > MaxMsgLen:
+		errs.add("msg", fmt.Sprintf("is longer than %d characters", MaxMsgLen))
+	}
+	if e.CreatedAt.IsZero() {
+		errs.add("created_at", "is required")
+	}
+	return errs
+}
+
+// Validate returns the ValidationError if the event is invalid.
+func (e MsgSentV1) Validate() error {
+	return e.fieldErrors().err(TopicMsgSent)
+}
+
+// Validate returns the ValidationError if the event is invalid.
+func (e MsgStoredV1) Validate() error {
+	errs := e.MsgSentV1.fieldErrors()
+	if e.MsgID == "" {
+		errs.add("msg_id", "is required")
+	} else if _, err := gocql.ParseUUID(e.MsgID); err != nil {
+		errs.add("msg_id", "is not a UUID")
+	}
+	if e.Seq < 0 {
+		errs.add("seq", "is negative")
+	}
+	return errs.err(TopicMsgStored)
+}
+
+// Validator decodes and validates the events and counts the invalid ones per topic.
+type Validator struct {
+	invalid metric.Int64Counter
+}
+
+// NewValidator creates a new Validator.
+func NewValidator() (*Validator, error) {
+	invalid, err := otel.GetMeterProvider().Meter("bricks/events").
+		Int64Counter("event_validation_failed_count", metric.WithDescription("The number of the invalid events"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create event_validation_failed_count metric: %w", err)
+	}
+	return &Validator{invalid: invalid}, nil
+}
+
+// Validate validates the event of the topic. The failures are counted by the topic and the invalid field.
+func (v *Validator) Validate(ctx context.Context, topic string, evt interface{ Validate() error }) error {
+	err := evt.Validate()
+	var vErr *ValidationError
+	if errors.As(err, &vErr) {
+		for _, f := range vErr.Fields {
+			v.invalid.Add(ctx, 1, metric.WithAttributes(attribute.String("topic", topic), attribute.String("field", f.Field)))
+		}
+	}
+	return err
+}
+
+// Decode decodes the payload of the topic into the event and validates it.
+// A payload that is not the JSON object of the event is reported as the invalid field "$".
+func (v *Validator) Decode(ctx context.Context, topic string, payload []byte, evt interface{ Validate() error }) error {
+	if err := json.Unmarshal(payload, evt); err != nil {
+		field := "$"
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			field = typeErr.Field
+		}
+		v.invalid.Add(ctx, 1, metric.WithAttributes(attribute.String("topic", topic), attribute.String("field", field)))
+		return &ValidationError{Topic: topic, Fields: []FieldError{{Field: field, Reason: err.Error()}}}
+	}
+	return v.Validate(ctx, topic, evt)
+}
@@ -0,0 +1,53 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fields(t *testing.T, err error) []string {
+	t.Helper()
+	var vErr *ValidationError
+	require.True(t, errors.As(err, &vErr), "must be the validation error: %v", err)
+	names := make([]string, 0, len(vErr.Fields))
+	for _, f := range vErr.Fields {
+		names = append(names, f.Field)
+	}
+	return names
+}
+
+func TestMsgSentV1_Validate(t *testing.T) {
+	assert.NoError(t, sentV1.Validate())
+
+	invalid := sentV1
+	invalid.ChatRoomID = ""
+	invalid.User.ID = ""
+	invalid.Msg = strings.Repeat("a", MaxMsgLen+1)
+	err := invalid.Validate()
+	assert.Equal(t, []string{"chat_room_id", "user.id", "msg"}, fields(t, err))
+	assert.ErrorContains(t, err, "invalid msg_sent event: chat_room_id: is required")
+}
+
+func TestMsgStoredV1_Validate(t *testing.T) {
+	assert.NoError(t, NewMsgStored("d0a7b9c2-8790-11ee-b9d1-0242ac120002", 1, sentV1).Validate())
+	err := NewMsgStored("not-uuid", -1, MsgSent{}).Validate()
+	assert.Equal(t, []string{"chat_room_id", "user.id", "msg", "created_at", "msg_id", "seq"}, fields(t, err))
+}
+
+func TestValidator_Decode(t *testing.T) {
+	v, err := NewValidator()
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var evt MsgSent
+	assert.Equal(t, []string{"user.id"}, fields(t, v.Decode(ctx, TopicMsgSent, []byte(`{"user":{"id":1}}`), &evt)))
+	assert.Equal(t, []string{"$"}, fields(t, v.Decode(ctx, TopicMsgSent, []byte(`[]`), &evt)))
+	require.NoError(t, v.Decode(ctx, TopicMsgSent,
+		[]byte(`{"chat_room_id":"room-1","msg":"hello","user":{"id":"user-1"},"created_at":"2023-11-20T10:00:00Z"}`), &evt))
+	assert.Equal(t, "hello", evt.Msg)
+}
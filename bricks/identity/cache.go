@@ -0,0 +1,58 @@
+package identity
+
+import (
+	"sync"
+	"time"
+)
+
+type cachedProfile struct {
+	expiresAt time.Time
+	profile   Profile
+	found     bool
+}
+
+// Cache keeps the resolved profiles in memory for the TTL.
+// It holds up to the size users, the oldest one is evicted first, so the memory does not grow with the number of the authors.
+type Cache struct {
+	mu       sync.Mutex
+	profiles map[string]cachedProfile
+	keys     []string
+	next     int
+	ttl      time.Duration
+}
+
+// NewCache creates a new Cache of the size.
+func NewCache(size int, ttl time.Duration) *Cache {
+	if size <= 0 {
+		size = 1
+	}
+	return &Cache{profiles: make(map[string]cachedProfile, size), keys: make([]string, 0, size), ttl: ttl}
+}
+
+// Get returns the cached profile of the user. The found is false if the user is cached as the one without a profile,
+// the ok is false if the user is not cached or has expired.
+func (c *Cache) Get(userID string) (p Profile, found, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp, ok := c.profiles[userID]
+	if !ok || time.Now().After(cp.expiresAt) {
+		return Profile{}, false, false
+	}
+	return cp.profile, cp.found, true
+}
+
+// Set caches the profile of the user. The found is false for the user without a profile.
+func (c *Cache) Set(userID string, p Profile, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.profiles[userID]; !ok {
+		if len(c.keys) < cap(c.keys) {
+			c.keys = append(c.keys, userID)
+		} else {
+			delete(c.profiles, c.keys[c.next])
+			c.keys[c.next] = userID
+			c.next = (c.next + 1) % len(c.keys)
+		}
+	}
+	c.profiles[userID] = cachedProfile{profile: p, found: found, expiresAt: time.Now().Add(c.ttl)}
+}
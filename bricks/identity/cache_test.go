@@ -0,0 +1,33 @@
+package identity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache(2, time.Minute)
+	c.Set("u1", Profile{ID: "u1"}, true)
+	c.Set("u2", Profile{}, false)
+
+	p, found, ok := c.Get("u1")
+	assert.True(t, ok)
+	assert.True(t, found)
+	assert.Equal(t, "u1", p.ID)
+	_, found, ok = c.Get("u2")
+	assert.True(t, ok)
+	assert.False(t, found)
+
+	// the refreshed user keeps its place, the oldest one is evicted above the size
+	c.Set("u1", Profile{ID: "u1", FirstName: "Alice"}, true)
+	c.Set("u3", Profile{ID: "u3"}, true)
+	_, _, ok = c.Get("u1")
+	assert.False(t, ok)
+	_, _, ok = c.Get("u2")
+	assert.True(t, ok)
+	_, _, ok = c.Get("u3")
+	assert.True(t, ok)
+	assert.Len(t, c.profiles, 2)
+}
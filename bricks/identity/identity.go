@@ -0,0 +1,127 @@
+// Package identity keeps the public profiles of the Kratos identities,
+// so the messages carry only the user ID and the display data is resolved at read time.
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Profile is the public data of the user.
+type Profile struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	AvatarURL string    `json:"avatar_url,omitempty"`
+}
+
+// upsertScript sets the profile unless the user is erased, so a sync racing with the erasure does not bring the profile back.
+// KEYS[1] is the profile, KEYS[2] is the tombstone of the erased user, ARGV[1] is the encoded profile.
+var upsertScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+
+// Store keeps the profiles in Redis.
+// Every profile is a JSON string under the profile:<user id> key.
+// The erased users have a tombstone under the profile:erased:<user id> key, their profiles are never stored again.
+type Store struct {
+	rdb redis.UniversalClient
+}
+
+// NewStore creates a new Store.
+func NewStore(rdb redis.UniversalClient) *Store {
+	return &Store{rdb: rdb}
+}
+
+func key(userID string) string {
+	return "profile:" + userID
+}
+
+func erasedKey(userID string) string {
+	return "profile:erased:" + userID
+}
+
+// Get returns the stored profiles of the users. The users without a profile are missing in the result.
+func (s *Store) Get(ctx context.Context, userIDs ...string) (map[string]Profile, error) {
+	result := map[string]Profile{}
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+	keys := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		keys = append(keys, key(id))
+	}
+	vals, err := s.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed load profiles: %w", err)
+	}
+	for _, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var p Profile
+		if err := json.Unmarshal([]byte(str), &p); err != nil {
+			return nil, fmt.Errorf("failed decode profile: %w", err)
+		}
+		result[p.ID] = p
+	}
+	return result, nil
+}
+
+// Upsert creates or replaces the profiles. The profiles of the erased users are skipped.
+func (s *Store) Upsert(ctx context.Context, profiles ...Profile) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, prof := range profiles {
+			if prof.ID == "" {
+				return errors.New("profile id is empty")
+			}
+			b, err := json.Marshal(prof)
+			if err != nil {
+				return fmt.Errorf("failed encode profile: %w", err)
+			}
+			upsertScript.Eval(ctx, p, []string{key(prof.ID), erasedKey(prof.ID)}, b)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed save profiles: %w", err)
+	}
+	return nil
+}
+
+// Erase deletes the profile of the user and keeps the tombstone, so the profile is not synced or fetched from Kratos again.
+func (s *Store) Erase(ctx context.Context, userID string) error {
+	_, err := s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, erasedKey(userID), time.Now().UTC().Format(time.RFC3339), 0)
+		p.Del(ctx, key(userID))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed erase profile: %w", err)
+	}
+	return nil
+}
+
+// Erased reports whether the user is erased.
+func (s *Store) Erased(ctx context.Context, userID string) (bool, error) {
+	n, err := s.rdb.Exists(ctx, erasedKey(userID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed check erased profile: %w", err)
+	}
+	return n > 0, nil
+}
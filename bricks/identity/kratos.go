@@ -0,0 +1,133 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// kratosPageSize is the number of identities requested per page when all identities are listed.
+const kratosPageSize = 250
+
+// Kratos reads the profiles from the Kratos admin API.
+type Kratos struct {
+	client   *http.Client
+	adminURL string
+}
+
+type kratosIdentity struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	ID        string    `json:"id"`
+	Traits    struct {
+		Email string `json:"email"`
+		Name  struct {
+			First string `json:"first"`
+			Last  string `json:"last"`
+		} `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	} `json:"traits"`
+}
+
+func (i kratosIdentity) profile() Profile {
+	return Profile{
+		ID:        i.ID,
+		Email:     i.Traits.Email,
+		FirstName: i.Traits.Name.First,
+		LastName:  i.Traits.Name.Last,
+		AvatarURL: i.Traits.AvatarURL,
+		UpdatedAt: i.UpdatedAt,
+	}
+}
+
+// NewKratos creates a new Kratos client of the admin API, e.g. http://kratos:4434.
+func NewKratos(adminURL string, client *http.Client) *Kratos {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Kratos{client: client, adminURL: strings.TrimSuffix(adminURL, "/")}
+}
+
+// Get returns the profile of the identity. The bool is false if there is no such identity.
+func (k *Kratos) Get(ctx context.Context, userID string) (Profile, bool, error) {
+	var identity kratosIdentity
+	resp, err := k.get(ctx, "/admin/identities/"+url.PathEscape(userID), &identity)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return Profile{}, false, nil
+	}
+	if err != nil {
+		return Profile{}, false, err
+	}
+	return identity.profile(), true, nil
+}
+
+// ByEmail returns the profile of the identity with the email. The bool is false if there is no such identity.
+func (k *Kratos) ByEmail(ctx context.Context, email string) (Profile, bool, error) {
+	var identities []kratosIdentity
+	if _, err := k.get(ctx, "/admin/identities?credentials_identifier="+url.QueryEscape(email), &identities); err != nil {
+		return Profile{}, false, err
+	}
+	for _, i := range identities {
+		if strings.EqualFold(i.Traits.Email, email) {
+			return i.profile(), true, nil
+		}
+	}
+	return Profile{}, false, nil
+}
+
+// List returns a page of all identities. The empty page token requests the first page,
+// the empty next page token means there are no more pages.
+func (k *Kratos) List(ctx context.Context, pageToken string) ([]Profile, string, error) {
+	q := url.Values{"page_size": {fmt.Sprint(kratosPageSize)}}
+	if pageToken != "" {
+		q.Set("page_token", pageToken)
+	}
+	var identities []kratosIdentity
+	resp, err := k.get(ctx, "/admin/identities?"+q.Encode(), &identities)
+	if err != nil {
+		return nil, "", err
+	}
+	profiles := make([]Profile, 0, len(identities))
+	for _, i := range identities {
+		profiles = append(profiles, i.profile())
+	}
+	return profiles, nextPageToken(resp.Header.Get("Link")), nil
+}
+
+func (k *Kratos) get(ctx context.Context, path string, v any) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.adminURL+path, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed create kratos request: %w", err)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed request kratos: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("failed request kratos: unexpected status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return resp, fmt.Errorf("failed decode kratos response: %w", err)
+	}
+	return resp, nil
+}
+
+// nextPageToken extracts the page token of the rel="next" link of the Kratos pagination Link header.
+func nextPageToken(link string) string {
+	for _, l := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(l), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		u, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return ""
+		}
+		return u.Query().Get("page_token")
+	}
+	return ""
+}
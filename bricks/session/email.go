@@ -0,0 +1,73 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// EmailVisibility is how the emails of the message authors are shown to the non-admin viewers.
+// The history and ws-receiver share the policy, so the history and the live messages match.
+type EmailVisibility string
+
+const (
+	// EmailVisible shows the emails as they are.
+	EmailVisible EmailVisibility = "visible"
+	// EmailMasked shows only the first letter of the email local part and the domain, e.g. j***@example.com.
+	EmailMasked EmailVisibility = "masked"
+	// EmailHidden drops the emails.
+	EmailHidden EmailVisibility = "hidden"
+)
+
+// EmailConfig is the configuration of the email visibility.
+type EmailConfig struct {
+	Visibility EmailVisibility `default:"masked" json:"visibility"`
+	// AdminRole is the role in the Kratos identity public metadata, e.g. {"role": "admin"}, the emails are always visible to.
+	AdminRole string `default:"admin" split_words:"true" json:"admin_role"`
+}
+
+// Validate validates the configuration.
+func (c EmailConfig) Validate() error {
+	switch c.Visibility {
+	case EmailVisible, EmailMasked, EmailHidden:
+		return nil
+	default:
+		return fmt.Errorf("unsupported email visibility %q", c.Visibility)
+	}
+}
+
+// Email returns the email as it's shown to the viewer.
+func (c EmailConfig) Email(viewer Session, email string) string {
+	if email == "" || c.Visible(viewer) {
+		return email
+	}
+	if c.Visibility == EmailMasked {
+		return MaskEmail(email)
+	}
+	return ""
+}
+
+// Visible reports whether the emails are shown to the viewer as they are.
+func (c EmailConfig) Visible(viewer Session) bool {
+	return c.Visibility == EmailVisible || IsAdmin(viewer.Identity, c.AdminRole)
+}
+
+// IsAdmin reports whether the identity has the role in the public metadata.
+func IsAdmin(identity Identity, role string) bool {
+	md, ok := identity.MetadataPublic.(map[string]interface{})
+	if !ok || role == "" {
+		return false
+	}
+	r, _ := md["role"].(string)
+	return r == role
+}
+
+// MaskEmail keeps the first letter of the local part and the domain of the email.
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	_, size := utf8.DecodeRuneInString(local)
+	return local[:size] + "***@" + domain
+}
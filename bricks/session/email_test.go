@@ -0,0 +1,31 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailConfig_Email(t *testing.T) {
+	admin := Session{Identity: Identity{ID: "admin", MetadataPublic: map[string]interface{}{"role": "admin"}}}
+	user := Session{Identity: Identity{ID: "user"}}
+
+	tests := []struct {
+		name   string
+		cfg    EmailConfig
+		viewer Session
+		want   string
+	}{
+		{name: "masked", cfg: EmailConfig{Visibility: EmailMasked, AdminRole: "admin"}, viewer: user, want: "j***@example.com"},
+		{name: "hidden", cfg: EmailConfig{Visibility: EmailHidden, AdminRole: "admin"}, viewer: user, want: ""},
+		{name: "visible", cfg: EmailConfig{Visibility: EmailVisible, AdminRole: "admin"}, viewer: user, want: "john@example.com"},
+		{name: "admin", cfg: EmailConfig{Visibility: EmailHidden, AdminRole: "admin"}, viewer: admin, want: "john@example.com"},
+		{name: "no admin role", cfg: EmailConfig{Visibility: EmailHidden}, viewer: admin, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.cfg.Email(tt.viewer, "john@example.com"))
+		})
+	}
+	assert.Error(t, EmailConfig{Visibility: "public"}.Validate())
+}
@@ -0,0 +1,170 @@
+package watermillbrick
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-kafka/v2/pkg/kafka"
+	"github.com/ThreeDotsLabs/watermill-nats/v2/pkg/nats"
+	"github.com/ThreeDotsLabs/watermill-redisstream/pkg/redisstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	wotelfloss "github.com/dentech-floss/watermill-opentelemetry-go-extra/pkg/opentelemetry"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	BrokerRedis     = "redis"
+	BrokerNATS      = "nats"
+	BrokerKafka     = "kafka"
+	BrokerGoChannel = "gochannel"
+)
+
+// BrokerConfig is the message broker configuration.
+type BrokerConfig struct {
+	// Kind is one of redis, nats, kafka or gochannel.
+	// The gochannel broker keeps the messages in memory, so it only connects the publishers and the subscribers of one process.
+	// The services run as separate processes, so it's meant for the tests only.
+	Kind string `default:"redis" json:"kind"`
+	// NatsURL is the URL of the NATS server with JetStream enabled.
+	NatsURL string `default:"nats://localhost:4222" split_words:"true" json:"nats_url"`
+	// KafkaBrokers are the addresses of the Kafka brokers.
+	KafkaBrokers []string `default:"localhost:9092" split_words:"true" json:"kafka_brokers"`
+	// Redis is the configuration of the Redis streams.
+	Redis RedisStreamConfig `json:"redis"`
+}
+
+// Broker creates the publishers and the subscribers of the configured message broker.
+type Broker struct {
+	cfg    BrokerConfig
+	rdb    redis.UniversalClient
+	logger watermill.LoggerAdapter
+	goChan *gochannel.GoChannel
+}
+
+// NewBroker creates a new Broker. The Redis client is required by the redis broker only.
+func NewBroker(cfg BrokerConfig, rdb redis.UniversalClient, logger watermill.LoggerAdapter) (*Broker, error) {
+	b := &Broker{cfg: cfg, rdb: rdb, logger: logger}
+	switch cfg.Kind {
+	case BrokerRedis:
+		if rdb == nil {
+			return nil, fmt.Errorf("%s broker requires redis client", cfg.Kind)
+		}
+	case BrokerNATS, BrokerKafka:
+	case BrokerGoChannel:
+		b.goChan = gochannel.NewGoChannel(gochannel.Config{OutputChannelBuffer: 64}, logger)
+	default:
+		return nil, fmt.Errorf("unsupported message broker %q", cfg.Kind)
+	}
+	return b, nil
+}
+
+// Publisher creates the publisher decorated with the tracing, the metrics and the CloudEvents attributes.
+func (b *Broker) Publisher(cfg PubConfig) (*Publisher, error) {
+	var (
+		pub message.Publisher
+		err error
+	)
+	switch b.cfg.Kind {
+	case BrokerRedis:
+		pub, err = redisstream.NewPublisher(redisstream.PublisherConfig{Client: b.rdb, Maxlens: b.cfg.Redis.MaxLens}, b.logger)
+		if err == nil && b.cfg.Redis.MinIDAge > 0 {
+			pub = newMinIDTrimmingPublisher(pub, b.rdb, b.cfg.Redis.MinIDAge)
+		}
+	case BrokerNATS:
+		pub, err = nats.NewPublisher(nats.PublisherConfig{
+			URL:       b.cfg.NatsURL,
+			Marshaler: &nats.NATSMarshaler{},
+			JetStream: nats.JetStreamConfig{AutoProvision: true, TrackMsgId: true},
+		}, b.logger)
+	case BrokerKafka:
+		pub, err = kafka.NewPublisher(kafka.PublisherConfig{
+			Brokers: b.cfg.KafkaBrokers,
+			// the events of the same subject, e.g. the messages of the room, go to the same partition and keep their order
+			Marshaler: kafka.NewWithPartitioningMarshaler(func(_ string, msg *message.Message) (string, error) {
+				return msg.Metadata.Get(CESubjectKey), nil
+			}),
+		}, b.logger)
+	case BrokerGoChannel:
+		pub = b.goChan
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed create %s publisher: %w", b.cfg.Kind, err)
+	}
+	return NewPublisher(cfg, pub)
+}
+
+// Subscriber creates the subscriber of the consumer group decorated with the tracing.
+// The message of the topic is delivered to one subscriber of the group.
+// Every subscriber without the group receives all the messages of the topic, so does every gochannel subscriber.
+func (b *Broker) Subscriber(group string) (message.Subscriber, error) {
+	var (
+		sub message.Subscriber
+		err error
+	)
+	switch b.cfg.Kind {
+	case BrokerRedis:
+		sub, err = redisstream.NewSubscriber(redisstream.SubscriberConfig{Client: b.rdb, ConsumerGroup: group}, b.logger)
+	case BrokerNATS:
+		js := nats.JetStreamConfig{AutoProvision: true, AckAsync: true}
+		if group != "" {
+			js.DurablePrefix = group
+			js.DurableCalculator = func(prefix, topic string) string {
+				// the durable names must not contain dots
+				return prefix + "_" + strings.ReplaceAll(topic, ".", "_")
+			}
+		}
+		sub, err = nats.NewSubscriber(nats.SubscriberConfig{
+			URL:              b.cfg.NatsURL,
+			QueueGroupPrefix: group,
+			Unmarshaler:      &nats.NATSMarshaler{},
+			JetStream:        js,
+		}, b.logger)
+	case BrokerKafka:
+		sub, err = kafka.NewSubscriber(kafka.SubscriberConfig{
+			Brokers:       b.cfg.KafkaBrokers,
+			Unmarshaler:   kafka.DefaultMarshaler{},
+			ConsumerGroup: group,
+		}, b.logger)
+	case BrokerGoChannel:
+		sub = b.goChan
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed create %s subscriber: %w", b.cfg.Kind, err)
+	}
+	return tracingSubscriber{Subscriber: sub}, nil
+}
+
+// extractParentSpan sets the span context propagated by the Publisher as the remote parent of the message context.
+var extractParentSpan = wotelfloss.ExtractRemoteParentSpanContextHandler(func(*message.Message) ([]*message.Message, error) {
+	return nil, nil
+})
+
+// tracingSubscriber continues the trace of the publisher in the received messages,
+// so the handlers started from the message context are the children of the publish span.
+type tracingSubscriber struct {
+	message.Subscriber
+}
+
+func (s tracingSubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	msgs, err := s.Subscriber.Subscribe(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *message.Message)
+	go func() {
+		defer close(out)
+		for msg := range msgs {
+			// the message with the malformed trace is delivered without the parent
+			_, _ = extractParentSpan(msg)
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				msg.Nack()
+			}
+		}
+	}()
+	return out, nil
+}
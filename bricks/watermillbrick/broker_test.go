@@ -0,0 +1,55 @@
+package watermillbrick
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestBroker_GoChannel(t *testing.T) {
+	b, err := NewBroker(BrokerConfig{Kind: BrokerGoChannel}, nil, watermill.NopLogger{})
+	require.NoError(t, err)
+	pub, err := b.Publisher(PubConfig{Name: "test"})
+	require.NoError(t, err)
+	defer pub.Close()
+	sub, err := b.Subscriber("test")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msgs, err := sub.Subscribe(ctx, "topic")
+	require.NoError(t, err)
+
+	traceID := trace.TraceID{1}
+	spanCtx := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: trace.SpanID{1}, TraceFlags: trace.FlagsSampled})
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	msg.SetContext(trace.ContextWithSpanContext(ctx, spanCtx))
+	require.NoError(t, pub.Publish("topic", msg))
+
+	select {
+	case received := <-msgs:
+		received.Ack()
+		assert.Equal(t, msg.UUID, received.UUID)
+		ce, err := DecodeCloudEvent(received)
+		require.NoError(t, err)
+		assert.Equal(t, "/chat/test", ce.Source)
+		// the subscriber continues the trace of the publisher
+		assert.Equal(t, traceID, trace.SpanContextFromContext(received.Context()).TraceID())
+	case <-ctx.Done():
+		t.Fatal("message is not received")
+	}
+}
+
+func TestNewBroker_Unsupported(t *testing.T) {
+	_, err := NewBroker(BrokerConfig{Kind: "rabbitmq"}, nil, watermill.NopLogger{})
+	assert.ErrorContains(t, err, `unsupported message broker "rabbitmq"`)
+
+	_, err = NewBroker(BrokerConfig{Kind: BrokerRedis}, nil, watermill.NopLogger{})
+	assert.ErrorContains(t, err, "requires redis client")
+}
@@ -0,0 +1,126 @@
+package watermillbrick
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/chat/bricks/events"
+)
+
+// The CloudEvents attributes are carried in the message metadata with the "ce_" prefix (the binary content mode),
+// so the payload stays the event data the existing consumers decode.
+const (
+	CEIDKey              = "ce_id"
+	CESourceKey          = "ce_source"
+	CETypeKey            = "ce_type"
+	CESpecVersionKey     = "ce_specversion"
+	CETimeKey            = "ce_time"
+	CESubjectKey         = "ce_subject"
+	CEDataContentTypeKey = "ce_datacontenttype"
+)
+
+const (
+	// CESpecVersion is the CloudEvents specification version of the attributes.
+	CESpecVersion = "1.0"
+	// DefaultCETypePrefix is the prefix of the event type, the type is the prefix followed by the topic and the event version.
+	DefaultCETypePrefix = "com.github.demeero.chat"
+)
+
+// ErrNotCloudEvent is returned by the decoder when the message lacks the required CloudEvents attributes.
+var ErrNotCloudEvent = errors.New("not a cloud event")
+
+// CloudEvent is the CloudEvents envelope of the message.
+type CloudEvent struct {
+	ID              string
+	Source          string
+	Type            string
+	SpecVersion     string
+	Time            time.Time
+	Subject         string
+	DataContentType string
+	Data            []byte
+}
+
+// SetSubject sets the subject of the event, e.g. the chat room of the message.
+func SetSubject(md message.Metadata, subject string) {
+	md.Set(CESubjectKey, subject)
+}
+
+// SetTime sets the time the event occurred. The publisher sets the publishing time if it is not set.
+func SetTime(md message.Metadata, t time.Time) {
+	md.Set(CETimeKey, t.UTC().Format(time.RFC3339Nano))
+}
+
+// CEType returns the event type of the topic. The type includes the event version if it is known.
+func CEType(prefix, topic string, md message.Metadata) string {
+	typ := prefix + "." + topic
+	if v := md.Get(events.VersionKey); v != "" {
+		typ += ".v" + v
+	}
+	return typ
+}
+
+// setCloudEvent sets the attributes that are not set yet, so the republished messages keep the original ones.
+func setCloudEvent(msg *message.Message, source, typ string, now time.Time) {
+	setDefault := func(key, value string) {
+		if msg.Metadata.Get(key) == "" {
+			msg.Metadata.Set(key, value)
+		}
+	}
+	setDefault(CEIDKey, msg.UUID)
+	setDefault(CESourceKey, source)
+	setDefault(CETypeKey, typ)
+	setDefault(CESpecVersionKey, CESpecVersion)
+	setDefault(CETimeKey, now.UTC().Format(time.RFC3339Nano))
+	setDefault(CEDataContentTypeKey, "application/json")
+}
+
+// DecodeCloudEvent returns the CloudEvents envelope of the message.
+// It fails with ErrNotCloudEvent if any of the required attributes is missing.
+func DecodeCloudEvent(msg *message.Message) (CloudEvent, error) {
+	ce := CloudEvent{
+		ID:              msg.Metadata.Get(CEIDKey),
+		Source:          msg.Metadata.Get(CESourceKey),
+		Type:            msg.Metadata.Get(CETypeKey),
+		SpecVersion:     msg.Metadata.Get(CESpecVersionKey),
+		Subject:         msg.Metadata.Get(CESubjectKey),
+		DataContentType: msg.Metadata.Get(CEDataContentTypeKey),
+		Data:            msg.Payload,
+	}
+	var missing []string
+	for _, key := range []string{CEIDKey, CESourceKey, CETypeKey, CESpecVersionKey} {
+		if msg.Metadata.Get(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return CloudEvent{}, fmt.Errorf("%w: missing %s", ErrNotCloudEvent, strings.Join(missing, ", "))
+	}
+	if t := msg.Metadata.Get(CETimeKey); t != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return CloudEvent{}, fmt.Errorf("%w: invalid %s: %s", ErrNotCloudEvent, CETimeKey, err)
+		}
+		ce.Time = parsed
+	}
+	return ce, nil
+}
+
+// DecodeCloudEventData returns the CloudEvents envelope of the message and decodes its JSON data into v.
+func DecodeCloudEventData(msg *message.Message, v any) (CloudEvent, error) {
+	ce, err := DecodeCloudEvent(msg)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	if ce.DataContentType != "" && ce.DataContentType != "application/json" {
+		return CloudEvent{}, fmt.Errorf("unsupported data content type %q", ce.DataContentType)
+	}
+	if err := json.Unmarshal(ce.Data, v); err != nil {
+		return CloudEvent{}, fmt.Errorf("failed decode cloud event data: %w", err)
+	}
+	return ce, nil
+}
@@ -0,0 +1,79 @@
+package watermillbrick
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingPublisher struct {
+	msgs []*message.Message
+}
+
+func (p *recordingPublisher) Publish(_ string, msgs ...*message.Message) error {
+	p.msgs = append(p.msgs, msgs...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+func TestPublisher_CloudEvent(t *testing.T) {
+	rec := &recordingPublisher{}
+	pub, err := NewPublisher(PubConfig{Name: "ws-sender"}, rec)
+	require.NoError(t, err)
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{"chat_room_id":"room-1","msg":"hello"}`))
+	msg.SetContext(context.Background())
+	events.SetVersion(msg.Metadata, events.MsgSentVersion)
+	SetSubject(msg.Metadata, "room-1")
+	require.NoError(t, pub.Publish(events.TopicMsgSent, msg))
+	require.Len(t, rec.msgs, 1)
+
+	var data struct {
+		ChatRoomID string `json:"chat_room_id"`
+	}
+	ce, err := DecodeCloudEventData(rec.msgs[0], &data)
+	require.NoError(t, err)
+	assert.Equal(t, msg.UUID, ce.ID)
+	assert.Equal(t, "/chat/ws-sender", ce.Source)
+	assert.Equal(t, "com.github.demeero.chat.msg_sent.v1", ce.Type)
+	assert.Equal(t, CESpecVersion, ce.SpecVersion)
+	assert.Equal(t, "room-1", ce.Subject)
+	assert.Equal(t, "application/json", ce.DataContentType)
+	assert.WithinDuration(t, time.Now(), ce.Time, time.Minute)
+	assert.Equal(t, "room-1", data.ChatRoomID)
+}
+
+func TestPublisher_CloudEvent_Republished(t *testing.T) {
+	rec := &recordingPublisher{}
+	pub, err := NewPublisher(PubConfig{Name: "history-outbox-relay", Source: "/chat/history"}, rec)
+	require.NoError(t, err)
+
+	occurred := time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	msg.SetContext(context.Background())
+	msg.Metadata.Set(CEIDKey, "original-id")
+	SetTime(msg.Metadata, occurred)
+	require.NoError(t, pub.Publish(events.TopicMsgStored, msg))
+
+	ce, err := DecodeCloudEvent(rec.msgs[0])
+	require.NoError(t, err)
+	assert.Equal(t, "original-id", ce.ID, "the republished event must keep its attributes")
+	assert.Equal(t, occurred, ce.Time)
+	assert.Equal(t, "/chat/history", ce.Source)
+	assert.Equal(t, "com.github.demeero.chat.msg_stored", ce.Type)
+}
+
+func TestDecodeCloudEvent_Missing(t *testing.T) {
+	_, err := DecodeCloudEvent(message.NewMessage(watermill.NewUUID(), []byte(`{}`)))
+	assert.ErrorIs(t, err, ErrNotCloudEvent)
+	assert.ErrorContains(t, err, "ce_id, ce_source, ce_type, ce_specversion")
+}
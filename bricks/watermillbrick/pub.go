@@ -3,6 +3,7 @@ package watermillbrick
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 	wotelfloss "github.com/dentech-floss/watermill-opentelemetry-go-extra/pkg/opentelemetry"
@@ -18,6 +19,10 @@ type PubConfig struct {
 	Name                string
 	Metrics             bool
 	NewRootSpanWithLink bool
+	// Source is the CloudEvents source of the published events, "/chat/<Name>" by default.
+	Source string
+	// TypePrefix is the prefix of the CloudEvents type of the published events, DefaultCETypePrefix by default.
+	TypePrefix string
 }
 
 type Publisher struct {
@@ -34,6 +39,12 @@ func NewPublisher(cfg PubConfig, pub message.Publisher) (*Publisher, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create event_publish_count metric: %w", err)
 	}
+	if cfg.Source == "" {
+		cfg.Source = "/chat/" + cfg.Name
+	}
+	if cfg.TypePrefix == "" {
+		cfg.TypePrefix = DefaultCETypePrefix
+	}
 	return &Publisher{pub: pub, evtPublishCounter: counter, cfg: cfg}, nil
 }
 
@@ -54,6 +65,15 @@ func (p *Publisher) Publish(topic string, messages ...*message.Message) error {
 		}
 	}
 
+	now := time.Now()
+	for _, msg := range messages {
+		setCloudEvent(msg, p.cfg.Source, CEType(p.cfg.TypePrefix, topic, msg.Metadata), now)
+		msg.Metadata.Set(PublishedAtKey, publishedAt(now))
+		if msg.Metadata.Get(OriginPublishedAtKey) == "" {
+			msg.Metadata.Set(OriginPublishedAtKey, publishedAt(now))
+		}
+	}
+
 	err := p.pub.Publish(topic, messages...)
 	p.recordMetrics(ctx, topic, err)
 	return err
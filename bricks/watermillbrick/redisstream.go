@@ -0,0 +1,259 @@
+package watermillbrick
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// RedisStreamConfig is the configuration of the Redis streams of the redis broker.
+type RedisStreamConfig struct {
+	// MaxLens caps the length of the streams on publish, e.g. msg_sent:100000,msg_stored:100000.
+	// The trimming is approximate and the entries are trimmed even if a consumer group has not read them yet.
+	MaxLens map[string]int64 `split_words:"true" json:"max_lens"`
+	// MinIDAge trims the entries older than the age from the streams on publish, 0 keeps them.
+	MinIDAge time.Duration `split_words:"true" json:"min_id_age"`
+	// Streams are the streams the StreamMonitor reports and reclaims. The history writer adds its lane streams.
+	Streams []string `default:"msg_sent,msg_stored" json:"streams"`
+	// MonitorInterval is how often the StreamMonitor runs.
+	MonitorInterval time.Duration `default:"30s" split_words:"true" json:"monitor_interval"`
+	// DeadConsumerAfter is the idle time after which the consumer is treated as dead and its pending entries are reclaimed.
+	DeadConsumerAfter time.Duration `default:"10m" split_words:"true" json:"dead_consumer_after"`
+}
+
+const (
+	// minIDTrimInterval is how often a stream is trimmed by the MINID on publish at most.
+	minIDTrimInterval = time.Second
+	// claimBatchSize is the number of the pending entries of a dead consumer claimed at once.
+	claimBatchSize = 100
+)
+
+// minIDTrimmingPublisher trims the entries older than the age from the streams it publishes to.
+type minIDTrimmingPublisher struct {
+	message.Publisher
+	rdb redis.UniversalClient
+	age time.Duration
+
+	mu      sync.Mutex
+	trimmed map[string]time.Time
+}
+
+func newMinIDTrimmingPublisher(pub message.Publisher, rdb redis.UniversalClient, age time.Duration) *minIDTrimmingPublisher {
+	return &minIDTrimmingPublisher{Publisher: pub, rdb: rdb, age: age, trimmed: map[string]time.Time{}}
+}
+
+func (p *minIDTrimmingPublisher) Publish(topic string, msgs ...*message.Message) error {
+	if err := p.Publisher.Publish(topic, msgs...); err != nil {
+		return err
+	}
+	now := time.Now()
+	p.mu.Lock()
+	due := now.Sub(p.trimmed[topic]) >= minIDTrimInterval
+	if due {
+		p.trimmed[topic] = now
+	}
+	p.mu.Unlock()
+	if !due {
+		return nil
+	}
+	minID := strconv.FormatInt(now.Add(-p.age).UnixMilli(), 10) + "-0"
+	if err := p.rdb.XTrimMinIDApprox(context.Background(), topic, minID, 0).Err(); err != nil {
+		// the messages are published, the stream is trimmed on the next publish
+		slog.Warn("failed trim redis stream", slog.String("stream", topic), slog.Any("err", err))
+	}
+	return nil
+}
+
+type groupStats struct {
+	stream, group string
+	pending, lag  int64
+}
+
+// StreamMonitor reports the length of the Redis streams and the backlog of their consumer groups,
+// and reclaims the pending entries of the dead consumers.
+type StreamMonitor struct {
+	rdb       redis.UniversalClient
+	cfg       RedisStreamConfig
+	reclaimed metric.Int64Counter
+
+	mu      sync.Mutex
+	lengths map[string]int64
+	groups  []groupStats
+}
+
+// NewStreamMonitor creates a new StreamMonitor.
+func NewStreamMonitor(rdb redis.UniversalClient, cfg RedisStreamConfig) (*StreamMonitor, error) {
+	m := &StreamMonitor{rdb: rdb, cfg: cfg, lengths: map[string]int64{}}
+	meter := otel.GetMeterProvider().Meter("bricks/watermillbrick/redisstream")
+	length, err := meter.Int64ObservableGauge("redis_stream_length", metric.WithDescription("The number of the entries in the stream"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create redis_stream_length metric: %w", err)
+	}
+	pending, err := meter.Int64ObservableGauge("redis_stream_group_pending",
+		metric.WithDescription("The number of the entries delivered to the consumer group and not acked yet"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create redis_stream_group_pending metric: %w", err)
+	}
+	lag, err := meter.Int64ObservableGauge("redis_stream_group_lag",
+		metric.WithDescription("The number of the entries not delivered to the consumer group yet"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create redis_stream_group_lag metric: %w", err)
+	}
+	m.reclaimed, err = meter.Int64Counter("redis_stream_reclaimed_count",
+		metric.WithDescription("The number of the pending entries reclaimed from the dead consumers"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create redis_stream_reclaimed_count metric: %w", err)
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for stream, l := range m.lengths {
+			o.ObserveInt64(length, l, metric.WithAttributes(semconv.MessagingDestinationName(stream)))
+		}
+		for _, g := range m.groups {
+			attrs := metric.WithAttributes(semconv.MessagingDestinationName(g.stream), attribute.String("group", g.group))
+			o.ObserveInt64(pending, g.pending, attrs)
+			o.ObserveInt64(lag, g.lag, attrs)
+		}
+		return nil
+	}, length, pending, lag)
+	if err != nil {
+		return nil, fmt.Errorf("failed register redis stream metrics callback: %w", err)
+	}
+	return m, nil
+}
+
+// Run runs the StreamMonitor every MonitorInterval until the context is done.
+func (m *StreamMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.MonitorInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.Collect(ctx); err != nil {
+			slog.Error("failed collect redis streams", slog.Any("err", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect collects the stats of the streams and reclaims the pending entries of the dead consumers.
+// The streams that do not exist yet are skipped.
+func (m *StreamMonitor) Collect(ctx context.Context) error {
+	lengths := map[string]int64{}
+	var groups []groupStats
+	for _, stream := range m.cfg.Streams {
+		l, err := m.rdb.XLen(ctx, stream).Result()
+		if err != nil {
+			return fmt.Errorf("failed get length of %s stream: %w", stream, err)
+		}
+		if l == 0 {
+			continue
+		}
+		lengths[stream] = l
+		xgs, err := m.rdb.XInfoGroups(ctx, stream).Result()
+		if err != nil {
+			return fmt.Errorf("failed get groups of %s stream: %w", stream, err)
+		}
+		for _, xg := range xgs {
+			groups = append(groups, groupStats{stream: stream, group: xg.Name, pending: xg.Pending, lag: xg.Lag})
+			if err := m.reclaim(ctx, stream, xg.Name); err != nil {
+				return err
+			}
+		}
+	}
+	m.mu.Lock()
+	m.lengths, m.groups = lengths, groups
+	m.mu.Unlock()
+	return nil
+}
+
+// reclaim hands the pending entries of the dead consumers of the group over to its most recently active consumer,
+// which processes them when its subscriber claims its idle entries. The dead consumers left without entries are deleted.
+func (m *StreamMonitor) reclaim(ctx context.Context, stream, group string) error {
+	consumers, err := m.rdb.XInfoConsumers(ctx, stream, group).Result()
+	if err != nil {
+		return fmt.Errorf("failed get consumers of %s group: %w", group, err)
+	}
+	var (
+		dead []redis.XInfoConsumer
+		live *redis.XInfoConsumer
+	)
+	for i, c := range consumers {
+		if c.Idle >= m.cfg.DeadConsumerAfter {
+			dead = append(dead, c)
+			continue
+		}
+		if live == nil || c.Idle < live.Idle {
+			live = &consumers[i]
+		}
+	}
+	for _, c := range dead {
+		if c.Pending > 0 && live != nil {
+			n, err := m.claim(ctx, stream, group, c.Name, live.Name)
+			if err != nil {
+				return err
+			}
+			m.reclaimed.Add(ctx, n, metric.WithAttributes(semconv.MessagingDestinationName(stream), attribute.String("group", group)))
+			c.Pending -= n
+		}
+		if c.Pending > 0 {
+			continue
+		}
+		if err := m.rdb.XGroupDelConsumer(ctx, stream, group, c.Name).Err(); err != nil {
+			return fmt.Errorf("failed delete %s consumer: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m *StreamMonitor) claim(ctx context.Context, stream, group, from, to string) (int64, error) {
+	var claimed int64
+	for {
+		xps, err := m.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   stream,
+			Group:    group,
+			Start:    "-",
+			End:      "+",
+			Count:    claimBatchSize,
+			Consumer: from,
+		}).Result()
+		if err != nil {
+			return claimed, fmt.Errorf("failed get pending entries of %s consumer: %w", from, err)
+		}
+		if len(xps) == 0 {
+			return claimed, nil
+		}
+		ids := make([]string, 0, len(xps))
+		for _, xp := range xps {
+			ids = append(ids, xp.ID)
+		}
+		// the entries of a dead consumer are idle for long, so the ones delivered again meanwhile are not taken over
+		n, err := m.rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    group,
+			Consumer: to,
+			MinIdle:  m.cfg.DeadConsumerAfter,
+			Messages: ids,
+		}).Result()
+		if err != nil {
+			return claimed, fmt.Errorf("failed claim pending entries of %s consumer: %w", from, err)
+		}
+		claimed += int64(len(n))
+		if len(n) < len(ids) || len(xps) < claimBatchSize {
+			return claimed, nil
+		}
+	}
+}
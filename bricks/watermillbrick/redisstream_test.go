@@ -0,0 +1,91 @@
+package watermillbrick
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestBroker_RedisMinIDTrimming(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	require.NoError(t, rdb.XAdd(ctx, &redis.XAddArgs{Stream: "msg_sent", ID: "1-1", Values: map[string]any{"payload": "old"}}).Err())
+
+	b, err := NewBroker(BrokerConfig{Kind: BrokerRedis, Redis: RedisStreamConfig{MinIDAge: time.Hour}}, rdb, watermill.NopLogger{})
+	require.NoError(t, err)
+	pub, err := b.Publisher(PubConfig{Name: "test"})
+	require.NoError(t, err)
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	msg.SetContext(ctx)
+	require.NoError(t, pub.Publish("msg_sent", msg))
+
+	l, err := rdb.XLen(ctx, "msg_sent").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), l, "the entries older than the age must be trimmed")
+}
+
+func TestStreamMonitor_Collect(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	defer otel.SetMeterProvider(sdkmetric.NewMeterProvider())
+
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	read := func(consumer string) {
+		id, err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: "msg_sent", Values: map[string]any{"payload": consumer}}).Result()
+		require.NoError(t, err)
+		require.NoError(t, rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group: "history-writer", Consumer: consumer, Streams: []string{"msg_sent", ">"}, Count: 1, Block: -1,
+		}).Err())
+		// miniredis tracks the idle time of the consumers on XCLAIM only
+		require.NoError(t, rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream: "msg_sent", Group: "history-writer", Consumer: consumer, Messages: []string{id},
+		}).Err())
+	}
+	now := time.Now()
+	mr.SetTime(now)
+	require.NoError(t, rdb.XGroupCreateMkStream(ctx, "msg_sent", "history-writer", "0").Err())
+	read("dead")
+	mr.SetTime(now.Add(time.Hour))
+	read("live")
+
+	m, err := NewStreamMonitor(rdb, RedisStreamConfig{Streams: []string{"msg_sent", "msg_stored"}, DeadConsumerAfter: 10 * time.Minute})
+	require.NoError(t, err)
+	require.NoError(t, m.Collect(ctx))
+
+	consumers, err := rdb.XInfoConsumers(ctx, "msg_sent", "history-writer").Result()
+	require.NoError(t, err)
+	require.Len(t, consumers, 1, "the dead consumer must be deleted")
+	assert.Equal(t, "live", consumers[0].Name)
+	assert.Equal(t, int64(2), consumers[0].Pending, "the pending entry of the dead consumer must be handed over")
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+	got := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, mt := range sm.Metrics {
+			got[mt.Name] = mt.Data
+		}
+	}
+	length := got["redis_stream_length"].(metricdata.Gauge[int64])
+	require.Len(t, length.DataPoints, 1, "the missing stream must be skipped")
+	assert.Equal(t, int64(2), length.DataPoints[0].Value)
+	pending := got["redis_stream_group_pending"].(metricdata.Gauge[int64])
+	require.Len(t, pending.DataPoints, 1)
+	assert.Equal(t, int64(2), pending.DataPoints[0].Value)
+	assert.Contains(t, got, "redis_stream_group_lag")
+	reclaimed := got["redis_stream_reclaimed_count"].(metricdata.Sum[int64])
+	require.Len(t, reclaimed.DataPoints, 1)
+	assert.Equal(t, int64(1), reclaimed.DataPoints[0].Value)
+}
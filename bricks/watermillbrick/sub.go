@@ -0,0 +1,165 @@
+package watermillbrick
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+const (
+	// PublishedAtKey is the metadata key of the time the message was published, in Unix milliseconds.
+	// It is set by the Publisher on every publish, so the republished message carries the time of its last hop.
+	PublishedAtKey = "published_at"
+	// OriginPublishedAtKey is the metadata key of the time the message was first published, in Unix milliseconds.
+	// It is kept by the Publisher, so the message republished with its metadata, e.g. routed to the writer lanes,
+	// carries the time of its first hop.
+	OriginPublishedAtKey = "origin_published_at"
+)
+
+// seenSize is the number of the recent message UUIDs remembered per handler to detect the redeliveries.
+const seenSize = 10000
+
+// SubMetrics records the metrics of the messages processed by the handlers.
+type SubMetrics struct {
+	duration    metric.Int64Histogram
+	processed   metric.Int64Counter
+	redelivered metric.Int64Counter
+	age         metric.Int64Histogram
+}
+
+// NewSubMetrics creates a new SubMetrics.
+func NewSubMetrics() (*SubMetrics, error) {
+	meter := otel.GetMeterProvider().Meter("bricks/watermillbrick/sub")
+	duration, err := meter.Int64Histogram("event_process_duration",
+		metric.WithDescription("The time the handler processes the event"), metric.WithUnit("ms"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create event_process_duration metric: %w", err)
+	}
+	processed, err := meter.Int64Counter("event_process_count", metric.WithDescription("The number of events processed"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create event_process_count metric: %w", err)
+	}
+	redelivered, err := meter.Int64Counter("event_redelivery_count",
+		metric.WithDescription("The number of events received again after they failed or were not acked in time"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create event_redelivery_count metric: %w", err)
+	}
+	age, err := meter.Int64Histogram("event_age",
+		metric.WithDescription("The time since the event was first published until it is received"), metric.WithUnit("ms"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create event_age metric: %w", err)
+	}
+	return &SubMetrics{
+		duration:    duration,
+		processed:   processed,
+		redelivered: redelivered,
+		age:         age,
+	}, nil
+}
+
+// RouterMiddleware records the metrics of the router handlers, the topic is the one the handler subscribed to.
+func (m *SubMetrics) RouterMiddleware(h message.HandlerFunc) message.HandlerFunc {
+	return m.Middleware("")(h)
+}
+
+// Middleware records the metrics of the messages of the topic. The empty topic is taken from the router context.
+// The redeliveries are detected per handler, so the same message delivered to several handlers is not a redelivery.
+func (m *SubMetrics) Middleware(topic string) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return m.handler(topic, newRecentSet(seenSize), h)
+	}
+}
+
+// FanOutMiddleware records the metrics of the messages of the topic, except the redeliveries.
+// It's meant for the subscribers without a consumer group, which receive every message once per subscription,
+// so it keeps no state per handler and is built once for all of them.
+func (m *SubMetrics) FanOutMiddleware(topic string) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return m.handler(topic, nil, h)
+	}
+}
+
+// handler records the metrics of the handler. The redeliveries are not detected if seen is nil.
+func (m *SubMetrics) handler(topic string, seen *recentSet, h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		ctx := msg.Context()
+		t := topic
+		if t == "" {
+			t = message.SubscribeTopicFromCtx(ctx)
+		}
+		topicAttr := semconv.MessagingDestinationName(t)
+		received := time.Now()
+		if publishedAt, ok := originPublishedAt(msg.Metadata); ok {
+			m.age.Record(ctx, received.Sub(publishedAt).Milliseconds(), metric.WithAttributes(topicAttr))
+		}
+		if seen != nil && seen.add(msg.UUID) {
+			m.redelivered.Add(ctx, 1, metric.WithAttributes(topicAttr))
+		}
+
+		msgs, err := h(msg)
+
+		statusAttr := semconv.OTelStatusCodeOk
+		if err != nil {
+			statusAttr = semconv.OTelStatusCodeError
+		}
+		m.duration.Record(ctx, time.Since(received).Milliseconds(), metric.WithAttributes(topicAttr, statusAttr))
+		m.processed.Add(ctx, 1, metric.WithAttributes(topicAttr, statusAttr))
+		return msgs, err
+	}
+}
+
+// originPublishedAt returns the time the message was first published.
+// The messages published before the OriginPublishedAtKey was set fall back to the time of their last hop.
+func originPublishedAt(md message.Metadata) (time.Time, bool) {
+	v := md.Get(OriginPublishedAtKey)
+	if v == "" {
+		v = md.Get(PublishedAtKey)
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+// recentSet remembers the last added keys.
+type recentSet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+	ring []string
+	size int
+	next int
+}
+
+func newRecentSet(size int) *recentSet {
+	return &recentSet{keys: map[string]struct{}{}, size: size}
+}
+
+// add adds the key and reports whether it has been added recently.
+func (s *recentSet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return true
+	}
+	s.keys[key] = struct{}{}
+	if len(s.ring) < s.size {
+		s.ring = append(s.ring, key)
+		return false
+	}
+	delete(s.keys, s.ring[s.next])
+	s.ring[s.next] = key
+	s.next = (s.next + 1) % s.size
+	return false
+}
+
+// publishedAt returns the value of the PublishedAtKey metadata.
+func publishedAt(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
@@ -0,0 +1,83 @@
+package watermillbrick
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestSubMetrics_Middleware(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	defer otel.SetMeterProvider(sdkmetric.NewMeterProvider())
+
+	m, err := NewSubMetrics()
+	require.NoError(t, err)
+	fail := true
+	h := m.Middleware("msg_sent")(func(msg *message.Message) ([]*message.Message, error) {
+		if fail {
+			fail = false
+			return nil, errors.New("failed")
+		}
+		return nil, nil
+	})
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	// the age is measured since the first hop, the last one is just now
+	msg.Metadata.Set(OriginPublishedAtKey, strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10))
+	msg.Metadata.Set(PublishedAtKey, strconv.FormatInt(time.Now().UnixMilli(), 10))
+	_, err = h(msg)
+	require.Error(t, err)
+	// the nacked message is delivered again
+	_, err = h(msg)
+	require.NoError(t, err)
+	// the fan-out subscribers receive the same message once per subscription, it's not a redelivery
+	fanOut := m.FanOutMiddleware("msg_stored")
+	for i := 0; i < 2; i++ {
+		_, err = fanOut(func(*message.Message) ([]*message.Message, error) { return nil, nil })(msg)
+		require.NoError(t, err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	got := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, mt := range sm.Metrics {
+			got[mt.Name] = mt.Data
+		}
+	}
+
+	processed := got["event_process_count"].(metricdata.Sum[int64])
+	assert.Len(t, processed.DataPoints, 3, "the successes and the failures are counted apart")
+	redelivered := got["event_redelivery_count"].(metricdata.Sum[int64])
+	require.Len(t, redelivered.DataPoints, 1)
+	assert.Equal(t, int64(1), redelivered.DataPoints[0].Value)
+	age := got["event_age"].(metricdata.Histogram[int64])
+	require.Len(t, age.DataPoints, 2)
+	for _, dp := range age.DataPoints {
+		assert.Equal(t, uint64(2), dp.Count)
+		minAge, _ := dp.Min.Value()
+		assert.GreaterOrEqual(t, minAge, int64(1000))
+	}
+	assert.Contains(t, got, "event_process_duration")
+}
+
+func TestRecentSet(t *testing.T) {
+	s := newRecentSet(2)
+	assert.False(t, s.add("a"))
+	assert.False(t, s.add("b"))
+	assert.True(t, s.add("a"))
+	assert.False(t, s.add("c"))
+	assert.False(t, s.add("a"), "the oldest key must be forgotten")
+	assert.True(t, s.add("c"))
+}
@@ -0,0 +1,120 @@
+package wschat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/net/websocket"
+)
+
+// Protocol is the websocket subprotocol negotiated by the Sec-WebSocket-Protocol header.
+// The protocols carry the same envelopes, they differ in the encoding only.
+type Protocol string
+
+const (
+	// ProtocolJSON sends the envelopes as the JSON text frames. It's used for the clients that don't ask for a protocol.
+	ProtocolJSON Protocol = "chat.v1.json"
+	// ProtocolMsgpack sends the envelopes as the MessagePack binary frames with the same field names as the JSON ones.
+	ProtocolMsgpack Protocol = "chat.v1.msgpack"
+)
+
+// Server returns the websocket server of the handler which negotiates the protocol on the handshake.
+func Server(h websocket.Handler) websocket.Server {
+	return websocket.Server{Handler: h, Handshake: Handshake}
+}
+
+// Handshake checks the origin as the websocket.Handler does and picks the first protocol offered by the client the chat supports.
+// The client which offers only the unsupported protocols is rejected.
+func Handshake(cfg *websocket.Config, req *http.Request) error {
+	var err error
+	cfg.Origin, err = websocket.Origin(cfg, req)
+	if err != nil {
+		return err
+	}
+	if cfg.Origin == nil {
+		return fmt.Errorf("null origin")
+	}
+	if len(cfg.Protocol) == 0 {
+		return nil
+	}
+	for _, p := range cfg.Protocol {
+		if Protocol(p) == ProtocolJSON || Protocol(p) == ProtocolMsgpack {
+			cfg.Protocol = []string{p}
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported websocket protocols %v", cfg.Protocol)
+}
+
+// ProtocolOf returns the protocol negotiated for the connection.
+func ProtocolOf(ws *websocket.Conn) Protocol {
+	if p := ws.Config().Protocol; len(p) == 1 && Protocol(p[0]) == ProtocolMsgpack {
+		return ProtocolMsgpack
+	}
+	return ProtocolJSON
+}
+
+// Encode encodes the JSON envelope as the frame of the protocol.
+// The frame is sent to any connection of the protocol, so the envelope delivered to many connections is encoded once.
+func (p Protocol) Encode(envelope []byte) ([]byte, error) {
+	if p != ProtocolMsgpack {
+		return envelope, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(envelope))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed decode envelope: %w", err)
+	}
+	b, err := msgpack.Marshal(fromJSON(v))
+	if err != nil {
+		return nil, fmt.Errorf("failed encode envelope: %w", err)
+	}
+	return b, nil
+}
+
+// Send sends the frame encoded by Encode.
+func (p Protocol) Send(ws *websocket.Conn, frame []byte) error {
+	if p != ProtocolMsgpack {
+		return websocket.Message.Send(ws, string(frame))
+	}
+	return websocket.Message.Send(ws, frame)
+}
+
+// Receive receives the envelope and decodes it into v by its JSON field names.
+func (p Protocol) Receive(ws *websocket.Conn, v any) error {
+	if p != ProtocolMsgpack {
+		return websocket.JSON.Receive(ws, v)
+	}
+	var frame []byte
+	if err := websocket.Message.Receive(ws, &frame); err != nil {
+		return err
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(frame))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// fromJSON converts the JSON numbers to the integers where possible, so the sequence numbers stay integers in MessagePack.
+func fromJSON(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = fromJSON(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = fromJSON(e)
+		}
+	}
+	return v
+}
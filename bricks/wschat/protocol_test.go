@@ -0,0 +1,74 @@
+package wschat
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/net/websocket"
+)
+
+func TestProtocol(t *testing.T) {
+	srv := httptest.NewServer(Server(func(ws *websocket.Conn) {
+		proto := ProtocolOf(ws)
+		var evt wsMsgEvt
+		if err := proto.Receive(ws, &evt); err != nil {
+			return
+		}
+		frame, err := proto.Encode([]byte(`{"seq":3,"msg":"` + evt.Msg + `","user":{"id":"1"}}`))
+		if err != nil {
+			return
+		}
+		_ = proto.Send(ws, frame)
+	}))
+	defer srv.Close()
+	dial := func(protocols ...string) (*websocket.Conn, error) {
+		cfg, err := websocket.NewConfig("ws"+strings.TrimPrefix(srv.URL, "http"), srv.URL)
+		require.NoError(t, err)
+		cfg.Protocol = protocols
+		return websocket.DialConfig(cfg)
+	}
+
+	t.Run("msgpack", func(t *testing.T) {
+		ws, err := dial("chat.v2.cbor", string(ProtocolMsgpack), string(ProtocolJSON))
+		require.NoError(t, err)
+		defer ws.Close()
+		assert.Equal(t, []string{string(ProtocolMsgpack)}, ws.Config().Protocol)
+
+		b, err := msgpack.Marshal(map[string]any{"chat_room_id": "room", "msg": "hi"})
+		require.NoError(t, err)
+		require.NoError(t, websocket.Message.Send(ws, b))
+		var frame []byte
+		require.NoError(t, websocket.Message.Receive(ws, &frame))
+		var got struct {
+			Seq  int64  `msgpack:"seq"`
+			Msg  string `msgpack:"msg"`
+			User struct {
+				ID string `msgpack:"id"`
+			} `msgpack:"user"`
+		}
+		require.NoError(t, msgpack.Unmarshal(frame, &got))
+		assert.Equal(t, int64(3), got.Seq)
+		assert.Equal(t, "hi", got.Msg)
+		assert.Equal(t, "1", got.User.ID)
+	})
+
+	t.Run("json by default", func(t *testing.T) {
+		ws, err := dial()
+		require.NoError(t, err)
+		defer ws.Close()
+
+		require.NoError(t, websocket.JSON.Send(ws, wsMsgEvt{Msg: "hi"}))
+		var frame string
+		require.NoError(t, websocket.Message.Receive(ws, &frame))
+		assert.JSONEq(t, `{"seq":3,"msg":"hi","user":{"id":"1"}}`, frame)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := dial("chat.v2.cbor")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,116 @@
+// Package wschat serves the chat over the websocket connections.
+package wschat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/demeero/chat/bricks/session"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"golang.org/x/net/websocket"
+)
+
+type wsMsgEvt struct {
+	PendingID  string `json:"pending_id"`
+	ChatRoomID string `json:"chat_room_id"`
+	Msg        string `json:"msg"`
+}
+
+// errorFrame is sent back to the client whose message is rejected. The pending_id is the one of the rejected message,
+// the fields are the reasons the message is invalid. The message frames have no error key, so the client tells them apart.
+type errorFrame struct {
+	Error frameError `json:"error"`
+}
+
+type frameError struct {
+	PendingID string       `json:"pending_id"`
+	Message   string       `json:"message"`
+	Fields    []fieldError `json:"fields,omitempty"`
+}
+
+type fieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Sender publishes the messages the user sends over the websocket as the msg_sent events.
+type Sender struct {
+	Topic     string
+	Sess      session.Session
+	Pub       message.Publisher
+	Validator *events.Validator
+}
+
+// Execute receives the messages in the negotiated protocol until the connection is closed.
+func (s Sender) Execute(ws *websocket.Conn) {
+	proto := ProtocolOf(ws)
+	for {
+		var wsEvt wsMsgEvt
+		err := proto.Receive(ws, &wsEvt)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		lg := slogbrick.FromCtx(ws.Request().Context())
+		if err != nil {
+			lg.Debug("failed receive ws evt", slog.Any("err", err))
+			return
+		}
+		lg.Debug("received ws evt", slog.Any("evt", wsEvt))
+		err = s.publish(ws.Request().Context(), wsEvt)
+		var vErr *events.ValidationError
+		if errors.As(err, &vErr) {
+			// the invalid message is dropped before it enters the stream, the connection is kept for the next ones
+			lg.Warn("invalid ws evt - skip", slog.Any("err", err), slog.String("pending_id", wsEvt.PendingID))
+			if err := sendValidationError(ws, proto, wsEvt.PendingID, vErr); err != nil {
+				lg.Debug("failed send error frame to ws", slog.Any("err", err))
+				return
+			}
+			continue
+		}
+		if err != nil {
+			lg.Error("failed publish evt", slog.Any("err", err))
+			return
+		}
+	}
+}
+
+func (s Sender) publish(ctx context.Context, evt wsMsgEvt) error {
+	sentEvt := events.NewMsgSent(evt.ChatRoomID, evt.PendingID, evt.Msg, s.Sess.Identity.ID)
+	if err := s.Validator.Validate(ctx, s.Topic, sentEvt); err != nil {
+		return err
+	}
+	b, err := json.Marshal(sentEvt)
+	if err != nil {
+		return fmt.Errorf("failed encode evt: %w", err)
+	}
+	m := message.NewMessage(watermill.NewUUID(), b)
+	events.SetVersion(m.Metadata, events.MsgSentVersion)
+	watermillbrick.SetSubject(m.Metadata, sentEvt.ChatRoomID)
+	events.SetSentAt(m.Metadata, sentEvt.CreatedAt)
+	m.SetContext(ctx)
+	return s.Pub.Publish(s.Topic, m)
+}
+
+// sendValidationError tells the client why its message is rejected.
+func sendValidationError(ws *websocket.Conn, proto Protocol, pendingID string, vErr *events.ValidationError) error {
+	frame := errorFrame{Error: frameError{PendingID: pendingID, Message: "invalid message"}}
+	for _, f := range vErr.Fields {
+		frame.Error.Fields = append(frame.Error.Fields, fieldError{Field: f.Field, Reason: f.Reason})
+	}
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return fmt.Errorf("failed encode error frame: %w", err)
+	}
+	if b, err = proto.Encode(b); err != nil {
+		return err
+	}
+	return proto.Send(ws, b)
+}
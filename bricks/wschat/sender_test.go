@@ -0,0 +1,67 @@
+package wschat
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/demeero/chat/bricks/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+type chanPublisher chan *message.Message
+
+func (p chanPublisher) Publish(_ string, msgs ...*message.Message) error {
+	for _, msg := range msgs {
+		p <- msg
+	}
+	return nil
+}
+
+func (p chanPublisher) Close() error {
+	return nil
+}
+
+func TestSender_Execute(t *testing.T) {
+	v, err := events.NewValidator()
+	require.NoError(t, err)
+	pub := make(chanPublisher, 2)
+	done := make(chan struct{})
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		defer close(done)
+		sess := session.Session{}
+		sess.Identity.ID = "9f0e1d2c-3b4a-4596-8778-695a4b3c2d1e"
+		Sender{Topic: events.TopicMsgSent, Sess: sess, Pub: pub, Validator: v}.Execute(ws)
+	}))
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	require.NoError(t, err)
+	// the invalid message is dropped with the error frame and the connection is kept
+	require.NoError(t, websocket.JSON.Send(ws, wsMsgEvt{PendingID: "1", ChatRoomID: "room"}))
+	var frame struct {
+		Error struct {
+			PendingID string       `json:"pending_id"`
+			Fields    []fieldError `json:"fields"`
+		} `json:"error"`
+	}
+	require.NoError(t, websocket.JSON.Receive(ws, &frame))
+	assert.Equal(t, "1", frame.Error.PendingID)
+	assert.Equal(t, []fieldError{{Field: "msg", Reason: "is required"}}, frame.Error.Fields)
+	require.NoError(t, websocket.JSON.Send(ws, wsMsgEvt{PendingID: "2", ChatRoomID: "room", Msg: "hi"}))
+	require.NoError(t, ws.Close())
+	<-done
+
+	require.Len(t, pub, 1)
+	msg := <-pub
+	evt := events.MsgSent{}
+	require.NoError(t, v.Decode(msg.Context(), events.TopicMsgSent, msg.Payload, &evt))
+	assert.Equal(t, "2", evt.PendingID)
+	sentAt, ok := events.SentAt(msg.Metadata)
+	require.True(t, ok)
+	assert.Equal(t, evt.CreatedAt.UnixMilli(), sentAt.UnixMilli())
+}
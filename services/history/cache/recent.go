@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/demeero/chat/history/loader"
+	"github.com/redis/go-redis/v9"
+)
+
+// Config is the configuration of the recent messages cache.
+type Config struct {
+	// Size is the maximum number of the latest messages kept per room.
+	Size int `default:"100" json:"size"`
+	// TTL is the time the messages of an inactive room are kept in the cache.
+	TTL     time.Duration `default:"24h" json:"ttl"`
+	Enabled bool          `default:"true" json:"enabled"`
+}
+
+// Recent keeps the latest messages of every room in Redis.
+// The message IDs are kept in a sorted set scored by the creation time and the messages in a hash by the ID,
+// so the order does not depend on the delivery order and the message pushed again, e.g. redelivered or filled from the store,
+// replaces the cached one instead of being duplicated.
+type Recent struct {
+	rdb redis.UniversalClient
+	cfg Config
+}
+
+func NewRecent(rdb redis.UniversalClient, cfg Config) *Recent {
+	return &Recent{rdb: rdb, cfg: cfg}
+}
+
+func msgsKey(roomChatID string) string {
+	return "history:recent:" + roomChatID
+}
+
+func bodiesKey(roomChatID string) string {
+	return "history:recent:" + roomChatID + ":msgs"
+}
+
+// fullKey marks that the cache contains the whole history of the room,
+// so the room has fewer messages than requested when the cache does.
+func fullKey(roomChatID string) string {
+	return "history:recent:" + roomChatID + ":full"
+}
+
+// pushScript adds the messages given as the score, ID and body triples and evicts the oldest ones above the size.
+var pushScript = redis.NewScript(`
+local size, ttl = tonumber(ARGV[1]), tonumber(ARGV[2])
+for i = 3, #ARGV, 3 do
+	redis.call('ZADD', KEYS[1], ARGV[i], ARGV[i + 1])
+	redis.call('HSET', KEYS[2], ARGV[i + 1], ARGV[i + 2])
+end
+local evicted = redis.call('ZRANGE', KEYS[1], 0, -size - 1)
+if #evicted > 0 then
+	redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -size - 1)
+	redis.call('HDEL', KEYS[2], unpack(evicted))
+end
+for i = 1, 3 do
+	redis.call('PEXPIRE', KEYS[i], ttl)
+end
+return #evicted
+`)
+
+// latestScript returns whether the room is fully cached followed by the bodies of up to ARGV[1] latest messages.
+var latestScript = redis.NewScript(`
+local ids = redis.call('ZREVRANGE', KEYS[1], 0, tonumber(ARGV[1]) - 1)
+local res = {redis.call('EXISTS', KEYS[3])}
+if #ids > 0 then
+	for _, body in ipairs(redis.call('HMGET', KEYS[2], unpack(ids))) do
+		table.insert(res, body)
+	end
+end
+return res
+`)
+
+// Push adds the messages to the room cache and evicts the oldest messages above the size.
+func (r *Recent) Push(ctx context.Context, roomChatID string, msgs ...loader.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	args := make([]any, 0, 2+3*len(msgs))
+	args = append(args, r.cfg.Size, r.cfg.TTL.Milliseconds())
+	for _, m := range msgs {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("failed encode msg: %w", err)
+		}
+		args = append(args, m.CreatedAt.UnixMilli(), m.ID, b)
+	}
+	keys := []string{msgsKey(roomChatID), bodiesKey(roomChatID), fullKey(roomChatID)}
+	if err := pushScript.Run(ctx, r.rdb, keys, args...).Err(); err != nil {
+		return fmt.Errorf("failed push msgs to cache: %w", err)
+	}
+	return nil
+}
+
+// Fill puts the newest page loaded from the store into the cache.
+// If the page is shorter than requested, the room is marked as fully cached.
+func (r *Recent) Fill(ctx context.Context, roomChatID string, page []loader.Message, requested int) error {
+	if err := r.Push(ctx, roomChatID, page...); err != nil {
+		return err
+	}
+	if len(page) >= requested {
+		return nil
+	}
+	if err := r.rdb.Set(ctx, fullKey(roomChatID), 1, r.cfg.TTL).Err(); err != nil {
+		return fmt.Errorf("failed mark room as fully cached: %w", err)
+	}
+	return nil
+}
+
+// Invalidate drops the cached messages of the room, so the next read is served by the store.
+func (r *Recent) Invalidate(ctx context.Context, roomChatID string) error {
+	if err := r.rdb.Del(ctx, msgsKey(roomChatID), bodiesKey(roomChatID), fullKey(roomChatID)).Err(); err != nil {
+		return fmt.Errorf("failed invalidate room cache: %w", err)
+	}
+	return nil
+}
+
+// Latest returns up to limit latest messages of the room ordered from the newest.
+// ok is false if the cache cannot serve the request and the store must be queried.
+func (r *Recent) Latest(ctx context.Context, roomChatID string, limit int) (msgs []loader.Message, ok bool, err error) {
+	if limit > r.cfg.Size {
+		return nil, false, nil
+	}
+	keys := []string{msgsKey(roomChatID), bodiesKey(roomChatID), fullKey(roomChatID)}
+	res, err := latestScript.Run(ctx, r.rdb, keys, limit).Slice()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed load msgs from cache: %w", err)
+	}
+	full, bodies := res[0] == int64(1), res[1:]
+	if len(bodies) < limit && !full {
+		return nil, false, nil
+	}
+	msgs = make([]loader.Message, 0, len(bodies))
+	for _, body := range bodies {
+		b, isStr := body.(string)
+		if !isStr {
+			// the message missing in the hash, e.g. cached in the former format, is loaded from the store
+			return nil, false, nil
+		}
+		var m loader.Message
+		if err := json.Unmarshal([]byte(b), &m); err != nil {
+			return nil, false, fmt.Errorf("failed decode cached msg: %w", err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, true, nil
+}
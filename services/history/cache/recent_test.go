@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/demeero/chat/history/loader"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRecent(t *testing.T, size int) *Recent {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	return NewRecent(rdb, Config{Size: size, TTL: time.Hour, Enabled: true})
+}
+
+func newMsgs(n int) []loader.Message {
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	msgs := make([]loader.Message, 0, n)
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, loader.Message{ID: strconv.Itoa(i), Msg: "msg", CreatedAt: start.Add(time.Duration(i) * time.Second)})
+	}
+	return msgs
+}
+
+func TestRecentPushKeepsLatest(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRecent(t, 3)
+	msgs := newMsgs(5)
+	// out of order delivery and redelivery
+	for _, i := range []int{4, 0, 1, 3, 2, 4} {
+		require.NoError(t, r.Push(ctx, "room", msgs[i]))
+	}
+
+	got, ok, err := r.Latest(ctx, "room", 3)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Len(t, got, 3)
+	assert.Equal(t, []string{"4", "3", "2"}, []string{got[0].ID, got[1].ID, got[2].ID})
+
+	_, ok, err = r.Latest(ctx, "room", 4)
+	require.NoError(t, err)
+	assert.False(t, ok, "request above the cache size must miss")
+}
+
+func TestRecentFill(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRecent(t, 10)
+
+	_, ok, err := r.Latest(ctx, "room", 5)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, r.Fill(ctx, "room", newMsgs(2), 5))
+	got, ok, err := r.Latest(ctx, "room", 5)
+	require.NoError(t, err)
+	assert.True(t, ok, "fully cached room must be served from the cache")
+	assert.Len(t, got, 2)
+
+	require.NoError(t, r.Fill(ctx, "other", newMsgs(5), 5))
+	_, ok, err = r.Latest(ctx, "other", 6)
+	require.NoError(t, err)
+	assert.False(t, ok, "partially cached room must miss when there are not enough messages")
+}
+
+func TestRecentPushFillIdempotent(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRecent(t, 10)
+	msgs := newMsgs(2)
+	pushed := msgs[1]
+	// the event carries the nanoseconds the store does not keep
+	pushed.CreatedAt = pushed.CreatedAt.Add(123 * time.Nanosecond)
+	require.NoError(t, r.Push(ctx, "room", pushed))
+	require.NoError(t, r.Fill(ctx, "room", []loader.Message{msgs[1], msgs[0]}, 5))
+
+	got, ok, err := r.Latest(ctx, "room", 5)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, []string{"1", "0"}, []string{got[0].ID, got[1].ID}, "the same message must be cached once")
+
+	require.NoError(t, r.Push(ctx, "room", newMsgs(12)[2:]...))
+	n, err := r.rdb.HLen(ctx, bodiesKey("room")).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), n, "the evicted messages must be removed from the hash")
+}
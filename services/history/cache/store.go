@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/history/loader"
+)
+
+// Store serves the newest page of the room history from the Recent cache
+// and falls back to the underlying store for the older pages and cache misses.
+type Store struct {
+	loader.Store
+	recent *Recent
+}
+
+func NewStore(s loader.Store, recent *Recent) *Store {
+	return &Store{Store: s, recent: recent}
+}
+
+func (s *Store) List(ctx context.Context, roomChatID string, cursor *loader.Cursor, limit int) ([]loader.Message, error) {
+	if cursor != nil {
+		return s.Store.List(ctx, roomChatID, cursor, limit)
+	}
+	lg := slogbrick.FromCtx(ctx)
+	msgs, ok, err := s.recent.Latest(ctx, roomChatID, limit)
+	if err != nil {
+		lg.Warn("failed load recent msgs from cache - fallback to store", slog.Any("err", err))
+	}
+	if ok {
+		return msgs, nil
+	}
+	msgs, err = s.Store.List(ctx, roomChatID, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.recent.Fill(ctx, roomChatID, msgs, limit); err != nil {
+		lg.Warn("failed fill recent msgs cache", slog.Any("err", err))
+	}
+	return msgs, nil
+}
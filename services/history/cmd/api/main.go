@@ -10,23 +10,56 @@ import (
 	"os"
 	"os/signal"
 
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/demeero/bricks/configbrick"
-	"github.com/demeero/bricks/cqlbrick"
 	"github.com/demeero/bricks/otelbrick"
 	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/events"
 	"github.com/demeero/chat/bricks/httpsrv"
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/bricks/session"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"github.com/demeero/chat/history/cache"
+	"github.com/demeero/chat/history/event"
 	"github.com/demeero/chat/history/httphandler"
 	"github.com/demeero/chat/history/loader"
-	"github.com/gocql/gocql"
+	"github.com/demeero/chat/history/privacy"
+	"github.com/demeero/chat/history/profile"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/search"
+	"github.com/demeero/chat/history/storage"
+	"github.com/demeero/chat/history/visibility"
+	"github.com/redis/go-redis/v9"
+	wotel "github.com/voi-oss/watermill-opentelemetry/pkg/opentelemetry"
 )
 
 type config struct {
 	configbrick.AppMeta
-	Cassandra configbrick.Cassandra `json:"cassandra"`
-	Log       configbrick.Log       `json:"log"`
-	HTTP      configbrick.HTTP      `json:"http"`
-	JwksURL   string                `split_words:"true" json:"jwks_url"`
-	OTEL      configbrick.OTEL      `json:"otel"`
+	Redis     configbrick.Redis           `json:"redis"`
+	Broker    watermillbrick.BrokerConfig `json:"broker"`
+	Cassandra configbrick.Cassandra       `json:"cassandra"`
+	Storage   storage.Config              `json:"storage"`
+	Cache     cache.Config                `json:"cache"`
+	Retention retention.Config            `json:"retention"`
+	Profile   profile.Config              `json:"profile"`
+	Email     session.EmailConfig         `json:"email"`
+	Log       configbrick.Log             `json:"log"`
+	HTTP      configbrick.HTTP            `json:"http"`
+	JwksURL   string                      `split_words:"true" json:"jwks_url"`
+	Search    searchConfig                `json:"search"`
+	OTEL      configbrick.OTEL            `json:"otel"`
+}
+
+type searchConfig struct {
+	// IndexPath is the path to the directory of the embedded search index.
+	IndexPath string `default:"search.bleve" split_words:"true" json:"index_path"`
+	// ConsumerGroup is the consumer group used to feed the index from the msg_stored stream.
+	// Every instance owns its index, so it must be unique per instance and stable across its restarts,
+	// e.g. history-search-<stateful set ordinal>. The instances sharing the group split the stream, so every index
+	// misses the messages consumed by the others and the search results depend on the instance serving the request.
+	// The group of the gone instance is left behind and holds the stream trimming.
+	ConsumerGroup string `required:"true" split_words:"true" json:"consumer_group"`
 }
 
 func main() {
@@ -39,31 +72,19 @@ func main() {
 		JSON:      cfg.Log.JSON,
 	})
 
-	cluster := gocql.NewCluster(cfg.Cassandra.Host)
-	if cfg.Cassandra.Username != "" {
-		cluster.Authenticator = gocql.PasswordAuthenticator{
-			Username: cfg.Cassandra.Username,
-			Password: cfg.Cassandra.Password,
-		}
-	}
-	cluster.Keyspace = cfg.Cassandra.Keyspace
-	cqlMeterObsrvr, err := cqlbrick.NewOTELMeterQueryObserver(false)
-	if err != nil {
-		log.Fatalf("failed create cql meter observer: %s", err)
-	}
-	cluster.QueryObserver = cqlbrick.NewObserverChain(
-		cqlbrick.SlogLogQueryObserver{Disabled: !cfg.Cassandra.Log},
-		cqlbrick.NewOTELTraceQueryObserver(false),
-		cqlMeterObsrvr)
-	cSess, err := cluster.CreateSession()
-	if err != nil {
-		log.Fatalf("failed create cassandra session: %s", err)
+	if err := cfg.Email.Validate(); err != nil {
+		log.Fatalf("invalid email config: %s", err)
 	}
 
 	ctx := context.Background()
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, os.Kill)
 	defer cancel()
 
+	store, closeStore, err := storage.Open(ctx, cfg.Storage, cfg.Cassandra)
+	if err != nil {
+		log.Fatalf("failed open history storage: %s", err)
+	}
+
 	traceCfg := cfg.OTEL.Trace
 	traceShutdown, err := otelbrick.InitTrace(ctx, otelbrick.TraceConfig{
 		ServiceName:           cfg.ServiceName,
@@ -94,6 +115,24 @@ func main() {
 		log.Fatalf("failed init metrics: %s", err)
 	}
 
+	idx, err := search.Open(cfg.Search.IndexPath)
+	if err != nil {
+		log.Fatalf("failed open search index: %s", err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB})
+	broker, err := watermillbrick.NewBroker(cfg.Broker, rdb, watermill.NewSlogLogger(slog.Default()))
+	if err != nil {
+		log.Fatalf("failed create message broker: %s", err)
+	}
+	r := setupSearchIndexer(ctx, cfg.Search, broker, idx)
+
+	var loaderStore loader.Store = store
+	if cfg.Cache.Enabled {
+		loaderStore = cache.NewStore(store, cache.NewRecent(rdb, cfg.Cache))
+	}
+
+	profiles := profile.NewResolver(identity.NewStore(rdb), identity.NewKratos(cfg.Profile.KratosAdminURL, nil), identity.NewCache(cfg.Profile.CacheSize, cfg.Profile.CacheTTL))
+
 	httpCfg := cfg.HTTP
 	httpSrv := httpsrv.Configure(httpsrv.Config{
 		ReadHeaderTimeout: httpCfg.ReadHeaderTimeout,
@@ -101,7 +140,7 @@ func main() {
 		WriteTimeout:      httpCfg.WriteTimeout,
 		Port:              httpCfg.Port,
 	})
-	if err := httphandler.Setup(ctx, cfg.JwksURL, cfg.ServiceName, httpSrv, loader.New(cSess)); err != nil {
+	if err := httphandler.Setup(ctx, cfg.JwksURL, cfg.ServiceName, httpSrv, loader.New(loaderStore, profiles, visibility.New(cfg.Email)), idx, retention.NewCache(store, cfg.Retention.CacheTTL)); err != nil {
 		log.Fatalf("failed setup http handler: %s", err)
 	}
 	go func() {
@@ -119,11 +158,52 @@ func main() {
 	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
 		slog.Error("failed shutdown http srv", slog.Any("err", err))
 	}
+	if err := r.Close(); err != nil {
+		slog.Error("failed close watermill router", slog.Any("err", err))
+	}
+	if err := idx.Close(); err != nil {
+		slog.Error("failed close search index", slog.Any("err", err))
+	}
+	if err := rdb.Close(); err != nil {
+		slog.Error("failed close redis conn", slog.Any("err", err))
+	}
 	if err := meterShutdown(context.Background()); err != nil {
 		slog.Error("failed shutdown meter provider", slog.Any("err", err))
 	}
 	if err := traceShutdown(context.Background()); err != nil {
 		slog.Error("failed shutdown tracer provider", slog.Any("err", err))
 	}
-	cSess.Close()
+	closeStore()
+}
+
+func setupSearchIndexer(ctx context.Context, cfg searchConfig, broker *watermillbrick.Broker, idx *search.Index) *message.Router {
+	wmLogger := watermill.NewSlogLogger(slog.Default())
+	sub, err := broker.Subscriber(cfg.ConsumerGroup)
+	if err != nil {
+		log.Fatalf("failed create watermill subscriber: %s", err)
+	}
+	validator, err := events.NewValidator()
+	if err != nil {
+		log.Fatalf("failed create event validator: %s", err)
+	}
+	r, err := message.NewRouter(message.RouterConfig{}, wmLogger)
+	if err != nil {
+		log.Fatalf("failed create watermill router: %s", err)
+	}
+	r.AddMiddleware(wotel.Trace())
+	r.AddNoPublisherHandler("history-search-indexer",
+		events.TopicMsgStored,
+		sub,
+		event.MsgStoredEvtIndexHandler(events.TopicMsgStored, idx, validator)).
+		AddMiddleware(events.NewUpcasters().Middleware(events.TopicMsgStored))
+	r.AddNoPublisherHandler("history-search-eraser",
+		privacy.AuditTopic,
+		sub,
+		event.UserDataAuditEvtIndexHandler(privacy.AuditTopic, idx))
+	go func() {
+		if err := r.Run(ctx); err != nil {
+			log.Fatalf("failed run watermill router: %s", err)
+		}
+	}()
+	return r
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/demeero/bricks/configbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/history/export"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/profile"
+	"github.com/demeero/chat/history/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+type config struct {
+	Redis     configbrick.Redis     `json:"redis"`
+	Cassandra configbrick.Cassandra `json:"cassandra"`
+	Storage   storage.Config        `json:"storage"`
+	Profile   profile.Config        `json:"profile"`
+	Log       configbrick.Log       `json:"log"`
+}
+
+func main() {
+	room := flag.String("room", "", "chat room ID to export")
+	format := flag.String("format", string(export.JSON), "export format: json, ndjson, csv or html")
+	out := flag.String("out", "", "output file, defaults to room-<room>.<format>; - writes to stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -room <room_chat_id> [-format json|ndjson|csv|html] [-out file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *room == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		log.Fatalf("invalid format: %s", err)
+	}
+
+	cfg := config{}
+	configbrick.LoadConfig(&cfg, os.Getenv("LOG_CONFIG") == "true")
+
+	slogbrick.Configure(slogbrick.Config{
+		Level:     cfg.Log.Level,
+		AddSource: cfg.Log.AddSource,
+		JSON:      cfg.Log.JSON,
+	})
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	store, closeStore, err := storage.Open(ctx, cfg.Storage, cfg.Cassandra)
+	if err != nil {
+		log.Fatalf("failed open history storage: %s", err)
+	}
+	defer closeStore()
+
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB})
+	defer rdb.Close()
+	profiles := profile.NewResolver(identity.NewStore(rdb), identity.NewKratos(cfg.Profile.KratosAdminURL, nil), identity.NewCache(cfg.Profile.CacheSize, cfg.Profile.CacheTTL))
+
+	if err := run(ctx, loader.New(store, profiles, nil), *room, f, *out); err != nil {
+		log.Fatalf("failed export chat history: %s", err)
+	}
+}
+
+func run(ctx context.Context, l *loader.Loader, room string, f export.Format, out string) (err error) {
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		if out == "" {
+			out = f.FileName(room)
+		}
+		file, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("failed create output file: %w", err)
+		}
+		defer func() {
+			if cErr := file.Close(); cErr != nil && err == nil {
+				err = fmt.Errorf("failed close output file: %w", cErr)
+			}
+		}()
+		w = file
+	}
+	bw := bufio.NewWriter(w)
+	if err := export.Room(ctx, l, room, f, bw, nil); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed flush output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/demeero/bricks/configbrick"
+	"github.com/demeero/bricks/cqlbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/history/migration"
+	"github.com/gocql/gocql"
+)
+
+type config struct {
+	Cassandra configbrick.Cassandra `json:"cassandra"`
+	Log       configbrick.Log       `json:"log"`
+	Migrate   migrateConfig         `json:"migrate"`
+}
+
+type migrateConfig struct {
+	// Replication is the replication options the keyspace is created with if it does not exist.
+	Replication string `default:"{'class': 'SimpleStrategy', 'replication_factor': 1}" json:"replication"`
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "log pending migrations without applying them")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-dry-run] up|status\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config{}
+	configbrick.LoadConfig(&cfg, os.Getenv("LOG_CONFIG") == "true")
+
+	slogbrick.Configure(slogbrick.Config{
+		Level:     cfg.Log.Level,
+		AddSource: cfg.Log.AddSource,
+		JSON:      cfg.Log.JSON,
+	})
+
+	cluster := gocql.NewCluster(cfg.Cassandra.Host)
+	if cfg.Cassandra.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: cfg.Cassandra.Username,
+			Password: cfg.Cassandra.Password,
+		}
+	}
+	cluster.QueryObserver = cqlbrick.SlogLogQueryObserver{Disabled: !cfg.Cassandra.Log}
+	cSess, err := cluster.CreateSession()
+	if err != nil {
+		log.Fatalf("failed create cassandra session: %s", err)
+	}
+	defer cSess.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	m, err := migration.New(cSess, cfg.Migrate.Replication)
+	if err != nil {
+		log.Fatalf("failed create migrator: %s", err)
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "up":
+		applied, err := m.Up(ctx, *dryRun)
+		if err != nil {
+			log.Fatalf("failed migrate up: %s", err)
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(os.Stdout, "schema is up to date")
+		}
+		for _, mig := range applied {
+			if *dryRun {
+				fmt.Fprintf(os.Stdout, "pending %04d_%s\n", mig.Version, mig.Name)
+			} else {
+				fmt.Fprintf(os.Stdout, "applied %04d_%s\n", mig.Version, mig.Name)
+			}
+		}
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			log.Fatalf("failed load migrations status: %s", err)
+		}
+		printStatus(statuses)
+	default:
+		log.Fatalf("unknown command %q", cmd)
+	}
+}
+
+func printStatus(statuses []migration.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, st := range statuses {
+		status, appliedAt := "pending", ""
+		if st.Applied {
+			status, appliedAt = "applied", st.AppliedAt.Format(time.RFC3339)
+		}
+		if st.Modified {
+			status = "modified"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", st.Migration.Version, st.Migration.Name, status, appliedAt)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("failed print status: %s", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/demeero/bricks/configbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"github.com/demeero/chat/history/cache"
+	"github.com/demeero/chat/history/privacy"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+type config struct {
+	Redis     configbrick.Redis           `json:"redis"`
+	Broker    watermillbrick.BrokerConfig `json:"broker"`
+	Cassandra configbrick.Cassandra       `json:"cassandra"`
+	Storage   storage.Config              `json:"storage"`
+	Cache     cache.Config                `json:"cache"`
+	Retention retention.Config            `json:"retention"`
+	Log       configbrick.Log             `json:"log"`
+}
+
+func main() {
+	userID := flag.String("user", "", "ID of the user the request is about")
+	requestedBy := flag.String("requested-by", "", "admin who triggered the request, recorded in the audit events")
+	jobID := flag.String("job", "", "job ID recorded in the audit events, generated if empty")
+	out := flag.String("out", "", "export output file, defaults to user-<user>.ndjson")
+	modeStr := flag.String("mode", string(privacy.Anonymize), "erasure mode: anonymize or delete")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -user <user_id> -requested-by <admin> [flags] export|erase\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *userID == "" || *requestedBy == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config{}
+	configbrick.LoadConfig(&cfg, os.Getenv("LOG_CONFIG") == "true")
+
+	slogbrick.Configure(slogbrick.Config{
+		Level:     cfg.Log.Level,
+		AddSource: cfg.Log.AddSource,
+		JSON:      cfg.Log.JSON,
+	})
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	store, closeStore, err := storage.Open(ctx, cfg.Storage, cfg.Cassandra)
+	if err != nil {
+		log.Fatalf("failed open history storage: %s", err)
+	}
+	defer closeStore()
+
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB})
+	defer rdb.Close()
+	broker, err := watermillbrick.NewBroker(cfg.Broker, rdb, watermill.NewSlogLogger(slog.Default()))
+	if err != nil {
+		log.Fatalf("failed create message broker: %s", err)
+	}
+	publisher, err := broker.Publisher(watermillbrick.PubConfig{Name: "history-privacy", Source: "/chat/history"})
+	if err != nil {
+		log.Fatalf("failed create watermill publisher: %s", err)
+	}
+	defer publisher.Close()
+
+	var invalidator privacy.RoomInvalidator
+	if cfg.Cache.Enabled {
+		invalidator = cache.NewRecent(rdb, cfg.Cache)
+	}
+	job := privacy.NewJob(store, retention.NewCache(store, cfg.Retention.CacheTTL), privacy.NewPubAuditor(publisher), invalidator)
+	req := privacy.Request{
+		JobID:       *jobID,
+		UserID:      *userID,
+		RequestedBy: *requestedBy,
+		Progress: func(rep privacy.Report) {
+			slog.Info("progress", slog.String("job_id", rep.JobID), slog.Int("messages", rep.Messages), slog.Int("rooms", rep.Rooms))
+		},
+	}
+
+	var rep privacy.Report
+	switch cmd := flag.Arg(0); cmd {
+	case "export":
+		if *out == "" {
+			*out = "user-" + *userID + ".ndjson"
+		}
+		rep, err = export(ctx, job, req, *out)
+	case "erase":
+		mode, mErr := privacy.ParseMode(*modeStr)
+		if mErr != nil {
+			log.Fatalf("invalid mode: %s", mErr)
+		}
+		rep, err = job.Erase(ctx, req, mode)
+		if err == nil {
+			// the Kratos identity is deleted separately, the profile copy is dropped right away and never synced again
+			err = identity.NewStore(rdb).Erase(ctx, *userID)
+		}
+	default:
+		log.Fatalf("unknown command %q", cmd)
+	}
+	if err != nil {
+		log.Fatalf("failed %s user data, job %s: %s", flag.Arg(0), rep.JobID, err)
+	}
+	fmt.Fprintf(os.Stdout, "job %s: %d messages in %d rooms\n", rep.JobID, rep.Messages, rep.Rooms)
+	if rep.AnonymousID != "" {
+		fmt.Fprintf(os.Stdout, "messages are attributed to %s\n", rep.AnonymousID)
+	}
+}
+
+func export(ctx context.Context, job *privacy.Job, req privacy.Request, out string) (rep privacy.Report, err error) {
+	f, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return privacy.Report{}, fmt.Errorf("failed create output file: %w", err)
+	}
+	defer func() {
+		if cErr := f.Close(); cErr != nil && err == nil {
+			err = fmt.Errorf("failed close output file: %w", cErr)
+		}
+	}()
+	bw := bufio.NewWriter(f)
+	if rep, err = job.Export(ctx, req, bw); err != nil {
+		return rep, err
+	}
+	if err := bw.Flush(); err != nil {
+		return rep, fmt.Errorf("failed flush output: %w", err)
+	}
+	return rep, nil
+}
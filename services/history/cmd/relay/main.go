@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/demeero/bricks/configbrick"
+	"github.com/demeero/bricks/otelbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"github.com/demeero/chat/history/outbox"
+	"github.com/demeero/chat/history/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+type config struct {
+	configbrick.AppMeta
+	Redis     configbrick.Redis           `json:"redis"`
+	Broker    watermillbrick.BrokerConfig `json:"broker"`
+	Cassandra configbrick.Cassandra       `json:"cassandra"`
+	Storage   storage.Config              `json:"storage"`
+	Outbox    outbox.Config               `json:"outbox"`
+	Log       configbrick.Log             `json:"log"`
+	OTEL      configbrick.OTEL            `json:"otel"`
+}
+
+func main() {
+	cfg := config{}
+	configbrick.LoadConfig(&cfg, os.Getenv("LOG_CONFIG") == "true")
+
+	slogbrick.Configure(slogbrick.Config{
+		Level:     cfg.Log.Level,
+		AddSource: cfg.Log.AddSource,
+		JSON:      cfg.Log.JSON,
+	})
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	store, closeStore, err := storage.Open(ctx, cfg.Storage, cfg.Cassandra)
+	if err != nil {
+		log.Fatalf("failed open history storage: %s", err)
+	}
+	defer closeStore()
+
+	traceCfg := cfg.OTEL.Trace
+	traceShutdown, err := otelbrick.InitTrace(ctx, otelbrick.TraceConfig{
+		ServiceName:           cfg.ServiceName,
+		ServiceNamespace:      cfg.ServiceNamespace,
+		DeploymentEnvironment: cfg.Env,
+		OTELHTTPEndpoint:      traceCfg.Endpoint,
+		OTELHTTPPathPrefix:    traceCfg.PathPrefix,
+		Insecure:              traceCfg.Insecure,
+		Headers:               traceCfg.BasicAuthHeader(),
+	})
+	if err != nil {
+		log.Fatalf("failed init tracer: %s", err)
+	}
+
+	meterCfg := cfg.OTEL.Meter
+	meterShutdown, err := otelbrick.InitMeter(ctx, otelbrick.MeterConfig{
+		ServiceName:           cfg.ServiceName,
+		ServiceNamespace:      cfg.ServiceNamespace,
+		DeploymentEnvironment: cfg.Env,
+		OTELHTTPEndpoint:      meterCfg.Endpoint,
+		OTELHTTPPathPrefix:    meterCfg.PathPrefix,
+		Insecure:              meterCfg.Insecure,
+		RuntimeMetrics:        true,
+		Headers:               meterCfg.BasicAuthHeader(),
+	})
+	if err != nil {
+		log.Fatalf("failed init metrics: %s", err)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB})
+	broker, err := watermillbrick.NewBroker(cfg.Broker, rdb, watermill.NewSlogLogger(slog.Default()))
+	if err != nil {
+		log.Fatalf("failed create message broker: %s", err)
+	}
+	pub, err := broker.Publisher(watermillbrick.PubConfig{
+		Name:    "history-outbox-relay",
+		Metrics: true,
+		// the writer and the relay publish the same msg_stored events
+		Source: "/chat/history",
+	})
+	if err != nil {
+		log.Fatalf("failed create watermill publisher: %s", err)
+	}
+
+	outbox.NewRelay(store, pub, cfg.Outbox).Run(ctx)
+	slog.Info("shutting down")
+
+	if err := pub.Close(); err != nil {
+		slog.Error("failed close watermill publisher", slog.Any("err", err))
+	}
+	if err := rdb.Close(); err != nil {
+		slog.Error("failed close redis client", slog.Any("err", err))
+	}
+	if err := meterShutdown(context.Background()); err != nil {
+		slog.Error("failed shutdown meter provider", slog.Any("err", err))
+	}
+	if err := traceShutdown(context.Background()); err != nil {
+		slog.Error("failed shutdown tracer provider", slog.Any("err", err))
+	}
+}
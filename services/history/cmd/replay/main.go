@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/bricks/configbrick"
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"github.com/demeero/chat/history/cache"
+	"github.com/demeero/chat/history/event"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/replay"
+	"github.com/demeero/chat/history/search"
+	"github.com/demeero/chat/history/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+type config struct {
+	Redis     configbrick.Redis           `json:"redis"`
+	Broker    watermillbrick.BrokerConfig `json:"broker"`
+	Cassandra configbrick.Cassandra       `json:"cassandra"`
+	Storage   storage.Config              `json:"storage"`
+	Cache     cache.Config                `json:"cache"`
+	Log       configbrick.Log             `json:"log"`
+}
+
+func main() {
+	rooms := flag.String("rooms", "", "comma separated chat room IDs, all rooms if empty")
+	from := flag.String("from", "", "replay the messages created since the RFC3339 time")
+	to := flag.String("to", "", "replay the messages created before the RFC3339 time")
+	rate := flag.Float64("rate", 200, "maximum number of the messages replayed per second, 0 is unlimited")
+	batch := flag.Int("batch", 500, "number of the messages loaded at once, the checkpoint is saved after every batch")
+	checkpoint := flag.String("checkpoint", "replay.checkpoint.json", "checkpoint file, the unfinished replay resumes from it")
+	index := flag.String("index", "", "search index path of the search target, the history api must be stopped")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] publish|cache|search\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "  publish republishes the msg_stored events to all consumers, cache and search rebuild the projection directly")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	job, err := parseJob(*rooms, *from, *to)
+	if err != nil {
+		log.Fatalf("invalid flags: %s", err)
+	}
+	job.Target = flag.Arg(0)
+
+	cfg := config{}
+	configbrick.LoadConfig(&cfg, os.Getenv("LOG_CONFIG") == "true")
+
+	slogbrick.Configure(slogbrick.Config{
+		Level:     cfg.Log.Level,
+		AddSource: cfg.Log.AddSource,
+		JSON:      cfg.Log.JSON,
+	})
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	store, closeStore, err := storage.Open(ctx, cfg.Storage, cfg.Cassandra)
+	if err != nil {
+		log.Fatalf("failed open history storage: %s", err)
+	}
+	defer closeStore()
+
+	validator, err := events.NewValidator()
+	if err != nil {
+		log.Fatalf("failed create event validator: %s", err)
+	}
+	var sink replay.Sink
+	switch target := flag.Arg(0); target {
+	case "publish":
+		rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB})
+		defer rdb.Close()
+		broker, err := watermillbrick.NewBroker(cfg.Broker, rdb, watermill.NewSlogLogger(slog.Default()))
+		if err != nil {
+			log.Fatalf("failed create message broker: %s", err)
+		}
+		pub, err := broker.Publisher(watermillbrick.PubConfig{Name: "history-replay", Source: "/chat/history"})
+		if err != nil {
+			log.Fatalf("failed create watermill publisher: %s", err)
+		}
+		defer pub.Close()
+		sink = publishSink(pub)
+	case "cache":
+		rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB})
+		defer rdb.Close()
+		sink = handlerSink(event.MsgStoredEvtCacheHandler(events.TopicMsgStored, cache.NewRecent(rdb, cfg.Cache), validator))
+	case "search":
+		if *index == "" {
+			log.Fatalf("search target requires the -index flag")
+		}
+		idx, err := search.Open(*index)
+		if err != nil {
+			log.Fatalf("failed open search index: %s", err)
+		}
+		defer idx.Close()
+		sink = handlerSink(event.MsgStoredEvtIndexHandler(events.TopicMsgStored, idx, validator))
+	default:
+		log.Fatalf("unknown target %q", target)
+	}
+
+	r := replay.New(store, sink, replay.FileCheckpoints(*checkpoint), replay.Config{Rate: *rate, BatchSize: *batch})
+	cp, err := r.Replay(ctx, job)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stdout, "stopped after %d messages, run again to resume from %s\n", cp.Replayed, *checkpoint)
+		return
+	}
+	if errors.Is(err, errbrick.ErrConflict) {
+		log.Fatalf("failed resume replay from %s: %s", *checkpoint, err)
+	}
+	if err != nil {
+		log.Fatalf("failed replay, run again to resume from %s: %s", *checkpoint, err)
+	}
+	fmt.Fprintf(os.Stdout, "replayed %d messages of %d rooms\n", cp.Replayed, len(cp.Job.Rooms))
+}
+
+func parseJob(rooms, from, to string) (replay.Job, error) {
+	var (
+		job replay.Job
+		err error
+	)
+	if rooms != "" {
+		job.Rooms = strings.Split(rooms, ",")
+	}
+	if from != "" {
+		if job.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return replay.Job{}, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if to != "" {
+		if job.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return replay.Job{}, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	return job, nil
+}
+
+// publishSink republishes the msg_stored events marked as replayed, so they are not delivered to the users again.
+func publishSink(pub message.Publisher) replay.Sink {
+	return func(ctx context.Context, roomChatID string, m loader.Message) error {
+		msg, err := event.NewMsgStoredMsg(ctx, roomChatID, m)
+		if err != nil {
+			return err
+		}
+		events.SetReplayed(msg.Metadata)
+		return pub.Publish(events.TopicMsgStored, msg)
+	}
+}
+
+// handlerSink feeds the msg_stored events to the projection handler.
+func handlerSink(h message.NoPublishHandlerFunc) replay.Sink {
+	return func(ctx context.Context, roomChatID string, m loader.Message) error {
+		msg, err := event.NewMsgStoredMsg(ctx, roomChatID, m)
+		if err != nil {
+			return err
+		}
+		return h(msg)
+	}
+}
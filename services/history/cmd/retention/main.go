@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/demeero/bricks/configbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/storage"
+)
+
+type config struct {
+	Cassandra configbrick.Cassandra `json:"cassandra"`
+	Storage   storage.Config        `json:"storage"`
+	Log       configbrick.Log       `json:"log"`
+}
+
+func main() {
+	room := flag.String("room", "", "chat room ID")
+	days := flag.Int("days", 0, "number of days the messages are kept for, 0 keeps them permanently")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-room <room_chat_id>] [-days N] get|set|list\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config{}
+	configbrick.LoadConfig(&cfg, os.Getenv("LOG_CONFIG") == "true")
+
+	slogbrick.Configure(slogbrick.Config{
+		Level:     cfg.Log.Level,
+		AddSource: cfg.Log.AddSource,
+		JSON:      cfg.Log.JSON,
+	})
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	store, closeStore, err := storage.Open(ctx, cfg.Storage, cfg.Cassandra)
+	if err != nil {
+		log.Fatalf("failed open history storage: %s", err)
+	}
+	defer closeStore()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "get":
+		p, err := store.GetRetention(ctx, *room)
+		if err != nil {
+			log.Fatalf("failed get retention: %s", err)
+		}
+		printPolicies([]retention.Policy{p})
+	case "set":
+		p, err := retention.Set(ctx, store, *room, *days)
+		if err != nil {
+			log.Fatalf("failed set retention: %s", err)
+		}
+		fmt.Fprintln(os.Stdout, "retention is set, the existing messages follow it after the next sweep")
+		printPolicies([]retention.Policy{p})
+	case "list":
+		policies, err := store.ListRetentions(ctx)
+		if err != nil {
+			log.Fatalf("failed list retentions: %s", err)
+		}
+		printPolicies(policies)
+	default:
+		log.Fatalf("unknown command %q", cmd)
+	}
+}
+
+func printPolicies(policies []retention.Policy) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOM\tRETENTION\tUPDATED AT\tAPPLIED AT")
+	for _, p := range policies {
+		days, updatedAt, appliedAt := "permanent", "", ""
+		if !p.Permanent() {
+			days = fmt.Sprintf("%d days", p.Days)
+		}
+		if !p.UpdatedAt.IsZero() {
+			updatedAt = p.UpdatedAt.Format(time.RFC3339)
+		}
+		if !p.AppliedAt.IsZero() {
+			appliedAt = p.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.RoomChatID, days, updatedAt, appliedAt)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("failed print retentions: %s", err)
+	}
+}
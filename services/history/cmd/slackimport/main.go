@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/demeero/bricks/configbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/history/profile"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/slackimport"
+	"github.com/demeero/chat/history/storage"
+	"github.com/demeero/chat/history/writer"
+	"github.com/redis/go-redis/v9"
+)
+
+type config struct {
+	Redis     configbrick.Redis     `json:"redis"`
+	Cassandra configbrick.Cassandra `json:"cassandra"`
+	Storage   storage.Config        `json:"storage"`
+	Profile   profile.Config        `json:"profile"`
+	Log       configbrick.Log       `json:"log"`
+}
+
+func main() {
+	statePath := flag.String("state", "", "file the import progress is saved to, defaults to <export>.state.json")
+	roomMapPath := flag.String("room-map", "", "JSON file mapping the slack channel names to the chat room IDs")
+	workers := flag.Int("workers", 8, "number of concurrent inserts")
+	keepUnresolved := flag.Bool("keep-unresolved", false, "import messages of the users without identity as slack:<user id>")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <slack-export.zip>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	zipPath := flag.Arg(0)
+	if *statePath == "" {
+		*statePath = zipPath + ".state.json"
+	}
+	roomMap, err := loadRoomMap(*roomMapPath)
+	if err != nil {
+		log.Fatalf("failed load room map: %s", err)
+	}
+
+	cfg := config{}
+	configbrick.LoadConfig(&cfg, os.Getenv("LOG_CONFIG") == "true")
+
+	slogbrick.Configure(slogbrick.Config{
+		Level:     cfg.Log.Level,
+		AddSource: cfg.Log.AddSource,
+		JSON:      cfg.Log.JSON,
+	})
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	archive, err := slackimport.OpenArchive(zipPath)
+	if err != nil {
+		log.Fatalf("failed open slack export: %s", err)
+	}
+	defer archive.Close()
+
+	store, closeStore, err := storage.Open(ctx, cfg.Storage, cfg.Cassandra)
+	if err != nil {
+		log.Fatalf("failed open history storage: %s", err)
+	}
+	defer closeStore()
+
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB})
+	defer rdb.Close()
+
+	// the messages are written concurrently and out of the room order, so the imported history is not sequenced
+	w := writer.New(store, retention.NewCache(store, time.Minute), nil)
+	resolver := slackimport.NewKratosResolver(identity.NewKratos(cfg.Profile.KratosAdminURL, nil))
+	importer := slackimport.New(w, resolver, identity.NewStore(rdb), slackimport.Config{
+		RoomMap:        roomMap,
+		StatePath:      *statePath,
+		Workers:        *workers,
+		KeepUnresolved: *keepUnresolved,
+	})
+	stats, err := importer.Run(ctx, archive)
+	slog.Info("slack import stats",
+		slog.Int("channels", stats.Channels),
+		slog.Int("days", stats.Days),
+		slog.Int("resumed_days", stats.ResumedDays),
+		slog.Int("messages", stats.Messages),
+		slog.Int("replies", stats.Replies),
+		slog.Int("skipped", stats.Skipped),
+		slog.Int("skipped_reactions", stats.Reactions))
+	if err != nil {
+		log.Fatalf("failed import slack export, run again to resume: %s", err)
+	}
+}
+
+func loadRoomMap(p string) (map[string]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
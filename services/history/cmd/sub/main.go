@@ -2,40 +2,80 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
 
 	"github.com/ThreeDotsLabs/watermill"
-	"github.com/ThreeDotsLabs/watermill-redisstream/pkg/redisstream"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/demeero/bricks/configbrick"
-	"github.com/demeero/bricks/cqlbrick"
 	"github.com/demeero/bricks/otelbrick"
 	"github.com/demeero/bricks/slogbrick"
-	"github.com/demeero/bricks/watermillbrick"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"github.com/demeero/chat/history/cache"
 	"github.com/demeero/chat/history/event"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/outbox"
+	"github.com/demeero/chat/history/profile"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/sequence"
+	"github.com/demeero/chat/history/storage"
 	"github.com/demeero/chat/history/writer"
-	wotelfloss "github.com/dentech-floss/watermill-opentelemetry-go-extra/pkg/opentelemetry"
-	"github.com/gocql/gocql"
 	"github.com/redis/go-redis/v9"
 	wotel "github.com/voi-oss/watermill-opentelemetry/pkg/opentelemetry"
 )
 
-const topic = "msg_sent"
-
 type config struct {
 	configbrick.AppMeta
-	Redis     configbrick.Redis     `json:"redis"`
-	Cassandra configbrick.Cassandra `json:"cassandra"`
-	Log       configbrick.Log       `json:"log"`
-	OTEL      configbrick.OTEL      `json:"otel"`
+	Redis     configbrick.Redis           `json:"redis"`
+	Broker    watermillbrick.BrokerConfig `json:"broker"`
+	Cassandra configbrick.Cassandra       `json:"cassandra"`
+	Storage   storage.Config              `json:"storage"`
+	Cache     cache.Config                `json:"cache"`
+	Retention retention.Config            `json:"retention"`
+	Profile   profile.Config              `json:"profile"`
+	Writer    writerConfig                `json:"writer"`
+	Log       configbrick.Log             `json:"log"`
+	OTEL      configbrick.OTEL            `json:"otel"`
+}
+
+type writerConfig struct {
+	// Lanes is the number of the rooms written in parallel. The messages of a room are always written by the same lane in order.
+	// Changing the number of lanes reroutes the rooms, so the events already routed to the old lanes may be written out of order.
+	Lanes int `default:"1" json:"lanes"`
+	// Replicas is the number of the instances sharing the writer. Every lane is consumed by a single instance only,
+	// so the messages of a room are never written by two instances at once.
+	Replicas int `default:"1" json:"replicas"`
+	// Replica is the ordinal of the instance from 0 to Replicas-1. The instance consumes the lanes with lane % Replicas == Replica.
+	// The instance 0 also routes the sent messages to the lanes, or writes them itself when there is a single lane.
+	Replica int `default:"0" json:"replica"`
+}
+
+func (c writerConfig) validate() error {
+	if c.Lanes < 1 {
+		return fmt.Errorf("invalid number of writer lanes %d", c.Lanes)
+	}
+	if c.Replicas < 1 || c.Replica < 0 || c.Replica >= c.Replicas {
+		return fmt.Errorf("invalid writer replica %d of %d", c.Replica, c.Replicas)
+	}
+	return nil
+}
+
+// owns reports whether the instance consumes the lane.
+func (c writerConfig) owns(lane int) bool {
+	return lane%c.Replicas == c.Replica
 }
 
 func main() {
 	cfg := config{}
 	configbrick.LoadConfig(&cfg, os.Getenv("LOG_CONFIG") == "true")
+	if err := cfg.Writer.validate(); err != nil {
+		log.Fatalf("invalid writer config: %s", err)
+	}
 
 	slogbrick.Configure(slogbrick.Config{
 		Level:     cfg.Log.Level,
@@ -43,32 +83,16 @@ func main() {
 		JSON:      cfg.Log.JSON,
 	})
 
-	cluster := gocql.NewCluster(cfg.Cassandra.Host)
-	if cfg.Cassandra.Username != "" {
-		cluster.Authenticator = gocql.PasswordAuthenticator{
-			Username: cfg.Cassandra.Username,
-			Password: cfg.Cassandra.Password,
-		}
-	}
-	cluster.Keyspace = cfg.Cassandra.Keyspace
-	cqlMeterObsrvr, err := cqlbrick.NewOTELMeterQueryObserver(false)
-	if err != nil {
-		log.Fatalf("failed create cql meter observer: %s", err)
-	}
-	cluster.QueryObserver = cqlbrick.NewObserverChain(
-		cqlbrick.SlogLogQueryObserver{Disabled: !cfg.Cassandra.Log},
-		cqlbrick.NewOTELTraceQueryObserver(false),
-		cqlMeterObsrvr)
-	cSess, err := cluster.CreateSession()
-	if err != nil {
-		log.Fatalf("failed create scylladb session: %s", err)
-	}
-	defer cSess.Close()
-
 	ctx := context.Background()
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, os.Kill)
 	defer cancel()
 
+	store, closeStore, err := storage.Open(ctx, cfg.Storage, cfg.Cassandra)
+	if err != nil {
+		log.Fatalf("failed open history storage: %s", err)
+	}
+	defer closeStore()
+
 	traceCfg := cfg.OTEL.Trace
 	traceShutdown, err := otelbrick.InitTrace(ctx, otelbrick.TraceConfig{
 		ServiceName:           cfg.ServiceName,
@@ -101,42 +125,103 @@ func main() {
 
 	rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB})
 	wmLogger := watermill.NewSlogLogger(slog.Default())
-	publisher, err := redisstream.NewPublisher(redisstream.PublisherConfig{Client: rdb}, wmLogger)
+	broker, err := watermillbrick.NewBroker(cfg.Broker, rdb, wmLogger)
 	if err != nil {
-		log.Fatalf("failed create redisstream publisher: %s", err)
+		log.Fatalf("failed create message broker: %s", err)
 	}
-	pub, err := watermillbrick.NewOTELPublisher(watermillbrick.OTELPubConfig{
+	pub, err := broker.Publisher(watermillbrick.PubConfig{
 		Name:    "history-writer",
 		Metrics: true,
-	}, publisher)
+		// the writer and the relay publish the same msg_stored events
+		Source: "/chat/history",
+	})
 	if err != nil {
 		log.Fatalf("failed create watermill publisher: %s", err)
 	}
-	sub, err := redisstream.NewSubscriber(redisstream.SubscriberConfig{
-		Client:        rdb,
-		ConsumerGroup: "history-writer",
-	}, wmLogger)
+	sub, err := broker.Subscriber("history-writer")
 	if err != nil {
-		log.Fatalf("failed create redisstream subscriber: %s", err)
+		log.Fatalf("failed create watermill subscriber: %s", err)
 	}
 
 	r, err := message.NewRouter(message.RouterConfig{}, wmLogger)
 	if err != nil {
 		log.Fatalf("failed create watermill router: %s", err)
 	}
-	r.AddMiddleware(wotelfloss.ExtractRemoteParentSpanContext())
 	r.AddMiddleware(wotel.Trace())
-	r.AddHandler("history-writer",
-		topic,
-		sub,
-		"msg_stored",
-		pub,
-		event.MsgSentEvtHandler(topic, writer.New(cSess)))
+	subMetrics, err := watermillbrick.NewSubMetrics()
+	if err != nil {
+		log.Fatalf("failed create subscriber metrics: %s", err)
+	}
+	r.AddMiddleware(subMetrics.RouterMiddleware)
+	w := writer.New(store, retention.NewCache(store, cfg.Retention.CacheTTL), sequence.NewRedis(rdb, store))
+	// the writer publishes the stored messages right after the write, the ones left unpublished are relayed by cmd/relay
+	relay := outbox.NewRelay(store, pub, outbox.Config{})
+	validator, err := events.NewValidator()
+	if err != nil {
+		log.Fatalf("failed create event validator: %s", err)
+	}
+	// the events of the older versions still sitting in the streams are upcasted before the handlers decode them
+	upcasters := events.NewUpcasters()
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("failed get hostname: %s", err)
+	}
+	storeLatency, err := events.NewLatency("msg_send_to_store_latency",
+		"The time since the user sent the message until it is stored", hostname)
+	if err != nil {
+		log.Fatalf("failed create store latency metric: %s", err)
+	}
+	if cfg.Writer.Lanes > 1 {
+		addWriterLanes(r, cfg.Writer, sub, pub, w, relay, validator, upcasters, storeLatency)
+	} else if cfg.Writer.owns(0) {
+		r.AddNoPublisherHandler("history-writer",
+			events.TopicMsgSent,
+			sub,
+			storeLatency.Handler(event.MsgSentEvtHandler(events.TopicMsgSent, events.TopicMsgStored, w, relay, validator))).
+			AddMiddleware(upcasters.Middleware(events.TopicMsgSent))
+	}
+	if cfg.Cache.Enabled {
+		cacheSub, err := broker.Subscriber("history-cache")
+		if err != nil {
+			log.Fatalf("failed create watermill subscriber: %s", err)
+		}
+		r.AddNoPublisherHandler("history-cache",
+			events.TopicMsgStored,
+			cacheSub,
+			event.MsgStoredEvtCacheHandler(events.TopicMsgStored, cache.NewRecent(rdb, cfg.Cache), validator)).
+			AddMiddleware(upcasters.Middleware(events.TopicMsgStored))
+	}
 	go func() {
 		if err := r.Run(ctx); err != nil {
 			log.Fatalf("failed run watermill router: %s", err)
 		}
 	}()
+	if cfg.Broker.Kind == watermillbrick.BrokerRedis {
+		streamCfg := cfg.Broker.Redis
+		if cfg.Writer.Lanes > 1 {
+			// the writer group consumes the lane streams, so their backlog is reported and reclaimed too
+			streamCfg.Streams = append([]string{}, cfg.Broker.Redis.Streams...)
+			for lane := 0; lane < cfg.Writer.Lanes; lane++ {
+				streamCfg.Streams = append(streamCfg.Streams, event.LaneTopic(events.TopicMsgSent, lane))
+			}
+		}
+		monitor, err := watermillbrick.NewStreamMonitor(rdb, streamCfg)
+		if err != nil {
+			log.Fatalf("failed create redis stream monitor: %s", err)
+		}
+		go monitor.Run(ctx)
+	}
+	if cfg.Retention.SweepEnabled {
+		var invalidator retention.RoomInvalidator
+		if cfg.Cache.Enabled {
+			invalidator = cache.NewRecent(rdb, cfg.Cache)
+		}
+		go retention.NewSweeper(store, store, loader.New(store, nil, nil), invalidator, cfg.Retention.SweepInterval).Run(ctx)
+	}
+	if cfg.Profile.SyncEnabled {
+		kratos := identity.NewKratos(cfg.Profile.KratosAdminURL, nil)
+		go profile.NewSyncer(identity.NewStore(rdb), kratos, cfg.Profile.SyncInterval).Run(ctx)
+	}
 
 	<-ctx.Done()
 	slog.Info("shutting down")
@@ -144,8 +229,8 @@ func main() {
 	if err := r.Close(); err != nil {
 		slog.Error("failed close watermill router", slog.Any("err", err))
 	}
-	if err := publisher.Close(); err != nil {
-		slog.Error("failed close redisstream publisher", slog.Any("err", err))
+	if err := pub.Close(); err != nil {
+		slog.Error("failed close watermill publisher", slog.Any("err", err))
 	}
 	if err := rdb.Close(); err != nil {
 		slog.Error("failed close redisstream publisher", slog.Any("err", err))
@@ -157,3 +242,33 @@ func main() {
 		slog.Error("failed shutdown tracer provider", slog.Any("err", err))
 	}
 }
+
+// addWriterLanes adds the handler routing the sent messages to the lanes by the room and a writer handler per lane of the instance.
+// Every handler processes its messages one by one in its own goroutine, so the lanes write different rooms in parallel.
+// Both the partitioner and every lane have a single consumer, so the messages of a room are routed and written in order.
+func addWriterLanes(r *message.Router, cfg writerConfig, sub message.Subscriber, pub message.Publisher, w *writer.Writer, relay *outbox.Relay, validator *events.Validator, upcasters *events.Upcasters, storeLatency *events.Latency) {
+	metrics, err := event.NewLaneMetrics()
+	if err != nil {
+		log.Fatalf("failed create writer lane metrics: %s", err)
+	}
+	if cfg.owns(0) {
+		// the partitioner takes over the consumer group of the single writer, so switching to the lanes continues the stream.
+		// The events are upcasted first, so the room is read from the current version.
+		r.AddNoPublisherHandler("history-writer",
+			events.TopicMsgSent,
+			sub,
+			event.MsgSentEvtPartitionHandler(events.TopicMsgSent, cfg.Lanes, pub)).
+			AddMiddleware(upcasters.Middleware(events.TopicMsgSent))
+	}
+	for lane := 0; lane < cfg.Lanes; lane++ {
+		if !cfg.owns(lane) {
+			continue
+		}
+		laneTopic := event.LaneTopic(events.TopicMsgSent, lane)
+		r.AddNoPublisherHandler(fmt.Sprintf("history-writer-lane-%d", lane),
+			laneTopic,
+			sub,
+			metrics.Handler(lane, storeLatency.Handler(event.MsgSentEvtHandler(laneTopic, events.TopicMsgStored, w, relay, validator)))).
+			AddMiddleware(upcasters.Middleware(events.TopicMsgSent))
+	}
+}
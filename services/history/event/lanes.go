@@ -0,0 +1,96 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/events"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// laneEnqueuedAtKey is the metadata key of the time the event was routed to the lane.
+const laneEnqueuedAtKey = "lane_enqueued_at"
+
+// LaneTopic returns the topic of the writer lane.
+func LaneTopic(topic string, lane int) string {
+	return topic + ".lane." + strconv.Itoa(lane)
+}
+
+// Lane returns the lane of the chat room. The messages of the room always go to the same lane, so they keep their order.
+func Lane(chatRoomID string, lanes int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(chatRoomID))
+	return int(h.Sum32() % uint32(lanes))
+}
+
+// MsgSentEvtPartitionHandler routes the sent messages to the writer lanes by the chat room,
+// so the lanes write different rooms in parallel while every room is written in order.
+// The events that can't be decoded go to the first lane, where they are skipped by the writer.
+func MsgSentEvtPartitionHandler(topic string, lanes int, pub message.Publisher) message.NoPublishHandlerFunc {
+	return func(msg *message.Message) error {
+		subLogger := slogbrick.WithOTELTrace(msg.Context(), slog.With(slog.String("topic", topic)))
+		msg.SetContext(slogbrick.ToCtx(msg.Context(), subLogger))
+
+		var lane int
+		evt := events.MsgSent{}
+		if err := json.Unmarshal(msg.Payload, &evt); err == nil {
+			lane = Lane(evt.ChatRoomID, lanes)
+		}
+		laneMsg := message.NewMessage(watermill.NewUUID(), msg.Payload)
+		for k, v := range msg.Metadata {
+			laneMsg.Metadata.Set(k, v)
+		}
+		laneMsg.Metadata.Set(laneEnqueuedAtKey, time.Now().UTC().Format(time.RFC3339Nano))
+		laneMsg.SetContext(msg.Context())
+		if err := pub.Publish(LaneTopic(topic, lane), laneMsg); err != nil {
+			subLogger.Error("failed route msg to lane", slog.Int("lane", lane), slog.Any("err", err))
+			return fmt.Errorf("failed route msg to lane %d: %w", lane, err)
+		}
+		return nil
+	}
+}
+
+// LaneMetrics records the metrics of the writer lanes.
+type LaneMetrics struct {
+	lag       metric.Int64Histogram
+	processed metric.Int64Counter
+}
+
+// NewLaneMetrics creates a new LaneMetrics.
+func NewLaneMetrics() (*LaneMetrics, error) {
+	meter := otel.GetMeterProvider().Meter("history/writer")
+	lag, err := meter.Int64Histogram("history_writer_lane_lag",
+		metric.WithDescription("The time the event waits in the writer lane before it is processed"), metric.WithUnit("ms"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create history_writer_lane_lag metric: %w", err)
+	}
+	processed, err := meter.Int64Counter("history_writer_lane_processed_count",
+		metric.WithDescription("The number of events processed by the writer lane"))
+	if err != nil {
+		return nil, fmt.Errorf("failed create history_writer_lane_processed_count metric: %w", err)
+	}
+	return &LaneMetrics{lag: lag, processed: processed}, nil
+}
+
+// Handler wraps the handler of the lane with the metrics.
+func (m *LaneMetrics) Handler(lane int, h message.NoPublishHandlerFunc) message.NoPublishHandlerFunc {
+	laneAttr := attribute.Int("lane", lane)
+	return func(msg *message.Message) error {
+		ctx := msg.Context()
+		if enqueuedAt, err := time.Parse(time.RFC3339Nano, msg.Metadata.Get(laneEnqueuedAtKey)); err == nil {
+			m.lag.Record(ctx, time.Since(enqueuedAt).Milliseconds(), metric.WithAttributes(laneAttr))
+		}
+		err := h(msg)
+		m.processed.Add(ctx, 1, metric.WithAttributes(laneAttr, attribute.Bool("error", err != nil)))
+		return err
+	}
+}
@@ -0,0 +1,60 @@
+package event
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type published struct {
+	topic string
+	msg   *message.Message
+}
+
+type recordingPublisher struct {
+	msgs []published
+}
+
+func (p *recordingPublisher) Publish(topic string, msgs ...*message.Message) error {
+	for _, msg := range msgs {
+		p.msgs = append(p.msgs, published{topic: topic, msg: msg})
+	}
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+func TestMsgSentEvtPartitionHandler(t *testing.T) {
+	const lanes = 4
+	room := "room-1"
+	lane := Lane(room, lanes)
+	assert.Equal(t, lane, Lane(room, lanes), "the room must always go to the same lane")
+
+	pub := &recordingPublisher{}
+	h := MsgSentEvtPartitionHandler("msg_sent", lanes, pub)
+	for i := 0; i < 3; i++ {
+		payload, err := json.Marshal(events.MsgSent{ChatRoomID: room, Msg: string(rune('a' + i)), CreatedAt: time.Now()})
+		require.NoError(t, err)
+		msg := message.NewMessage(watermill.NewUUID(), payload)
+		msg.Metadata.Set("trace", "1")
+		require.NoError(t, h(msg))
+	}
+
+	require.Len(t, pub.msgs, 3)
+	for i, p := range pub.msgs {
+		assert.Equal(t, LaneTopic("msg_sent", lane), p.topic)
+		var evt events.MsgSent
+		require.NoError(t, json.Unmarshal(p.msg.Payload, &evt))
+		assert.Equal(t, string(rune('a'+i)), evt.Msg, "the room messages must keep their order")
+		assert.Equal(t, "1", p.msg.Metadata.Get("trace"))
+		assert.NotEmpty(t, p.msg.Metadata.Get(laneEnqueuedAtKey))
+	}
+}
@@ -1,90 +1,103 @@
 package event
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
-	"time"
 
-	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/demeero/bricks/errbrick"
 	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"github.com/demeero/chat/history/outbox"
 	"github.com/demeero/chat/history/writer"
+	"github.com/gocql/gocql"
 )
 
-type msgEvtUser struct {
-	ID        string `json:"id"`
-	Email     string `json:"email"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-}
+// gregorianOffset is the number of the 100-nanosecond intervals between the UUID epoch 1582-10-15 and the Unix epoch.
+const gregorianOffset = 0x01B21DD213814000
 
-type msgSentEvt struct {
-	ChatRoomID string     `json:"chat_room_id"`
-	PendingID  string     `json:"pending_id"`
-	Msg        string     `json:"msg"`
-	User       msgEvtUser `json:"user"`
-	CreatedAt  time.Time  `json:"created_at"`
+// msgID returns the TimeUUID of the message derived from the event, so the redelivered event rewrites the same message
+// instead of storing a duplicate. The events without the pending ID get the random message ID.
+func msgID(e events.MsgSent) string {
+	if e.PendingID == "" {
+		return ""
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(e.ChatRoomID + "\x00" + e.User.ID + "\x00" + e.PendingID))
+	sum := h.Sum(nil)
+	ts := e.CreatedAt.UnixNano()/100 + gregorianOffset
+	return gocql.TimeUUIDWith(ts, binary.BigEndian.Uint32(sum[:4]), sum[2:]).String()
 }
 
-func (e *msgSentEvt) WriteParams() writer.CreateParams {
+func writeParams(e events.MsgSent) writer.CreateParams {
 	return writer.CreateParams{
+		MsgID:      msgID(e),
 		RoomChatID: e.ChatRoomID,
 		Msg:        e.Msg,
 		CreatedAt:  e.CreatedAt,
 		PendingID:  e.PendingID,
-		User: writer.UserParams{
-			ID:        e.User.ID,
-			Email:     e.User.Email,
-			FirstName: e.User.FirstName,
-			LastName:  e.User.LastName,
-		},
+		User:       writer.UserParams{ID: e.User.ID},
 	}
 }
 
-type msgStoredEvt struct {
-	MsgID      string `json:"msg_id"`
-	msgSentEvt `json:",inline"`
-}
-
-func MsgSentEvtHandler(topic string, w *writer.Writer) message.HandlerFunc {
-	return func(msg *message.Message) ([]*message.Message, error) {
+// MsgSentEvtHandler stores the sent messages. The stored message event is written to the outbox together with the message
+// and is published right after the write. If the publishing fails, the event is published later by the outbox relay.
+func MsgSentEvtHandler(topic, storedTopic string, w *writer.Writer, relay *outbox.Relay, v *events.Validator) message.NoPublishHandlerFunc {
+	return func(msg *message.Message) error {
 		subLogger := slogbrick.WithOTELTrace(msg.Context(), slog.With(slog.String("topic", topic)))
 		ctx := slogbrick.ToCtx(msg.Context(), subLogger)
 		msg.SetContext(ctx)
 
-		evt := msgSentEvt{}
-		err := json.Unmarshal(msg.Payload, &evt)
-		if err != nil {
-			subLogger.Error("failed decode msg - skip", slog.Any("err", err), slog.String("payload", string(msg.Payload)))
+		evt := events.MsgSent{}
+		if err := v.Decode(ctx, topic, msg.Payload, &evt); err != nil {
+			subLogger.Error("invalid msg - skip", slog.Any("err", err), slog.String("payload", string(msg.Payload)))
 			msg.Ack()
-			return nil, fmt.Errorf("failed decode msg: %w", err)
+			return fmt.Errorf("invalid msg: %w", err)
 		}
 
-		msgID, err := w.Create(ctx, evt.WriteParams())
+		rec, err := w.CreateWithEvent(ctx, writeParams(evt), func(rec writer.Record) (outbox.Entry, error) {
+			storedEvtBytes, err := json.Marshal(events.NewMsgStored(rec.MsgID, rec.Seq, evt))
+			if err != nil {
+				return outbox.Entry{}, fmt.Errorf("%w: failed encode stored evt: %s", errbrick.ErrInvalidData, err)
+			}
+			entry := outbox.NewEntry(ctx, storedTopic, rec.RoomChatID, storedEvtBytes)
+			events.SetVersion(entry.Metadata, events.MsgStoredVersion)
+			watermillbrick.SetSubject(entry.Metadata, rec.RoomChatID)
+			// the send time is carried over, so the consumers of the stored message measure the latency since the send
+			if sentAt := msg.Metadata.Get(events.SentAtKey); sentAt != "" {
+				entry.Metadata[events.SentAtKey] = sentAt
+			}
+			return entry, nil
+		})
 		if errbrick.IsOneOf(err) {
 			subLogger.Error("failed write history - skip", slog.Any("err", err))
 			msg.Ack()
-			return nil, fmt.Errorf("failed write history: %w", err)
+			return fmt.Errorf("failed write history: %w", err)
 		}
 		if err != nil {
 			subLogger.Error("failed write history due to unexpected error", slog.Any("err", err))
-			return nil, fmt.Errorf("failed write history: %w", err)
+			return fmt.Errorf("failed write history: %w", err)
 		}
-
-		storedEvt := msgStoredEvt{
-			MsgID:      msgID,
-			msgSentEvt: evt,
+		if rec.Event == nil {
+			return nil
 		}
-		storedEvtBytes, err := json.Marshal(storedEvt)
-		if err != nil {
-			subLogger.Error("failed encode stored evt", slog.Any("err", err))
-			msg.Ack()
-			return nil, fmt.Errorf("failed encode stored evt: %w", err)
+		if rec.Duplicate {
+			// the event of the redelivered message is published again with the stored sequence number,
+			// it's not written to the outbox, so the failed publish is retried by the redelivery
+			if err := relay.Publish(ctx, *rec.Event); err != nil {
+				subLogger.Error("failed republish stored evt", slog.Any("err", err))
+				return fmt.Errorf("failed republish stored evt: %w", err)
+			}
+			return nil
+		}
+		if err := relay.Publish(ctx, *rec.Event); err != nil {
+			// the message and its event are stored, so the retry would only rewrite them
+			subLogger.Warn("failed publish stored evt - left to outbox relay", slog.Any("err", err))
 		}
-		storedEvtMsg := message.NewMessage(watermill.NewUUID(), storedEvtBytes)
-		storedEvtMsg.SetContext(ctx)
-		return []*message.Message{storedEvtMsg}, nil
+		return nil
 	}
 }
@@ -0,0 +1,105 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"github.com/demeero/chat/history/cache"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/search"
+)
+
+// NewMsgStoredMsg creates the msg_stored event of the stored message, e.g. to replay it.
+func NewMsgStoredMsg(ctx context.Context, chatRoomID string, m loader.Message) (*message.Message, error) {
+	b, err := json.Marshal(events.NewMsgStored(m.ID, m.Seq, events.MsgSent{
+		ChatRoomID: chatRoomID,
+		PendingID:  m.PendingID,
+		Msg:        m.Msg,
+		User:       events.User{ID: m.User.ID},
+		CreatedAt:  m.CreatedAt,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed encode stored evt: %w", err)
+	}
+	msg := message.NewMessage(watermill.NewUUID(), b)
+	events.SetVersion(msg.Metadata, events.MsgStoredVersion)
+	watermillbrick.SetSubject(msg.Metadata, chatRoomID)
+	msg.SetContext(ctx)
+	return msg, nil
+}
+
+func loaderMsg(e events.MsgStored) loader.Message {
+	return loader.Message{
+		ID:        e.MsgID,
+		Seq:       e.Seq,
+		PendingID: e.PendingID,
+		Msg:       e.Msg,
+		User:      loader.MsgUser{ID: e.User.ID},
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+func searchDoc(e events.MsgStored) search.Document {
+	return search.Document{
+		MsgID:      e.MsgID,
+		ChatRoomID: e.ChatRoomID,
+		PendingID:  e.PendingID,
+		Msg:        e.Msg,
+		UserID:     e.User.ID,
+		CreatedAt:  e.CreatedAt,
+	}
+}
+
+// MsgStoredEvtIndexHandler feeds the search index with the stored messages. The invalid messages are skipped.
+func MsgStoredEvtIndexHandler(topic string, idx *search.Index, v *events.Validator) message.NoPublishHandlerFunc {
+	return func(msg *message.Message) error {
+		subLogger := slogbrick.WithOTELTrace(msg.Context(), slog.With(slog.String("topic", topic)))
+		msg.SetContext(slogbrick.ToCtx(msg.Context(), subLogger))
+
+		evt := events.MsgStored{}
+		if err := v.Decode(msg.Context(), topic, msg.Payload, &evt); err != nil {
+			subLogger.Error("invalid msg - skip", slog.Any("err", err), slog.String("payload", string(msg.Payload)))
+			return nil
+		}
+		err := idx.Index(searchDoc(evt))
+		if errors.Is(err, errbrick.ErrInvalidData) {
+			// the document can't be indexed on retry either
+			subLogger.Error("invalid doc - skip", slog.Any("err", err), slog.String("payload", string(msg.Payload)))
+			return nil
+		}
+		if err != nil {
+			subLogger.Error("failed index msg", slog.Any("err", err))
+			return fmt.Errorf("failed index msg: %w", err)
+		}
+		return nil
+	}
+}
+
+// MsgStoredEvtCacheHandler keeps the recent messages cache up to date with the stored messages. The invalid messages are skipped.
+func MsgStoredEvtCacheHandler(topic string, recent *cache.Recent, v *events.Validator) message.NoPublishHandlerFunc {
+	return func(msg *message.Message) error {
+		subLogger := slogbrick.WithOTELTrace(msg.Context(), slog.With(slog.String("topic", topic)))
+		ctx := slogbrick.ToCtx(msg.Context(), subLogger)
+		msg.SetContext(ctx)
+
+		evt := events.MsgStored{}
+		if err := v.Decode(msg.Context(), topic, msg.Payload, &evt); err != nil {
+			subLogger.Error("invalid msg - skip", slog.Any("err", err), slog.String("payload", string(msg.Payload)))
+			return nil
+		}
+		if err := recent.Push(ctx, evt.ChatRoomID, loaderMsg(evt)); err != nil {
+			subLogger.Error("failed cache msg", slog.Any("err", err))
+			return fmt.Errorf("failed cache msg: %w", err)
+		}
+		return nil
+	}
+}
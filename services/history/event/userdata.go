@@ -0,0 +1,41 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/history/privacy"
+	"github.com/demeero/chat/history/search"
+)
+
+// UserDataAuditEvtIndexHandler erases the messages of the user from the search index once they are erased from the store.
+func UserDataAuditEvtIndexHandler(topic string, idx *search.Index) message.NoPublishHandlerFunc {
+	return func(msg *message.Message) error {
+		subLogger := slogbrick.WithOTELTrace(msg.Context(), slog.With(slog.String("topic", topic)))
+		ctx := slogbrick.ToCtx(msg.Context(), subLogger)
+		msg.SetContext(ctx)
+
+		evt := privacy.AuditEvt{}
+		if err := json.Unmarshal(msg.Payload, &evt); err != nil {
+			subLogger.Error("failed decode msg - skip", slog.Any("err", err), slog.String("payload", string(msg.Payload)))
+			return nil
+		}
+		if evt.Type != privacy.EvtErased {
+			return nil
+		}
+		anonymousID := ""
+		if evt.Mode == privacy.Anonymize {
+			anonymousID = evt.AnonymousID
+		}
+		erased, err := idx.EraseUser(ctx, evt.UserID, anonymousID)
+		if err != nil {
+			subLogger.Error("failed erase user from search index", slog.Any("err", err), slog.String("job_id", evt.JobID))
+			return fmt.Errorf("failed erase user from search index: %w", err)
+		}
+		subLogger.Info("erased user from search index", slog.String("job_id", evt.JobID), slog.Int("messages", erased))
+		return nil
+	}
+}
@@ -0,0 +1,223 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/chat/history/loader"
+)
+
+// Format is the format of the room history export.
+type Format string
+
+const (
+	JSON   Format = "json"
+	NDJSON Format = "ndjson"
+	CSV    Format = "csv"
+	HTML   Format = "html"
+)
+
+// ParseFormat parses the export format. The empty string means JSON.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "":
+		return JSON, nil
+	case JSON, NDJSON, CSV, HTML:
+		return f, nil
+	default:
+		return "", fmt.Errorf("%w: unsupported export format %q", errbrick.ErrInvalidData, s)
+	}
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case NDJSON:
+		return "application/x-ndjson"
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case HTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// FileName returns the name of the export file of the room.
+func (f Format) FileName(roomChatID string) string {
+	return fmt.Sprintf("room-%s.%s", roomChatID, f)
+}
+
+// encoder writes the messages one by one, so the export never keeps the whole history in memory.
+type encoder interface {
+	begin() error
+	encode(m loader.Message) error
+	end() error
+}
+
+// flushEvery is the number of the messages written between the flushes.
+const flushEvery = 100
+
+// Room writes the whole history of the room to w from the oldest message to the newest.
+// The history is streamed page by page. When flush is not nil, it is called after every flushEvery messages and at the end.
+func Room(ctx context.Context, l *loader.Loader, roomChatID string, f Format, w io.Writer, flush func()) error {
+	if flush == nil {
+		flush = func() {}
+	}
+	enc := newEncoder(f, w, roomChatID)
+	if err := enc.begin(); err != nil {
+		return fmt.Errorf("failed begin export: %w", err)
+	}
+	var n int
+	err := l.Walk(ctx, roomChatID, func(m loader.Message) error {
+		if err := enc.encode(m); err != nil {
+			return fmt.Errorf("failed encode msg %s: %w", m.ID, err)
+		}
+		if n++; n%flushEvery == 0 {
+			flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.end(); err != nil {
+		return fmt.Errorf("failed end export: %w", err)
+	}
+	flush()
+	return nil
+}
+
+func newEncoder(f Format, w io.Writer, roomChatID string) encoder {
+	switch f {
+	case NDJSON:
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}
+	case CSV:
+		return &csvEncoder{w: csv.NewWriter(w)}
+	case HTML:
+		return &htmlEncoder{w: w, roomChatID: roomChatID}
+	default:
+		return &jsonEncoder{w: w}
+	}
+}
+
+type jsonEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonEncoder) begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonEncoder) encode(m loader.Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *jsonEncoder) end() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) begin() error {
+	return nil
+}
+
+func (e *ndjsonEncoder) encode(m loader.Message) error {
+	return e.enc.Encode(m)
+}
+
+func (e *ndjsonEncoder) end() error {
+	return nil
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) begin() error {
+	return e.write([]string{"id", "seq", "created_at", "user_id", "user_email", "user_first_name", "user_last_name", "pending_id", "msg"})
+}
+
+func (e *csvEncoder) encode(m loader.Message) error {
+	return e.write([]string{
+		m.ID, strconv.FormatInt(m.Seq, 10), m.CreatedAt.Format(time.RFC3339Nano), m.User.ID, m.User.Email, m.User.FirstName, m.User.LastName, m.PendingID, m.Msg,
+	})
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) write(record []string) error {
+	if err := e.w.Write(record); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+var (
+	htmlBegin = template.Must(template.New("begin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Room {{.}} history</title>
+<style>
+body { font-family: sans-serif; }
+.msg { margin: 0.5em 0; }
+.meta { color: #666; font-size: 0.85em; }
+.text { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Room {{.}} history</h1>
+`))
+	htmlMsg = template.Must(template.New("msg").Parse(`<div class="msg" id="{{.ID}}">
+<div class="meta"><time datetime="{{.CreatedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{.CreatedAt.Format "2006-01-02 15:04:05 MST"}}</time> {{.User.FirstName}} {{.User.LastName}} &lt;{{.User.Email}}&gt;</div>
+<div class="text">{{.Msg}}</div>
+</div>
+`))
+)
+
+type htmlEncoder struct {
+	w          io.Writer
+	roomChatID string
+}
+
+func (e *htmlEncoder) begin() error {
+	return htmlBegin.Execute(e.w, e.roomChatID)
+}
+
+func (e *htmlEncoder) encode(m loader.Message) error {
+	return htmlMsg.Execute(e.w, m)
+}
+
+func (e *htmlEncoder) end() error {
+	_, err := io.WriteString(e.w, "</body>\n</html>\n")
+	return err
+}
@@ -0,0 +1,74 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/storage/sqlstore"
+	"github.com/demeero/chat/history/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoom(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlstore.Open(ctx, sqlstore.SQLite, filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	w := writer.New(s, nil, nil)
+	start := time.Now().UTC()
+	for i, msg := range []string{"hello", "<script>alert(1)</script>", "a, \"quoted\"\nline"} {
+		_, err := w.Create(ctx, writer.CreateParams{
+			RoomChatID: "room-1",
+			Msg:        msg,
+			CreatedAt:  start.Add(time.Duration(i) * time.Second),
+			User:       writer.UserParams{ID: "user-1"},
+		})
+		require.NoError(t, err)
+	}
+	l := loader.New(s, nil, nil)
+
+	dump := func(f Format) string {
+		var buf bytes.Buffer
+		require.NoError(t, Room(ctx, l, "room-1", f, &buf, nil))
+		return buf.String()
+	}
+
+	var msgs []loader.Message
+	require.NoError(t, json.Unmarshal([]byte(dump(JSON)), &msgs))
+	require.Len(t, msgs, 3)
+	assert.Equal(t, "hello", msgs[0].Msg)
+
+	lines := strings.Split(strings.TrimSpace(dump(NDJSON)), "\n")
+	assert.Len(t, lines, 3)
+
+	records, err := csv.NewReader(strings.NewReader(dump(CSV))).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, "a, \"quoted\"\nline", records[3][len(records[3])-1])
+
+	html := dump(HTML)
+	assert.NotContains(t, html, "<script>")
+	assert.Contains(t, html, "&lt;script&gt;")
+
+	var empty bytes.Buffer
+	require.NoError(t, Room(ctx, l, "room-2", JSON, &empty, nil))
+	assert.JSONEq(t, "[]", empty.String())
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, JSON, f)
+	_, err = ParseFormat("xml")
+	assert.ErrorIs(t, err, errbrick.ErrInvalidData)
+}
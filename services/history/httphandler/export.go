@@ -0,0 +1,41 @@
+package httphandler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/history/export"
+	"github.com/demeero/chat/history/loader"
+	"github.com/labstack/echo/v4"
+)
+
+// ExportHistory streams the whole history of the chat room in the requested format.
+// The messages are written as they are loaded, so the history is never kept in memory.
+func ExportHistory(l *loader.Loader) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		f, err := export.ParseFormat(c.QueryParam("format"))
+		if err != nil {
+			return err
+		}
+		roomChatID := c.Param("room_chat_id")
+		resp := c.Response()
+		// the export of a large room may take longer than the server write timeout
+		if err := http.NewResponseController(resp.Writer).SetWriteDeadline(time.Time{}); err != nil {
+			slogbrick.FromCtx(c.Request().Context()).Warn("failed reset write deadline", slog.Any("err", err))
+		}
+		resp.Header().Set(echo.HeaderContentType, f.ContentType())
+		resp.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", f.FileName(roomChatID)))
+		resp.WriteHeader(http.StatusOK)
+		if err := export.Room(c.Request().Context(), l, roomChatID, f, resp, resp.Flush); err != nil {
+			// the status has already been sent, so log the error and abort the response,
+			// the client gets a broken stream instead of the truncated export which looks complete
+			slogbrick.FromCtx(c.Request().Context()).Error("failed export chat history",
+				slog.String("room_chat_id", roomChatID), slog.Any("err", err))
+			panic(http.ErrAbortHandler)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package httphandler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/demeero/chat/bricks/httpsrv"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/storage/sqlstore"
+	"github.com/demeero/chat/history/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportHistory(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlstore.Open(ctx, sqlstore.SQLite, filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	for _, msg := range []string{"hi", "bye"} {
+		_, err := writer.New(s, nil, nil).Create(ctx, writer.CreateParams{
+			RoomChatID: "room",
+			Msg:        msg,
+			CreatedAt:  time.Now(),
+			User:       writer.UserParams{ID: "u1"},
+		})
+		require.NoError(t, err)
+	}
+
+	e := httpsrv.Configure(httpsrv.Config{})
+	e.Use(recoverMW())
+	e.GET("/:room_chat_id/export", ExportHistory(loader.New(s, nil, nil)))
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/room/export?format=ndjson")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"msg":"bye"`)
+
+	// the export failing after the status is sent must not end like a complete one
+	require.NoError(t, s.Close())
+	resp, err = http.Get(srv.URL + "/room/export?format=ndjson")
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+	assert.Error(t, err)
+}
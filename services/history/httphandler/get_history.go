@@ -8,10 +8,11 @@ import (
 
 	"github.com/demeero/bricks/errbrick"
 	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/retention"
 	"github.com/labstack/echo/v4"
 )
 
-func GetHistory(l *loader.Loader) func(c echo.Context) error {
+func GetHistory(l *loader.Loader, retentions *retention.Cache) func(c echo.Context) error {
 	return func(c echo.Context) error {
 		pSize := c.QueryParam("page_size")
 		if pSize == "" {
@@ -25,10 +26,15 @@ func GetHistory(l *loader.Loader) func(c echo.Context) error {
 		if err != nil {
 			return err
 		}
-		msgs, pt, err := l.Load(c.Request().Context(), c.Param("room_chat_id"), p)
+		roomChatID := c.Param("room_chat_id")
+		msgs, pt, err := l.Load(c.Request().Context(), roomChatID, p)
 		if err != nil {
 			return fmt.Errorf("failed load chat history: %w", err)
 		}
+		policy, err := retentions.Policy(c.Request().Context(), roomChatID)
+		if err != nil {
+			return fmt.Errorf("failed load room retention: %w", err)
+		}
 		if msgs == nil {
 			msgs = []loader.Message{}
 		}
@@ -36,6 +42,8 @@ func GetHistory(l *loader.Loader) func(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"page":            msgs,
 			"next_page_token": pt,
+			// zero means the messages of the room are kept permanently
+			"retention_days": policy.Days,
 		})
 	}
 }
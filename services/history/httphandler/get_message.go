@@ -0,0 +1,31 @@
+package httphandler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/demeero/chat/history/loader"
+	"github.com/labstack/echo/v4"
+)
+
+// GetMessage returns a single message of the chat room by its ID.
+func GetMessage(l *loader.Loader) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		msg, err := l.Get(c.Request().Context(), c.Param("room_chat_id"), c.Param("msg_id"))
+		if err != nil {
+			return fmt.Errorf("failed load message: %w", err)
+		}
+		return c.JSON(http.StatusOK, msg)
+	}
+}
+
+// GetMessageByPendingID resolves the client-side pending ID of the sent message into the stored message.
+func GetMessageByPendingID(l *loader.Loader) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		msg, err := l.GetByPendingID(c.Request().Context(), c.Param("room_chat_id"), c.Param("pending_id"))
+		if err != nil {
+			return fmt.Errorf("failed load message by pending id: %w", err)
+		}
+		return c.JSON(http.StatusOK, msg)
+	}
+}
@@ -0,0 +1,67 @@
+package httphandler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/demeero/chat/bricks/httpsrv"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/storage/sqlstore"
+	"github.com/demeero/chat/history/writer"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMessage(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlstore.Open(ctx, sqlstore.SQLite, filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	rec, err := writer.New(s, nil, nil).Create(ctx, writer.CreateParams{
+		RoomChatID: "room",
+		Msg:        "hi",
+		PendingID:  "pending-1",
+		CreatedAt:  time.Now(),
+		User:       writer.UserParams{ID: "u1"},
+	})
+	require.NoError(t, err)
+
+	l := loader.New(s, nil, nil)
+	e := httpsrv.Configure(httpsrv.Config{})
+	e.GET("/:room_chat_id/messages/:msg_id", GetMessage(l))
+	e.GET("/:room_chat_id/pending/:pending_id", GetMessageByPendingID(l))
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "by id", path: "/room/messages/" + rec.MsgID, status: http.StatusOK},
+		{name: "by pending id", path: "/room/pending/pending-1", status: http.StatusOK},
+		{name: "unknown id", path: "/room/messages/" + gocql.TimeUUID().String(), status: http.StatusNotFound},
+		{name: "unknown pending id", path: "/room/pending/pending-2", status: http.StatusNotFound},
+		{name: "id of another room", path: "/other/messages/" + rec.MsgID, status: http.StatusNotFound},
+		{name: "pending id of another room", path: "/other/pending/pending-1", status: http.StatusNotFound},
+		{name: "invalid id", path: "/room/messages/1", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, http.NoBody))
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			if tt.status != http.StatusOK {
+				return
+			}
+			var msg loader.Message
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &msg))
+			assert.Equal(t, rec.MsgID, msg.ID)
+			assert.Equal(t, "hi", msg.Msg)
+		})
+	}
+}
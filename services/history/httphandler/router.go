@@ -4,18 +4,21 @@ import (
 	"context"
 	"log"
 	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/MicahParks/keyfunc/v2"
 	"github.com/demeero/bricks/echobrick"
 	"github.com/demeero/chat/bricks/httpsrv"
 	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/search"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 )
 
-func Setup(ctx context.Context, jwksURL, serviceName string, e *echo.Echo, l *loader.Loader) error {
+func Setup(ctx context.Context, jwksURL, serviceName string, e *echo.Echo, l *loader.Loader, idx *search.Index, retentions *retention.Cache) error {
 	meterMW, err := echobrick.OTELMeterMW(echobrick.OTELMeterMWConfig{
 		Attrs: &echobrick.OTELMeterAttrsConfig{
 			Method:     true,
@@ -35,7 +38,7 @@ func Setup(ctx context.Context, jwksURL, serviceName string, e *echo.Echo, l *lo
 		log.Fatalf("failed create meter middleware: %s", err)
 	}
 	e.Pre(echomw.RemoveTrailingSlash())
-	e.Use(echobrick.RecoverSlogMW())
+	e.Use(recoverMW())
 	e.Use(otelecho.Middleware(serviceName))
 	e.Use(meterMW)
 	e.Use(echobrick.SlogCtxMW(echobrick.LogCtxMWConfig{Trace: true}))
@@ -51,7 +54,11 @@ func Setup(ctx context.Context, jwksURL, serviceName string, e *echo.Echo, l *lo
 	}))
 	e.Use(httpsrv.SessionCtxMW())
 	e.Use(echobrick.SlogLogMW(slog.LevelDebug, nil))
-	e.GET("/:room_chat_id", GetHistory(l))
+	e.GET("/search", Search(idx, l))
+	e.GET("/:room_chat_id", GetHistory(l, retentions))
+	e.GET("/:room_chat_id/messages/:msg_id", GetMessage(l))
+	e.GET("/:room_chat_id/pending/:pending_id", GetMessageByPendingID(l))
+	e.GET("/:room_chat_id/export", ExportHistory(l))
 
 	for _, r := range e.Routes() {
 		if r != nil {
@@ -62,3 +69,29 @@ func Setup(ctx context.Context, jwksURL, serviceName string, e *echo.Echo, l *lo
 
 	return nil
 }
+
+// recoverMW is the echobrick.RecoverSlogMW letting the http.ErrAbortHandler panic through to the server,
+// so the handler which has already sent the status can still abort the response.
+func recoverMW() echo.MiddlewareFunc {
+	recoverSlog := echobrick.RecoverSlogMW()
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var aborted bool
+			err := recoverSlog(func(c echo.Context) error {
+				defer func() {
+					if r := recover(); r != nil {
+						if r != http.ErrAbortHandler {
+							panic(r)
+						}
+						aborted = true
+					}
+				}()
+				return next(c)
+			})(c)
+			if aborted {
+				panic(http.ErrAbortHandler)
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package httphandler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/chat/bricks/session"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/search"
+	"github.com/labstack/echo/v4"
+)
+
+// Search performs the full-text search over the history of the rooms the caller is a member of.
+// The index keeps only the author IDs, so the hits are hydrated with the author profiles by the loader.
+func Search(idx *search.Index, l *loader.Loader) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		q, err := newSearchQuery(c)
+		if err != nil {
+			return err
+		}
+		ctx := c.Request().Context()
+		res, err := idx.Search(ctx, q)
+		if err != nil {
+			return fmt.Errorf("failed search history: %w", err)
+		}
+		msgs := make([]loader.Message, 0, len(res.Hits))
+		for _, h := range res.Hits {
+			msgs = append(msgs, h.Message)
+		}
+		l.Hydrate(ctx, msgs)
+		for i := range res.Hits {
+			res.Hits[i].Message = msgs[i]
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+func newSearchQuery(c echo.Context) (search.Query, error) {
+	q := search.Query{
+		Text:       c.QueryParam("q"),
+		CallerID:   session.FromCtx(c.Request().Context()).Identity.ID,
+		ChatRoomID: c.QueryParam("room"),
+		UserID:     c.QueryParam("user"),
+		PageToken:  c.QueryParam("page_token"),
+	}
+	if pSize := c.QueryParam("page_size"); pSize != "" {
+		pSizeInt, err := strconv.ParseUint(pSize, 10, 16)
+		if err != nil {
+			return search.Query{}, fmt.Errorf("%w: failed parse page size: %s", errbrick.ErrInvalidData, err)
+		}
+		q.PageSize = uint16(pSizeInt)
+	}
+	var err error
+	if q.From, err = parseTimeParam(c, "from"); err != nil {
+		return search.Query{}, err
+	}
+	if q.To, err = parseTimeParam(c, "to"); err != nil {
+		return search.Query{}, err
+	}
+	return q, nil
+}
+
+func parseTimeParam(c echo.Context, name string) (time.Time, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: failed parse %s: %s", errbrick.ErrInvalidData, name, err)
+	}
+	return t, nil
+}
@@ -2,108 +2,181 @@ package loader
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
-	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/bricks/slogbrick"
 	"github.com/gocql/gocql"
 )
 
+// MsgUser is the author of the message.
+// The stored messages carry only the ID, the rest is hydrated from the user profile at read time.
 type MsgUser struct {
 	ID        string `json:"id"`
 	Email     string `json:"email"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	AvatarURL string `json:"avatar_url,omitempty"`
 }
 
 type Message struct {
-	ID        string    `json:"id"`
+	ID string `json:"id"`
+	// Seq is the sequence number of the message in the room. It's zero for the messages stored before the sequencing.
+	Seq       int64     `json:"seq,omitempty"`
 	PendingID string    `json:"pending_id"`
 	Msg       string    `json:"msg"`
 	User      MsgUser   `json:"user"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
-type cqlMsg struct {
-	MsgID         string    `json:"msg_id"`
-	PendingID     string    `json:"pending_id"`
-	Msg           string    `json:"msg"`
-	UserID        string    `json:"user_id"`
-	UserEmail     string    `json:"user_email"`
-	UserFirstName string    `json:"user_first_name"`
-	UserLastName  string    `json:"user_last_name"`
-	CreatedAt     time.Time `json:"created_at"`
+// Store reads the chat history.
+// Implementations must return errbrick.ErrNotFound when the requested message does not exist.
+type Store interface {
+	// List returns up to limit messages of the chat room ordered from the newest to the oldest.
+	// If the cursor is not nil, only the messages older than the cursor are returned.
+	List(ctx context.Context, roomChatID string, cursor *Cursor, limit int) ([]Message, error)
+	// Scan returns up to limit messages of the chat room ordered from the oldest to the newest.
+	// If the cursor is not nil, only the messages newer than the cursor are returned.
+	Scan(ctx context.Context, roomChatID string, cursor *Cursor, limit int) ([]Message, error)
+	// Get returns the message by its ID.
+	Get(ctx context.Context, roomChatID, msgID string) (Message, error)
+	// GetByPendingID returns the latest message sent with the given pending ID.
+	GetByPendingID(ctx context.Context, roomChatID, pendingID string) (Message, error)
 }
 
-func newCQLMsgs(data []map[string]interface{}) ([]cqlMsg, error) {
-	b, err := json.Marshal(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed encode data: %w", err)
-	}
-	var cqlMsgs []cqlMsg
-	if err := json.Unmarshal(b, &cqlMsgs); err != nil {
-		return nil, fmt.Errorf("failed decode data: %w", err)
-	}
-	return cqlMsgs, nil
+// ProfileResolver resolves the profiles of the message authors.
+type ProfileResolver interface {
+	// Profiles returns the profiles of the users by their IDs. The users without a profile are missing in the result.
+	Profiles(ctx context.Context, userIDs []string) (map[string]MsgUser, error)
 }
 
-func (m cqlMsg) toMsg() Message {
-	return Message{
-		PendingID: m.PendingID,
-		ID:        m.MsgID,
-		Msg:       m.Msg,
-		User: MsgUser{
-			ID:        m.UserID,
-			Email:     m.UserEmail,
-			FirstName: m.UserFirstName,
-			LastName:  m.UserLastName,
-		},
-		CreatedAt: m.CreatedAt,
-	}
+// Redactor hides the data of the messages the reader must not see, e.g. the emails of the authors.
+type Redactor interface {
+	// Redact modifies the messages in place. The reader is identified by the context.
+	Redact(ctx context.Context, msgs []Message)
 }
 
+// walkPageSize is the number of messages loaded at once by Walk.
+const walkPageSize = 500
+
 type Loader struct {
-	sess *gocql.Session
+	store    Store
+	profiles ProfileResolver
+	redactor Redactor
 }
 
-func New(sess *gocql.Session) *Loader {
-	return &Loader{sess: sess}
+// New creates a new Loader. If profiles is nil, the messages are returned with the user data of the store as is.
+// If redactor is nil, nothing is redacted, e.g. for the operator tools.
+func New(store Store, profiles ProfileResolver, redactor Redactor) *Loader {
+	return &Loader{store: store, profiles: profiles, redactor: redactor}
 }
 
-func (l *Loader) Load(ctx context.Context, roomChatID string, p Pagination) ([]Message, string, error) {
-	q, err := l.buildQuery(ctx, roomChatID, int(p.pageSize), p.pageToken)
+// Hydrate fills the authors of the messages with their profiles and redacts what the reader must not see.
+// A failed profile lookup is logged and leaves the authors as they are, so the history is still readable.
+func (l *Loader) Hydrate(ctx context.Context, msgs []Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	l.hydrate(ctx, msgs)
+	if l.redactor != nil {
+		l.redactor.Redact(ctx, msgs)
+	}
+}
+
+func (l *Loader) hydrate(ctx context.Context, msgs []Message) {
+	if l.profiles == nil {
+		return
+	}
+	ids := make([]string, 0, len(msgs))
+	seen := make(map[string]struct{}, len(msgs))
+	for _, m := range msgs {
+		if _, ok := seen[m.User.ID]; ok {
+			continue
+		}
+		seen[m.User.ID] = struct{}{}
+		ids = append(ids, m.User.ID)
+	}
+	profiles, err := l.profiles.Profiles(ctx, ids)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed build query: %w", err)
+		slogbrick.FromCtx(ctx).Warn("failed resolve profiles - serve msgs as stored", slog.Any("err", err))
+		return
 	}
-	iter := q.Iter()
-	data, err := iter.SliceMap()
-	if errors.Is(err, gocql.ErrNotFound) {
-		return nil, "", nil
+	for i, m := range msgs {
+		if p, ok := profiles[m.User.ID]; ok {
+			p.ID = m.User.ID
+			msgs[i].User = p
+		}
 	}
+}
+
+func (l *Loader) hydrateOne(ctx context.Context, m Message, err error) (Message, error) {
+	if err != nil {
+		return m, err
+	}
+	msgs := []Message{m}
+	l.Hydrate(ctx, msgs)
+	return msgs[0], nil
+}
+
+func (l *Loader) Load(ctx context.Context, roomChatID string, p Pagination) ([]Message, string, error) {
+	msgs, err := l.store.List(ctx, roomChatID, p.cursor, int(p.pageSize)+1)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed slice map: %w", err)
+		return nil, "", fmt.Errorf("failed list messages: %w", err)
+	}
+	if len(msgs) <= int(p.pageSize) {
+		l.Hydrate(ctx, msgs)
+		return msgs, "", nil
 	}
-	cqlMsgs, err := newCQLMsgs(data)
+	msgs = msgs[:p.pageSize]
+	l.Hydrate(ctx, msgs)
+	last := msgs[len(msgs)-1]
+	pt, err := Cursor{CreatedAt: last.CreatedAt, MsgID: last.ID}.encode()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed create cql messages: %w", err)
+		return nil, "", fmt.Errorf("failed encode page token: %w", err)
+	}
+	return msgs, pt, nil
+}
+
+// Walk calls fn for every message of the chat room from the oldest to the newest.
+// The history is loaded page by page, so it is never kept in memory as a whole.
+func (l *Loader) Walk(ctx context.Context, roomChatID string, fn func(Message) error) error {
+	var cursor *Cursor
+	for {
+		msgs, err := l.store.Scan(ctx, roomChatID, cursor, walkPageSize)
+		if err != nil {
+			return fmt.Errorf("failed scan messages: %w", err)
+		}
+		l.Hydrate(ctx, msgs)
+		for _, m := range msgs {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		if len(msgs) < walkPageSize {
+			return nil
+		}
+		last := msgs[len(msgs)-1]
+		cursor = &Cursor{CreatedAt: last.CreatedAt, MsgID: last.ID}
 	}
-	pageState := iter.PageState()
-	return l.convertFromCQLMsgs(cqlMsgs), base64.StdEncoding.EncodeToString(pageState), nil
 }
 
-func (l *Loader) convertFromCQLMsgs(cqlMsgs []cqlMsg) []Message {
-	msgs := make([]Message, 0, len(cqlMsgs))
-	for _, m := range cqlMsgs {
-		msgs = append(msgs, m.toMsg())
+// Get returns the message with the given ID from the chat room.
+func (l *Loader) Get(ctx context.Context, roomChatID, msgID string) (Message, error) {
+	if _, err := gocql.ParseUUID(msgID); err != nil {
+		return Message{}, fmt.Errorf("%w: invalid msg id: %s", errbrick.ErrInvalidData, err)
 	}
-	return msgs
+	m, err := l.store.Get(ctx, roomChatID, msgID)
+	return l.hydrateOne(ctx, m, err)
 }
 
-func (l *Loader) buildQuery(ctx context.Context, roomChatID string, pSize int, pToken []byte) (*gocql.Query, error) {
-	return l.sess.Query("SELECT * FROM chat.history WHERE chat_room_id = ? ORDER BY created_at DESC, msg_id DESC", roomChatID).
-		WithContext(ctx).
-		PageState(pToken).
-		PageSize(pSize), nil
+// GetByPendingID returns the message that was sent with the given client-side pending ID.
+// If there are several such messages, the latest one is returned.
+func (l *Loader) GetByPendingID(ctx context.Context, roomChatID, pendingID string) (Message, error) {
+	if pendingID == "" {
+		return Message{}, fmt.Errorf("%w: pending id is empty", errbrick.ErrInvalidData)
+	}
+	m, err := l.store.GetByPendingID(ctx, roomChatID, pendingID)
+	return l.hydrateOne(ctx, m, err)
 }
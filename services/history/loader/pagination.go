@@ -2,15 +2,31 @@ package loader
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/demeero/bricks/errbrick"
 )
 
+// Cursor points to the last message of the previously loaded page.
+type Cursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	MsgID     string    `json:"msg_id"`
+}
+
+func (c Cursor) encode() (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
 // Pagination is the pagination parameters.
 type Pagination struct {
-	// PageToken is the token to get the next page.
-	pageToken []byte
+	// cursor is the position to get the next page from.
+	cursor *Cursor
 	// PageSize is the number of items per page.
 	pageSize uint16
 }
@@ -22,16 +38,19 @@ func NewPagination(pageToken string, pageSize uint16) (Pagination, error) {
 	if pageSize > 1000 {
 		pageSize = 1000
 	}
-	var tokenBytes []byte
+	var cursor *Cursor
 	if pageToken != "" {
 		b, err := base64.StdEncoding.DecodeString(pageToken)
 		if err != nil {
 			return Pagination{}, fmt.Errorf("%w: failed to decode token from base64: %s", errbrick.ErrInvalidData, err)
 		}
-		tokenBytes = b
+		cursor = &Cursor{}
+		if err := json.Unmarshal(b, cursor); err != nil {
+			return Pagination{}, fmt.Errorf("%w: failed to decode token: %s", errbrick.ErrInvalidData, err)
+		}
 	}
 	return Pagination{
-		pageSize:  pageSize,
-		pageToken: tokenBytes,
+		pageSize: pageSize,
+		cursor:   cursor,
 	}, nil
 }
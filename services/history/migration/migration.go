@@ -0,0 +1,284 @@
+package migration
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/gocql/gocql"
+)
+
+//go:embed cql/*.cql
+var migrationsFS embed.FS
+
+// Keyspace is the keyspace the migrations are applied to.
+const Keyspace = "chat"
+
+// Migration is a single versioned CQL migration.
+type Migration struct {
+	Name       string
+	Checksum   string
+	Statements []string
+	Version    int
+}
+
+// Status is the state of the migration in the database.
+type Status struct {
+	AppliedAt time.Time
+	Migration Migration
+	// Applied is true if the migration has been applied.
+	Applied bool
+	// Modified is true if the migration has been changed after it was applied.
+	Modified bool
+}
+
+// Load loads the embedded migrations sorted by version.
+// Migration files are named as <version>_<name>.cql, statements in a file are separated by semicolons.
+// The semicolons in the literals and the comments don't separate the statements.
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationsFS, "cql")
+	if err != nil {
+		return nil, fmt.Errorf("failed read migrations dir: %w", err)
+	}
+	migrations := make([]Migration, 0, len(entries))
+	for _, e := range entries {
+		m, err := loadMigration(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicated migration version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+func loadMigration(fileName string) (Migration, error) {
+	versionStr, name, ok := strings.Cut(strings.TrimSuffix(fileName, ".cql"), "_")
+	if !ok {
+		return Migration{}, fmt.Errorf("invalid migration file name %s", fileName)
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return Migration{}, fmt.Errorf("invalid migration version %s: %w", fileName, err)
+	}
+	b, err := migrationsFS.ReadFile(path.Join("cql", fileName))
+	if err != nil {
+		return Migration{}, fmt.Errorf("failed read migration %s: %w", fileName, err)
+	}
+	sum := sha256.Sum256(b)
+	return Migration{
+		Version:    version,
+		Name:       name,
+		Checksum:   hex.EncodeToString(sum[:]),
+		Statements: splitStatements(string(b)),
+	}, nil
+}
+
+// splitStatements splits the CQL by the semicolons outside of the string literals, the quoted names and the $$ bodies.
+// The comments are dropped, so they may contain the semicolons too.
+func splitStatements(cql string) []string {
+	var (
+		stmts []string
+		stmt  strings.Builder
+	)
+	flush := func() {
+		if s := strings.TrimSpace(stmt.String()); s != "" {
+			stmts = append(stmts, s)
+		}
+		stmt.Reset()
+	}
+	for i := 0; i < len(cql); {
+		rest := cql[i:]
+		switch {
+		case rest[0] == ';':
+			flush()
+			i++
+		case rest[0] == '\'' || rest[0] == '"':
+			// the escaped quote is doubled, so it's read as the end of the literal followed by the next one
+			end := closing(rest, 1, rest[:1])
+			stmt.WriteString(rest[:end])
+			i += end
+		case strings.HasPrefix(rest, "$$"):
+			end := closing(rest, 2, "$$")
+			stmt.WriteString(rest[:end])
+			i += end
+		case strings.HasPrefix(rest, "--"), strings.HasPrefix(rest, "//"):
+			// the line break ending the comment is kept
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			i += end
+		case strings.HasPrefix(rest, "/*"):
+			stmt.WriteByte(' ')
+			i += closing(rest, 2, "*/")
+		default:
+			stmt.WriteByte(rest[0])
+			i++
+		}
+	}
+	flush()
+	return stmts
+}
+
+// closing returns the index right after the terminator found in s since the from index, or the length of s if there is none.
+func closing(s string, from int, terminator string) int {
+	if i := strings.Index(s[from:], terminator); i >= 0 {
+		return from + i + len(terminator)
+	}
+	return len(s)
+}
+
+// Migrator applies the migrations and records the applied versions in the schema_migrations table.
+type Migrator struct {
+	sess        *gocql.Session
+	replication string
+	migrations  []Migration
+}
+
+// New creates a new Migrator.
+// The replication is the replication options the keyspace is created with if it does not exist.
+func New(sess *gocql.Session, replication string) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed load migrations: %w", err)
+	}
+	return &Migrator{sess: sess, replication: replication, migrations: migrations}, nil
+}
+
+// Status returns the status of every known migration.
+// It does not change the database, so all migrations are pending if the keyspace has not been bootstrapped yet.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		st := Status{Migration: mig}
+		if a, ok := applied[mig.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = a.AppliedAt
+			st.Modified = a.Migration.Checksum != mig.Checksum
+		}
+		result = append(result, st)
+	}
+	return result, nil
+}
+
+// Up applies all pending migrations in order and returns them.
+// If dryRun is true, the pending migrations are only returned and logged without being applied.
+func (m *Migrator) Up(ctx context.Context, dryRun bool) ([]Migration, error) {
+	if !dryRun {
+		if err := m.bootstrap(ctx); err != nil {
+			return nil, err
+		}
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lg := slogbrick.FromCtx(ctx)
+	var pending []Migration
+	for _, st := range statuses {
+		if st.Modified {
+			return nil, fmt.Errorf("migration %d_%s has been modified after it was applied", st.Migration.Version, st.Migration.Name)
+		}
+		if !st.Applied {
+			pending = append(pending, st.Migration)
+		}
+	}
+	for _, mig := range pending {
+		mLogger := lg.With(slog.Int("version", mig.Version), slog.String("name", mig.Name))
+		if dryRun {
+			for _, stmt := range mig.Statements {
+				mLogger.Info("dry-run: would apply migration statement", slog.String("statement", stmt))
+			}
+			continue
+		}
+		if err := m.apply(ctx, mig); err != nil {
+			return nil, err
+		}
+		mLogger.Info("applied migration")
+	}
+	return pending, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, mig Migration) error {
+	for _, stmt := range mig.Statements {
+		if err := m.sess.Query(stmt).WithContext(ctx).Exec(); err != nil {
+			return fmt.Errorf("failed apply migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+	err := m.sess.Query("INSERT INTO "+Keyspace+".schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+		mig.Version, mig.Name, mig.Checksum, time.Now().UTC()).
+		WithContext(ctx).
+		Exec()
+	if err != nil {
+		return fmt.Errorf("failed record migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	return nil
+}
+
+func (m *Migrator) bootstrap(ctx context.Context) error {
+	if m.replication == "" {
+		return errors.New("keyspace replication is empty")
+	}
+	stmts := []string{
+		fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s", Keyspace, m.replication),
+		`CREATE TABLE IF NOT EXISTS ` + Keyspace + `.schema_migrations (
+			version    int PRIMARY KEY,
+			name       text,
+			checksum   text,
+			applied_at timestamp)`,
+	}
+	for _, stmt := range stmts {
+		if err := m.sess.Query(stmt).WithContext(ctx).Exec(); err != nil {
+			return fmt.Errorf("failed bootstrap migrations: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]Status, error) {
+	var tableName string
+	err := m.sess.Query("SELECT table_name FROM system_schema.tables WHERE keyspace_name = ? AND table_name = ?", Keyspace, "schema_migrations").
+		WithContext(ctx).
+		Scan(&tableName)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return map[int]Status{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed check schema_migrations table: %w", err)
+	}
+	iter := m.sess.Query("SELECT version, name, checksum, applied_at FROM " + Keyspace + ".schema_migrations").
+		WithContext(ctx).
+		Iter()
+	result := map[int]Status{}
+	var st Status
+	for iter.Scan(&st.Migration.Version, &st.Migration.Name, &st.Migration.Checksum, &st.AppliedAt) {
+		st.Applied = true
+		result[st.Migration.Version] = st
+		st = Status{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed load applied migrations: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,36 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions must be sequential")
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Checksum)
+		assert.NotEmpty(t, m.Statements)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements("CREATE TABLE a (id int PRIMARY KEY);\n\nALTER TABLE a ADD b text;\n")
+	assert.Equal(t, []string{"CREATE TABLE a (id int PRIMARY KEY)", "ALTER TABLE a ADD b text"}, stmts)
+
+	stmts = splitStatements(`-- the comment; with a semicolon
+INSERT INTO a (id, b) VALUES (1, 'it''s; a literal'); /* block; comment */
+CREATE FUNCTION f() RETURNS NULL ON NULL INPUT RETURNS text LANGUAGE java AS $$ return "a;b"; $$;
+SELECT "we;ird" FROM a // trailing; comment
+`)
+	assert.Equal(t, []string{
+		"INSERT INTO a (id, b) VALUES (1, 'it''s; a literal')",
+		`CREATE FUNCTION f() RETURNS NULL ON NULL INPUT RETURNS text LANGUAGE java AS $$ return "a;b"; $$`,
+		`SELECT "we;ird" FROM a`,
+	}, stmts)
+}
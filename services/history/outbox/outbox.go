@@ -0,0 +1,159 @@
+// Package outbox publishes the events written to the outbox together with the history,
+// so a stored message is never left without its event.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Shards is the number of the outbox shards the entries are spread over by the key.
+const Shards = 16
+
+// Config is the outbox relay configuration.
+type Config struct {
+	// Interval is how often the relay looks for the entries left unpublished.
+	Interval time.Duration `default:"5s" json:"interval"`
+	// MinAge is the age of the entries the relay publishes. The writer publishes the entries right after the write,
+	// so the younger ones are left to it to avoid publishing them twice.
+	MinAge time.Duration `default:"10s" json:"min_age"`
+	// BatchSize is the number of the entries published at once.
+	BatchSize int `default:"100" json:"batch_size"`
+}
+
+// Entry is the event waiting in the outbox to be published.
+type Entry struct {
+	CreatedAt time.Time
+	// Metadata is the message metadata, including the trace context of the writer.
+	Metadata map[string]string
+	// ID is the TimeUUID of the entry. It is also the UUID of the published message, so the consumers can detect duplicates.
+	ID    string
+	Topic string
+	// Key routes the entry to the outbox shard. The entries of the same key are published in the order they were written.
+	Key     string
+	Payload []byte
+}
+
+// NewEntry creates a new entry of the topic with the trace context of ctx.
+func NewEntry(ctx context.Context, topic, key string, payload []byte) Entry {
+	md := map[string]string{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(md))
+	createdAt := time.Now().UTC()
+	// the event keeps the time it was written, however late the relay publishes it
+	watermillbrick.SetTime(md, createdAt)
+	return Entry{
+		CreatedAt: createdAt,
+		Metadata:  md,
+		ID:        gocql.TimeUUID().String(),
+		Topic:     topic,
+		Key:       key,
+		Payload:   payload,
+	}
+}
+
+// Shard returns the outbox shard of the entry.
+func (e Entry) Shard() int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(e.Key))
+	return int(h.Sum32() % Shards)
+}
+
+func (e Entry) message() *message.Message {
+	msg := message.NewMessage(e.ID, e.Payload)
+	for k, v := range e.Metadata {
+		msg.Metadata.Set(k, v)
+	}
+	msg.SetContext(otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(e.Metadata)))
+	return msg
+}
+
+// Store keeps the outbox entries. The entries are added by the writer.Store atomically with the messages.
+type Store interface {
+	// PendingOutbox returns the entries written before the time and not published yet, ordered by the key.
+	PendingOutbox(ctx context.Context, before time.Time, limit int) ([]Entry, error)
+	// OutboxDone marks the published entries done, so they are not published again.
+	OutboxDone(ctx context.Context, entries ...Entry) error
+}
+
+// Relay publishes the outbox entries and marks them done.
+// An entry is published at least once: it is published again if the relay fails before it is marked done.
+type Relay struct {
+	store Store
+	pub   message.Publisher
+	cfg   Config
+}
+
+// NewRelay creates a new Relay.
+func NewRelay(store Store, pub message.Publisher, cfg Config) *Relay {
+	return &Relay{store: store, pub: pub, cfg: cfg}
+}
+
+// Publish publishes the entries in order and marks the published ones done.
+func (r *Relay) Publish(ctx context.Context, entries ...Entry) error {
+	var (
+		published []Entry
+		pubErr    error
+	)
+	for _, e := range entries {
+		if pubErr = r.pub.Publish(e.Topic, e.message()); pubErr != nil {
+			pubErr = fmt.Errorf("failed publish outbox entry %s: %w", e.ID, pubErr)
+			break
+		}
+		published = append(published, e)
+	}
+	if len(published) == 0 {
+		return pubErr
+	}
+	if err := r.store.OutboxDone(ctx, published...); err != nil {
+		return errors.Join(pubErr, fmt.Errorf("failed mark outbox entries done: %w", err))
+	}
+	return pubErr
+}
+
+// Run relays periodically until the context is done.
+func (r *Relay) Run(ctx context.Context) {
+	t := time.NewTicker(r.cfg.Interval)
+	defer t.Stop()
+	for {
+		if n, err := r.Relay(ctx); err != nil {
+			slogbrick.FromCtx(ctx).Error("failed relay outbox", slog.Any("err", err), slog.Int("entries", n))
+		} else if n > 0 {
+			slogbrick.FromCtx(ctx).Info("relayed outbox", slog.Int("entries", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Relay publishes the pending entries older than the MinAge until none is left and returns the number of the published entries.
+func (r *Relay) Relay(ctx context.Context) (int, error) {
+	var relayed int
+	before := time.Now().Add(-r.cfg.MinAge)
+	for {
+		entries, err := r.store.PendingOutbox(ctx, before, r.cfg.BatchSize)
+		if err != nil {
+			return relayed, fmt.Errorf("failed load pending outbox entries: %w", err)
+		}
+		if len(entries) == 0 {
+			return relayed, nil
+		}
+		if err := r.Publish(ctx, entries...); err != nil {
+			return relayed, err
+		}
+		relayed += len(entries)
+	}
+}
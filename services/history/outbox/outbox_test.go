@@ -0,0 +1,93 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memStore struct {
+	entries []Entry
+}
+
+func (s *memStore) PendingOutbox(_ context.Context, before time.Time, limit int) ([]Entry, error) {
+	var pending []Entry
+	for _, e := range s.entries {
+		if e.CreatedAt.Before(before) && len(pending) < limit {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+func (s *memStore) OutboxDone(_ context.Context, entries ...Entry) error {
+	done := map[string]bool{}
+	for _, e := range entries {
+		done[e.ID] = true
+	}
+	var left []Entry
+	for _, e := range s.entries {
+		if !done[e.ID] {
+			left = append(left, e)
+		}
+	}
+	s.entries = left
+	return nil
+}
+
+type publisher struct {
+	ids  []string
+	fail int
+}
+
+func (p *publisher) Publish(_ string, msgs ...*message.Message) error {
+	for _, msg := range msgs {
+		if p.fail > 0 && len(p.ids) == p.fail {
+			return errors.New("unavailable")
+		}
+		p.ids = append(p.ids, msg.UUID)
+	}
+	return nil
+}
+
+func (p *publisher) Close() error {
+	return nil
+}
+
+func TestRelay_Relay(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{}
+	for i := 0; i < 5; i++ {
+		e := NewEntry(ctx, "msg_stored", "room-1", []byte("{}"))
+		e.CreatedAt = e.CreatedAt.Add(-time.Minute)
+		store.entries = append(store.entries, e)
+	}
+	young := NewEntry(ctx, "msg_stored", "room-1", []byte("{}"))
+	store.entries = append(store.entries, young)
+	ids := func(entries []Entry) []string {
+		var ids []string
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+	old := ids(store.entries[:5])
+
+	pub := &publisher{fail: 3}
+	r := NewRelay(store, pub, Config{MinAge: time.Second, BatchSize: 2})
+	_, err := r.Relay(ctx)
+	require.Error(t, err)
+	assert.Equal(t, old[3:], ids(store.entries[:2]), "the published entries must be marked done")
+
+	pub.fail = 0
+	n, err := r.Relay(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, old, pub.ids, "the entries must be published in order")
+	assert.Equal(t, []string{young.ID}, ids(store.entries), "the young entries must be left to the writer")
+}
@@ -0,0 +1,63 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// AuditTopic is the topic the audit events of the data subject requests are published to.
+const AuditTopic = "user_data_audit"
+
+// Audit event types.
+const (
+	EvtExportStarted  = "user_data_export_started"
+	EvtExported       = "user_data_exported"
+	EvtErasureStarted = "user_data_erasure_started"
+	EvtErased         = "user_data_erased"
+)
+
+// AuditEvt records the step of the data subject request.
+type AuditEvt struct {
+	At          time.Time `json:"at"`
+	Type        string    `json:"type"`
+	JobID       string    `json:"job_id"`
+	UserID      string    `json:"user_id"`
+	RequestedBy string    `json:"requested_by"`
+	Mode        Mode      `json:"mode,omitempty"`
+	AnonymousID string    `json:"anonymous_id,omitempty"`
+	Messages    int       `json:"messages"`
+	Rooms       int       `json:"rooms"`
+}
+
+// Auditor records the audit events.
+type Auditor interface {
+	Audit(ctx context.Context, evt AuditEvt) error
+}
+
+// PubAuditor publishes the audit events to the AuditTopic.
+type PubAuditor struct {
+	pub message.Publisher
+}
+
+// NewPubAuditor creates a new PubAuditor.
+func NewPubAuditor(pub message.Publisher) *PubAuditor {
+	return &PubAuditor{pub: pub}
+}
+
+func (a *PubAuditor) Audit(ctx context.Context, evt AuditEvt) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("failed encode audit evt: %w", err)
+	}
+	msg := message.NewMessage(watermill.NewUUID(), b)
+	msg.SetContext(ctx)
+	if err := a.pub.Publish(AuditTopic, msg); err != nil {
+		return fmt.Errorf("failed publish audit evt: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Package privacy handles the data subject requests: the export and the erasure of everything a user wrote.
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/writer"
+	"github.com/gocql/gocql"
+)
+
+const pageSize = 500
+
+// Mode is the way the messages of the user are erased.
+type Mode string
+
+const (
+	// Anonymize keeps the messages, but replaces the user data with an anonymous identity.
+	Anonymize Mode = "anonymize"
+	// Delete deletes the messages.
+	Delete Mode = "delete"
+)
+
+// ParseMode parses the erasure mode.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case Anonymize, Delete:
+		return m, nil
+	default:
+		return "", fmt.Errorf("%w: unsupported erasure mode %q", errbrick.ErrInvalidData, s)
+	}
+}
+
+// Message is the message of the user with the room it was written to.
+type Message struct {
+	RoomChatID string `json:"chat_room_id"`
+	loader.Message
+}
+
+// Store is the storage of the messages looked up by the user across all rooms.
+type Store interface {
+	writer.Store
+	// UserMessages returns a page of the messages written by the user in any room.
+	// The empty page token requests the first page, the empty next page token means there are no more pages.
+	UserMessages(ctx context.Context, userID, pageToken string, limit int) ([]Message, string, error)
+	// DeleteMessage deletes the message.
+	DeleteMessage(ctx context.Context, roomChatID, msgID string, createdAt time.Time) error
+}
+
+// RoomInvalidator drops the copies of the room messages kept outside the store, e.g. the recent messages cache.
+type RoomInvalidator interface {
+	Invalidate(ctx context.Context, roomChatID string) error
+}
+
+// Request is the data subject request.
+type Request struct {
+	// JobID identifies the request in the audit events. It is generated if empty.
+	JobID  string
+	UserID string
+	// RequestedBy is the admin who triggered the request.
+	RequestedBy string
+	// Progress is called after every processed page of the messages.
+	Progress func(Report)
+}
+
+func (r *Request) validate() error {
+	if r.UserID == "" {
+		return fmt.Errorf("%w: user id is empty", errbrick.ErrInvalidData)
+	}
+	if r.RequestedBy == "" {
+		return fmt.Errorf("%w: requested by is empty", errbrick.ErrInvalidData)
+	}
+	if r.JobID == "" {
+		r.JobID = gocql.TimeUUID().String()
+	}
+	return nil
+}
+
+// Report is the progress of the request.
+type Report struct {
+	JobID    string `json:"job_id"`
+	Messages int    `json:"messages"`
+	Rooms    int    `json:"rooms"`
+	// AnonymousID is the user ID the anonymized messages are attributed to.
+	AnonymousID string `json:"anonymous_id,omitempty"`
+}
+
+// Job runs the data subject requests.
+type Job struct {
+	store       Store
+	retention   writer.RetentionResolver
+	auditor     Auditor
+	invalidator RoomInvalidator
+}
+
+// NewJob creates a new Job.
+// The retention is used to keep the TTL of the anonymized messages, the invalidator may be nil.
+func NewJob(store Store, retention writer.RetentionResolver, auditor Auditor, invalidator RoomInvalidator) *Job {
+	return &Job{store: store, retention: retention, auditor: auditor, invalidator: invalidator}
+}
+
+// Export writes every message of the user as newline-delimited JSON to w.
+func (j *Job) Export(ctx context.Context, req Request, w io.Writer) (Report, error) {
+	if err := req.validate(); err != nil {
+		return Report{}, err
+	}
+	rep := Report{JobID: req.JobID}
+	if err := j.audit(ctx, req, EvtExportStarted, rep, ""); err != nil {
+		return rep, err
+	}
+	rooms := map[string]struct{}{}
+	enc := json.NewEncoder(w)
+	var token string
+	for {
+		msgs, next, err := j.store.UserMessages(ctx, req.UserID, token, pageSize)
+		if err != nil {
+			return rep, fmt.Errorf("failed load user messages: %w", err)
+		}
+		for _, m := range msgs {
+			if err := enc.Encode(m); err != nil {
+				return rep, fmt.Errorf("failed write user message: %w", err)
+			}
+			rooms[m.RoomChatID] = struct{}{}
+		}
+		rep.Messages += len(msgs)
+		rep.Rooms = len(rooms)
+		req.progress(rep)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	return rep, j.audit(ctx, req, EvtExported, rep, "")
+}
+
+// Erase anonymizes or deletes every message of the user.
+// The job is idempotent: the erased messages no longer belong to the user, so an interrupted job is resumed by running it again.
+func (j *Job) Erase(ctx context.Context, req Request, mode Mode) (Report, error) {
+	if err := req.validate(); err != nil {
+		return Report{}, err
+	}
+	rep := Report{JobID: req.JobID}
+	if mode == Anonymize {
+		// the same anonymous ID for all messages keeps the conversations readable without linking them to the user
+		rep.AnonymousID = "anonymous-" + gocql.TimeUUID().String()
+	}
+	if err := j.audit(ctx, req, EvtErasureStarted, rep, mode); err != nil {
+		return rep, err
+	}
+	rooms := map[string]struct{}{}
+	seen := map[string]struct{}{}
+	for {
+		// the processed messages no longer match the user, so the first page is always the next one
+		msgs, _, err := j.store.UserMessages(ctx, req.UserID, "", pageSize)
+		if err != nil {
+			return rep, fmt.Errorf("failed load user messages: %w", err)
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, m := range msgs {
+			if _, ok := seen[m.ID]; ok {
+				return rep, fmt.Errorf("message %s has not been erased", m.ID)
+			}
+			seen[m.ID] = struct{}{}
+			if err := j.erase(ctx, m, mode, rep.AnonymousID); err != nil {
+				return rep, err
+			}
+			rooms[m.RoomChatID] = struct{}{}
+		}
+		rep.Messages += len(msgs)
+		rep.Rooms = len(rooms)
+		req.progress(rep)
+	}
+	if j.invalidator != nil {
+		for room := range rooms {
+			if err := j.invalidator.Invalidate(ctx, room); err != nil {
+				return rep, fmt.Errorf("failed invalidate room %s: %w", room, err)
+			}
+		}
+	}
+	return rep, j.audit(ctx, req, EvtErased, rep, mode)
+}
+
+func (j *Job) erase(ctx context.Context, m Message, mode Mode, anonymousID string) error {
+	if mode == Delete {
+		if err := j.store.DeleteMessage(ctx, m.RoomChatID, m.ID, m.CreatedAt); err != nil {
+			return fmt.Errorf("failed delete message %s: %w", m.ID, err)
+		}
+		return nil
+	}
+	rec := writer.Record{CreateParams: writer.CreateParams{
+		MsgID:      m.ID,
+		Seq:        m.Seq,
+		RoomChatID: m.RoomChatID,
+		Msg:        m.Msg,
+		CreatedAt:  m.CreatedAt,
+		PendingID:  m.PendingID,
+		User:       writer.UserParams{ID: anonymousID},
+	}}
+	if j.retention != nil {
+		retention, err := j.retention.Retention(ctx, m.RoomChatID)
+		if err != nil {
+			return fmt.Errorf("failed resolve room retention: %w", err)
+		}
+		if retention > 0 {
+			// the rewritten message must expire at the same time as the original one
+			if rec.TTL = time.Until(m.CreatedAt.Add(retention)); rec.TTL <= 0 {
+				return j.erase(ctx, m, Delete, "")
+			}
+		}
+	}
+	if err := j.store.Insert(ctx, rec); err != nil {
+		return fmt.Errorf("failed anonymize message %s: %w", m.ID, err)
+	}
+	return nil
+}
+
+func (j *Job) audit(ctx context.Context, req Request, evtType string, rep Report, mode Mode) error {
+	evt := AuditEvt{
+		Type:        evtType,
+		JobID:       req.JobID,
+		UserID:      req.UserID,
+		RequestedBy: req.RequestedBy,
+		Mode:        mode,
+		Messages:    rep.Messages,
+		Rooms:       rep.Rooms,
+		AnonymousID: rep.AnonymousID,
+		At:          time.Now().UTC(),
+	}
+	if err := j.auditor.Audit(ctx, evt); err != nil {
+		return fmt.Errorf("failed audit %s: %w", evtType, err)
+	}
+	slogbrick.FromCtx(ctx).Info("privacy audit event",
+		slog.String("type", evtType), slog.String("job_id", req.JobID), slog.String("requested_by", req.RequestedBy))
+	return nil
+}
+
+func (r Request) progress(rep Report) {
+	if r.Progress != nil {
+		r.Progress(rep)
+	}
+}
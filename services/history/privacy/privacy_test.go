@@ -0,0 +1,97 @@
+package privacy_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/privacy"
+	"github.com/demeero/chat/history/storage/sqlstore"
+	"github.com/demeero/chat/history/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type auditLog []privacy.AuditEvt
+
+func (a *auditLog) Audit(_ context.Context, evt privacy.AuditEvt) error {
+	*a = append(*a, evt)
+	return nil
+}
+
+func TestJob(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlstore.Open(ctx, sqlstore.SQLite, filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	w := writer.New(s, nil, nil)
+	for i, user := range []string{"user-1", "user-2", "user-1", "user-1"} {
+		_, err := w.Create(ctx, writer.CreateParams{
+			RoomChatID: []string{"room-1", "room-2"}[i%2],
+			Msg:        "msg",
+			CreatedAt:  time.Now().UTC(),
+			User:       writer.UserParams{ID: user},
+		})
+		require.NoError(t, err)
+	}
+
+	var audit auditLog
+	job := privacy.NewJob(s, nil, &audit, nil)
+	req := privacy.Request{UserID: "user-1", RequestedBy: "admin"}
+
+	var buf bytes.Buffer
+	rep, err := job.Export(ctx, req, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rep.Messages)
+	assert.Equal(t, 2, rep.Rooms)
+	var lines int
+	for sc := bufio.NewScanner(&buf); sc.Scan(); lines++ {
+		var m privacy.Message
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &m))
+		assert.Equal(t, "user-1", m.User.ID)
+	}
+	assert.Equal(t, 3, lines)
+
+	rep, err = job.Erase(ctx, req, privacy.Anonymize)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rep.Messages)
+	require.NotEmpty(t, rep.AnonymousID)
+
+	msgs, _, err := s.UserMessages(ctx, "user-1", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+	anonymized, _, err := s.UserMessages(ctx, rep.AnonymousID, "", 10)
+	require.NoError(t, err)
+	require.Len(t, anonymized, 3)
+	assert.Equal(t, "msg", anonymized[0].Msg)
+
+	_, err = privacy.NewJob(s, nil, &audit, nil).Erase(ctx, privacy.Request{UserID: "user-2", RequestedBy: "admin"}, privacy.Delete)
+	require.NoError(t, err)
+	page, _, err := loader.New(s, nil, nil).Load(ctx, "room-2", mustPagination(t))
+	require.NoError(t, err)
+	assert.Len(t, page, 1)
+
+	types := make([]string, 0, len(audit))
+	for _, evt := range audit {
+		types = append(types, evt.Type)
+		assert.Equal(t, "admin", evt.RequestedBy)
+	}
+	assert.Equal(t, []string{
+		privacy.EvtExportStarted, privacy.EvtExported,
+		privacy.EvtErasureStarted, privacy.EvtErased,
+		privacy.EvtErasureStarted, privacy.EvtErased,
+	}, types)
+}
+
+func mustPagination(t *testing.T) loader.Pagination {
+	t.Helper()
+	p, err := loader.NewPagination("", 10)
+	require.NoError(t, err)
+	return p
+}
@@ -0,0 +1,19 @@
+// Package profile resolves the profiles of the message authors and keeps them synced with the Kratos identities.
+package profile
+
+import (
+	"time"
+)
+
+// Config is the configuration of the profiles.
+type Config struct {
+	// KratosAdminURL is the URL of the Kratos admin API the profiles are fed from.
+	KratosAdminURL string `default:"http://kratos:4434" split_words:"true" json:"kratos_admin_url"`
+	// CacheTTL is how long the resolved profiles are cached in memory for.
+	CacheTTL time.Duration `default:"1m" split_words:"true" json:"cache_ttl"`
+	// CacheSize is the max number of the users the resolved profiles are cached in memory for.
+	CacheSize int `default:"10000" split_words:"true" json:"cache_size"`
+	// SyncInterval is the interval all Kratos identities are synced to the store with.
+	SyncInterval time.Duration `default:"10m" split_words:"true" json:"sync_interval"`
+	SyncEnabled  bool          `default:"true" split_words:"true" json:"sync_enabled"`
+}
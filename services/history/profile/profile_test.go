@@ -0,0 +1,100 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/history/loader"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func kratosIdentity(id, first string) map[string]any {
+	return map[string]any{
+		"id": id,
+		"traits": map[string]any{
+			"email":      id + "@example.com",
+			"name":       map[string]any{"first": first, "last": "Last"},
+			"avatar_url": "https://example.com/" + id + ".png",
+		},
+	}
+}
+
+func newKratos(t *testing.T, requests *atomic.Int32) *identity.Kratos {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch {
+		case r.URL.Path == "/admin/identities" && r.URL.Query().Get("page_token") == "":
+			w.Header().Set("Link", `</admin/identities?page_size=250&page_token=p2>; rel="next"`)
+			_ = json.NewEncoder(w).Encode([]any{kratosIdentity("u1", "Alice")})
+		case r.URL.Path == "/admin/identities":
+			_ = json.NewEncoder(w).Encode([]any{kratosIdentity("u2", "Bob")})
+		case r.URL.Path == "/admin/identities/u3":
+			_ = json.NewEncoder(w).Encode(kratosIdentity("u3", "Carol"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return identity.NewKratos(srv.URL, srv.Client())
+}
+
+func TestResolver_Profiles(t *testing.T) {
+	ctx := context.Background()
+	var requests atomic.Int32
+	kratos := newKratos(t, &requests)
+	store := identity.NewStore(redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}))
+
+	n, err := NewSyncer(store, kratos, time.Minute).Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	r := NewResolver(store, kratos, identity.NewCache(10, time.Minute))
+	requests.Store(0)
+	users, err := r.Profiles(ctx, []string{"u1", "u2", "u3", "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, loader.MsgUser{
+		ID:        "u1",
+		Email:     "u1@example.com",
+		FirstName: "Alice",
+		LastName:  "Last",
+		AvatarURL: "https://example.com/u1.png",
+	}, users["u1"])
+	assert.Equal(t, "Bob", users["u2"].FirstName)
+	assert.Equal(t, "Carol", users["u3"].FirstName)
+	assert.NotContains(t, users, "unknown")
+	// only the users missing in the store are fetched from Kratos
+	assert.EqualValues(t, 2, requests.Load())
+
+	stored, err := store.Get(ctx, "u3")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(stored["u3"].AvatarURL, "u3.png"))
+
+	// the resolved and the missing users are cached
+	_, err = r.Profiles(ctx, []string{"u3", "unknown"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, requests.Load())
+
+	// the erased users are neither synced nor fetched from Kratos again
+	require.NoError(t, store.Erase(ctx, "u1"))
+	require.NoError(t, store.Erase(ctx, "u3"))
+	_, err = NewSyncer(store, kratos, time.Minute).Sync(ctx)
+	require.NoError(t, err)
+	requests.Store(0)
+	users, err = NewResolver(store, kratos, identity.NewCache(10, time.Minute)).Profiles(ctx, []string{"u1", "u2", "u3"})
+	require.NoError(t, err)
+	assert.NotContains(t, users, "u1")
+	assert.NotContains(t, users, "u3")
+	assert.Equal(t, "Bob", users["u2"].FirstName)
+	assert.EqualValues(t, 0, requests.Load())
+}
@@ -0,0 +1,87 @@
+package profile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/history/loader"
+)
+
+// Resolver resolves the profiles of the message authors at read time.
+// The profiles are looked up in the in-memory cache, then in the Store and finally in Kratos.
+type Resolver struct {
+	store  *identity.Store
+	kratos *identity.Kratos
+	cache  *identity.Cache
+}
+
+// NewResolver creates a new Resolver. If kratos is nil, the profiles missing in the store are not resolved.
+func NewResolver(store *identity.Store, kratos *identity.Kratos, cache *identity.Cache) *Resolver {
+	return &Resolver{store: store, kratos: kratos, cache: cache}
+}
+
+// Profiles implements loader.ProfileResolver.
+func (r *Resolver) Profiles(ctx context.Context, userIDs []string) (map[string]loader.MsgUser, error) {
+	result := make(map[string]loader.MsgUser, len(userIDs))
+	var missing []string
+	for _, id := range userIDs {
+		p, found, ok := r.cache.Get(id)
+		switch {
+		case !ok:
+			missing = append(missing, id)
+		case found:
+			result[id] = msgUser(p)
+		}
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+	stored, err := r.store.Get(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range missing {
+		p, ok := stored[id]
+		if !ok && r.kratos != nil {
+			if p, ok, err = r.fetch(ctx, id); err != nil {
+				// the message is still shown with the data of the row, so a Kratos outage does not break reads
+				slogbrick.FromCtx(ctx).Warn("failed fetch profile from kratos", slog.String("user_id", id), slog.Any("err", err))
+				continue
+			}
+		}
+		r.cache.Set(id, p, ok)
+		if ok {
+			result[id] = msgUser(p)
+		}
+	}
+	return result, nil
+}
+
+// fetch fetches the profile missing in the store from Kratos. The erased users are not fetched, their profiles stay deleted.
+func (r *Resolver) fetch(ctx context.Context, userID string) (identity.Profile, bool, error) {
+	erased, err := r.store.Erased(ctx, userID)
+	if err != nil || erased {
+		return identity.Profile{}, false, err
+	}
+	p, ok, err := r.kratos.Get(ctx, userID)
+	if err != nil || !ok {
+		return identity.Profile{}, false, err
+	}
+	if err := r.store.Upsert(ctx, p); err != nil {
+		return identity.Profile{}, false, fmt.Errorf("failed save fetched profile: %w", err)
+	}
+	return p, true, nil
+}
+
+func msgUser(p identity.Profile) loader.MsgUser {
+	return loader.MsgUser{
+		ID:        p.ID,
+		Email:     p.Email,
+		FirstName: p.FirstName,
+		LastName:  p.LastName,
+		AvatarURL: p.AvatarURL,
+	}
+}
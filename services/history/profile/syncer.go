@@ -0,0 +1,64 @@
+package profile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/identity"
+)
+
+// Syncer periodically copies all Kratos identities to the Store, so the name and avatar changes reach the profiles.
+// The erased users are skipped by the Store, so the identity not yet deleted in Kratos does not bring the profile back.
+type Syncer struct {
+	store    *identity.Store
+	kratos   *identity.Kratos
+	interval time.Duration
+}
+
+// NewSyncer creates a new Syncer.
+func NewSyncer(store *identity.Store, kratos *identity.Kratos, interval time.Duration) *Syncer {
+	return &Syncer{store: store, kratos: kratos, interval: interval}
+}
+
+// Run syncs periodically until the context is done.
+func (s *Syncer) Run(ctx context.Context) {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		if n, err := s.Sync(ctx); err != nil {
+			slogbrick.FromCtx(ctx).Error("failed sync profiles", slog.Any("err", err))
+		} else {
+			slogbrick.FromCtx(ctx).Debug("synced profiles", slog.Int("profiles", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Sync copies all identities once and returns the number of the synced profiles.
+func (s *Syncer) Sync(ctx context.Context) (int, error) {
+	var (
+		synced int
+		token  string
+	)
+	for {
+		profiles, next, err := s.kratos.List(ctx, token)
+		if err != nil {
+			return synced, fmt.Errorf("failed list identities: %w", err)
+		}
+		if err := s.store.Upsert(ctx, profiles...); err != nil {
+			return synced, err
+		}
+		synced += len(profiles)
+		if next == "" || next == token {
+			return synced, nil
+		}
+		token = next
+	}
+}
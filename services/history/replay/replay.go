@@ -0,0 +1,230 @@
+// Package replay rebuilds the projections of the chat history, e.g. the search index or the recent messages cache,
+// by replaying the stored messages.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/history/loader"
+	"github.com/gocql/gocql"
+	"golang.org/x/time/rate"
+)
+
+// Store is the chat history the messages are replayed from.
+type Store interface {
+	// Scan returns up to limit messages of the chat room ordered from the oldest to the newest.
+	Scan(ctx context.Context, roomChatID string, cursor *loader.Cursor, limit int) ([]loader.Message, error)
+	// Rooms returns the IDs of all chat rooms with the stored messages.
+	Rooms(ctx context.Context) ([]string, error)
+}
+
+// Sink receives the replayed messages, e.g. republishes them or feeds them to the projection handler.
+type Sink func(ctx context.Context, roomChatID string, m loader.Message) error
+
+// Config is the replay configuration.
+type Config struct {
+	// Rate is the maximum number of the messages replayed per second. Zero means no limit.
+	Rate float64
+	// BatchSize is the number of the messages loaded at once. The checkpoint is saved after every batch.
+	BatchSize int
+}
+
+// Job is the replay of the messages of the chat rooms created in the time range.
+type Job struct {
+	// Target names what the messages are replayed to, e.g. the projection, so the job of one target is not resumed by another.
+	Target string `json:"target,omitempty"`
+	// Rooms are the replayed chat rooms. All rooms are replayed if empty.
+	Rooms []string `json:"rooms"`
+	// From is the inclusive start of the time range. Zero From means since the oldest message.
+	From time.Time `json:"from"`
+	// To is the exclusive end of the time range. Zero To means until the newest message.
+	To time.Time `json:"to"`
+}
+
+// equal reports whether the jobs are the same. The rooms must be listed in the same order.
+func (j Job) equal(o Job) bool {
+	return j.Target == o.Target && slices.Equal(j.Rooms, o.Rooms) && j.From.Equal(o.From) && j.To.Equal(o.To)
+}
+
+func (j Job) validate() error {
+	if !j.From.IsZero() && !j.To.IsZero() && !j.From.Before(j.To) {
+		return fmt.Errorf("%w: from must be before to", errbrick.ErrInvalidData)
+	}
+	return nil
+}
+
+// Checkpoint is the progress of the job, so the stopped replay continues where it stopped.
+type Checkpoint struct {
+	// Job is the replayed job with all the rooms listed.
+	Job Job `json:"job"`
+	// Requested is the job as it was requested, so the resumed replay is checked to be the same job.
+	Requested *Job `json:"requested,omitempty"`
+	// Room is the index of the chat room being replayed.
+	Room int `json:"room"`
+	// Cursor points to the last replayed message of the chat room.
+	Cursor   *loader.Cursor `json:"cursor,omitempty"`
+	Replayed int            `json:"replayed"`
+	Done     bool           `json:"done"`
+}
+
+// Checkpoints keeps the checkpoint of the replay.
+type Checkpoints interface {
+	// Load returns the saved checkpoint and false if there is none.
+	Load(ctx context.Context) (Checkpoint, bool, error)
+	Save(ctx context.Context, cp Checkpoint) error
+}
+
+// FileCheckpoints keeps the checkpoint in the JSON file.
+type FileCheckpoints string
+
+func (f FileCheckpoints) Load(_ context.Context) (Checkpoint, bool, error) {
+	b, err := os.ReadFile(string(f))
+	if errors.Is(err, os.ErrNotExist) {
+		return Checkpoint{}, false, nil
+	}
+	if err != nil {
+		return Checkpoint{}, false, fmt.Errorf("failed read checkpoint: %w", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return Checkpoint{}, false, fmt.Errorf("failed decode checkpoint: %w", err)
+	}
+	return cp, true, nil
+}
+
+// Save writes the checkpoint to the temporary file and renames it, so the interrupted save keeps the previous checkpoint.
+func (f FileCheckpoints) Save(_ context.Context, cp Checkpoint) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encode checkpoint: %w", err)
+	}
+	tmp := filepath.Join(filepath.Dir(string(f)), "."+filepath.Base(string(f))+".tmp")
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("failed write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, string(f)); err != nil {
+		return fmt.Errorf("failed save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Replayer replays the stored messages to the sink.
+type Replayer struct {
+	store       Store
+	sink        Sink
+	checkpoints Checkpoints
+	limiter     *rate.Limiter
+	batchSize   int
+}
+
+// New creates a new Replayer.
+func New(store Store, sink Sink, checkpoints Checkpoints, cfg Config) *Replayer {
+	limit := rate.Inf
+	if cfg.Rate > 0 {
+		limit = rate.Limit(cfg.Rate)
+	}
+	batchSize := cfg.BatchSize
+	if batchSize < 1 {
+		batchSize = 500
+	}
+	return &Replayer{
+		store:       store,
+		sink:        sink,
+		checkpoints: checkpoints,
+		limiter:     rate.NewLimiter(limit, 1),
+		batchSize:   batchSize,
+	}
+}
+
+// Replay runs the job. If the checkpoint of the unfinished job is saved, the saved job is resumed,
+// so the resumed replay walks the same rooms and time range. The job must be the one of the checkpoint,
+// otherwise the replay fails with errbrick.ErrConflict instead of resuming the other job.
+// The checkpoint is returned even if the replay fails or the context is done.
+func (r *Replayer) Replay(ctx context.Context, job Job) (Checkpoint, error) {
+	cp, ok, err := r.checkpoints.Load(ctx)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if ok && cp.Done {
+		ok = false
+	}
+	if ok && cp.Requested != nil && !cp.Requested.equal(job) {
+		return Checkpoint{}, fmt.Errorf("%w: checkpoint is of another replay job, run it with the same target, rooms, from and to or remove the checkpoint",
+			errbrick.ErrConflict)
+	}
+	if ok && cp.Requested == nil {
+		slogbrick.FromCtx(ctx).Warn("checkpoint has no requested job - resume without checking it")
+	}
+	if ok {
+		slogbrick.FromCtx(ctx).Info("resume replay",
+			slog.Int("room", cp.Room), slog.Int("rooms", len(cp.Job.Rooms)), slog.Int("replayed", cp.Replayed))
+	} else {
+		if err := job.validate(); err != nil {
+			return Checkpoint{}, err
+		}
+		requested := job
+		requested.Rooms = slices.Clone(job.Rooms)
+		if len(job.Rooms) == 0 {
+			if job.Rooms, err = r.store.Rooms(ctx); err != nil {
+				return Checkpoint{}, fmt.Errorf("failed list rooms: %w", err)
+			}
+		}
+		cp = Checkpoint{Job: job, Requested: &requested}
+	}
+	for ; cp.Room < len(cp.Job.Rooms); cp.Room++ {
+		if err := r.replayRoom(ctx, &cp); err != nil {
+			return cp, errors.Join(err, r.checkpoints.Save(ctx, cp))
+		}
+		cp.Cursor = nil
+	}
+	cp.Done = true
+	return cp, r.checkpoints.Save(ctx, cp)
+}
+
+func (r *Replayer) replayRoom(ctx context.Context, cp *Checkpoint) error {
+	roomChatID := cp.Job.Rooms[cp.Room]
+	from, to := cp.Job.From, cp.Job.To
+	if cp.Cursor == nil && !from.IsZero() {
+		// start right before the range, the messages older than From are skipped below
+		cp.Cursor = &loader.Cursor{CreatedAt: from.Add(-time.Second), MsgID: gocql.MaxTimeUUID(from).String()}
+	}
+	for {
+		msgs, err := r.store.Scan(ctx, roomChatID, cp.Cursor, r.batchSize)
+		if err != nil {
+			return fmt.Errorf("failed scan room %s: %w", roomChatID, err)
+		}
+		for _, m := range msgs {
+			if !to.IsZero() && !m.CreatedAt.Before(to) {
+				return nil
+			}
+			if m.CreatedAt.Before(from) {
+				cp.Cursor = &loader.Cursor{CreatedAt: m.CreatedAt, MsgID: m.ID}
+				continue
+			}
+			if err := r.limiter.Wait(ctx); err != nil {
+				return err
+			}
+			if err := r.sink(ctx, roomChatID, m); err != nil {
+				return fmt.Errorf("failed replay msg %s of room %s: %w", m.ID, roomChatID, err)
+			}
+			cp.Cursor = &loader.Cursor{CreatedAt: m.CreatedAt, MsgID: m.ID}
+			cp.Replayed++
+		}
+		if len(msgs) < r.batchSize {
+			return nil
+		}
+		if err := r.checkpoints.Save(ctx, *cp); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/storage/sqlstore"
+	"github.com/demeero/chat/history/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayer_Replay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := sqlstore.Open(ctx, sqlstore.SQLite, filepath.Join(dir, "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	w := writer.New(s, nil, nil)
+	start := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Hour)
+	for _, room := range []string{"room-1", "room-2"} {
+		for i := 0; i < 5; i++ {
+			_, err := w.Create(ctx, writer.CreateParams{
+				RoomChatID: room,
+				Msg:        room,
+				CreatedAt:  start.Add(time.Duration(i) * time.Minute),
+				User:       writer.UserParams{ID: "user-1"},
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	var (
+		replayed []loader.Message
+		failAt   = 3
+	)
+	sink := func(_ context.Context, roomChatID string, m loader.Message) error {
+		if len(replayed) == failAt {
+			return errors.New("projection unavailable")
+		}
+		assert.Equal(t, roomChatID, m.Msg)
+		replayed = append(replayed, m)
+		return nil
+	}
+	checkpoints := FileCheckpoints(filepath.Join(dir, "checkpoint.json"))
+	r := New(s, sink, checkpoints, Config{BatchSize: 2})
+	// the range skips the first and the last message of every room
+	job := Job{From: start.Add(time.Minute), To: start.Add(4 * time.Minute)}
+
+	cp, err := r.Replay(ctx, job)
+	require.Error(t, err)
+	assert.Equal(t, 3, cp.Replayed)
+	assert.Equal(t, []string{"room-1", "room-2"}, cp.Job.Rooms)
+
+	failAt = -1
+	_, err = r.Replay(ctx, Job{Rooms: []string{"room-1"}, From: job.From, To: job.To})
+	require.ErrorIs(t, err, errbrick.ErrConflict, "the checkpoint of another job must not be resumed")
+	cp, err = r.Replay(ctx, job)
+	require.NoError(t, err, "the saved job must be resumed")
+	assert.True(t, cp.Done)
+	assert.Equal(t, 6, cp.Replayed)
+	require.Len(t, replayed, 6)
+	seen := map[string]bool{}
+	for _, m := range replayed {
+		assert.False(t, seen[m.ID], "the resumed replay must not replay the message %s again", m.ID)
+		seen[m.ID] = true
+		assert.False(t, m.CreatedAt.Before(job.From))
+		assert.True(t, m.CreatedAt.Before(job.To))
+	}
+
+	_, err = r.Replay(ctx, Job{From: start, To: start})
+	assert.ErrorIs(t, err, errbrick.ErrInvalidData, "the finished checkpoint must not be resumed")
+}
@@ -0,0 +1,130 @@
+// Package retention manages how long the messages of the chat rooms are kept for.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+)
+
+// MaxDays is the longest limited retention. Cassandra does not support TTLs longer than 20 years.
+const MaxDays = 7300
+
+// Config is the configuration of the retention enforcement.
+type Config struct {
+	// CacheTTL is how long the policies are cached for by the writer and the API.
+	CacheTTL time.Duration `default:"1m" split_words:"true" json:"cache_ttl"`
+	// SweepInterval is the interval the Sweeper runs with.
+	SweepInterval time.Duration `default:"1h" split_words:"true" json:"sweep_interval"`
+	SweepEnabled  bool          `default:"true" split_words:"true" json:"sweep_enabled"`
+}
+
+// Policy is the message retention policy of the chat room.
+type Policy struct {
+	// UpdatedAt is the time the policy was changed at.
+	UpdatedAt time.Time `json:"updated_at"`
+	// AppliedAt is the time the policy was applied to the existing messages at by the Sweeper.
+	AppliedAt  time.Time `json:"-"`
+	RoomChatID string    `json:"room_chat_id"`
+	// Days is the number of days the messages are kept for. Zero means the messages are kept permanently.
+	Days int `json:"retention_days"`
+}
+
+// Permanent is true if the messages are never expired.
+func (p Policy) Permanent() bool {
+	return p.Days == 0
+}
+
+// Duration returns how long the messages are kept for. Zero means the messages are kept permanently.
+func (p Policy) Duration() time.Duration {
+	return time.Duration(p.Days) * 24 * time.Hour
+}
+
+// Pending is true if the policy has been changed after it was applied to the existing messages.
+func (p Policy) Pending() bool {
+	return p.AppliedAt.Before(p.UpdatedAt)
+}
+
+func (p Policy) validate() error {
+	if p.RoomChatID == "" {
+		return fmt.Errorf("%w: room chat id is empty", errbrick.ErrInvalidData)
+	}
+	if p.Days < 0 || p.Days > MaxDays {
+		return fmt.Errorf("%w: retention days must be between 0 and %d", errbrick.ErrInvalidData, MaxDays)
+	}
+	return nil
+}
+
+// Store persists the retention policies.
+type Store interface {
+	// GetRetention returns the policy of the chat room. The rooms without a policy are permanent.
+	GetRetention(ctx context.Context, roomChatID string) (Policy, error)
+	// SetRetention creates or replaces the policy of the chat room.
+	SetRetention(ctx context.Context, p Policy) error
+	// ListRetentions returns all policies.
+	ListRetentions(ctx context.Context) ([]Policy, error)
+	// MarkRetentionApplied records that the policy has been applied to the existing messages.
+	MarkRetentionApplied(ctx context.Context, roomChatID string, appliedAt time.Time) error
+}
+
+// Set validates and saves the retention policy of the chat room.
+// The existing messages are brought in line with the policy by the Sweeper.
+func Set(ctx context.Context, s Store, roomChatID string, days int) (Policy, error) {
+	p := Policy{RoomChatID: roomChatID, Days: days, UpdatedAt: time.Now().UTC()}
+	if err := p.validate(); err != nil {
+		return Policy{}, err
+	}
+	if err := s.SetRetention(ctx, p); err != nil {
+		return Policy{}, fmt.Errorf("failed save retention: %w", err)
+	}
+	return p, nil
+}
+
+// Cache is the in-memory cache of the retention policies used to resolve the TTL of every written message.
+// The policy changes are picked up after the cache TTL expires.
+type Cache struct {
+	store    Store
+	policies map[string]cachedPolicy
+	ttl      time.Duration
+	mu       sync.Mutex
+}
+
+type cachedPolicy struct {
+	expiresAt time.Time
+	policy    Policy
+}
+
+// NewCache creates a new Cache.
+func NewCache(store Store, ttl time.Duration) *Cache {
+	return &Cache{store: store, ttl: ttl, policies: map[string]cachedPolicy{}}
+}
+
+// Retention implements writer.RetentionResolver.
+func (c *Cache) Retention(ctx context.Context, roomChatID string) (time.Duration, error) {
+	p, err := c.Policy(ctx, roomChatID)
+	if err != nil {
+		return 0, err
+	}
+	return p.Duration(), nil
+}
+
+// Policy returns the cached policy of the chat room.
+func (c *Cache) Policy(ctx context.Context, roomChatID string) (Policy, error) {
+	c.mu.Lock()
+	cp, ok := c.policies[roomChatID]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cp.expiresAt) {
+		return cp.policy, nil
+	}
+	p, err := c.store.GetRetention(ctx, roomChatID)
+	if err != nil {
+		return Policy{}, err
+	}
+	c.mu.Lock()
+	c.policies[roomChatID] = cachedPolicy{policy: p, expiresAt: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return p, nil
+}
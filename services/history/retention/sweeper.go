@@ -0,0 +1,131 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/writer"
+)
+
+// MessageStore is the storage of the messages the retention is enforced on.
+type MessageStore interface {
+	writer.Store
+	// DeleteBefore deletes the messages of the chat room created before the time.
+	DeleteBefore(ctx context.Context, roomChatID string, before time.Time) error
+	// Rewrite rewrites the stored message with the TTL of the record only if it still exists,
+	// so the message deleted after it was read, e.g. erased, is not written again.
+	Rewrite(ctx context.Context, rec writer.Record) error
+}
+
+// RoomInvalidator drops the copies of the room messages kept outside the store, e.g. the recent messages cache.
+type RoomInvalidator interface {
+	Invalidate(ctx context.Context, roomChatID string) error
+}
+
+// Sweeper enforces the retention policies on the existing messages.
+//
+// The new messages get the TTL of the policy when they are written, so Cassandra expires them on its own.
+// The sweeper covers the rest:
+//   - deletes the messages out of the retention, which is how the messages expire in the SQL backends
+//     and how a shortened retention takes effect immediately;
+//   - rewrites the messages of the rooms with a changed policy, so their TTL follows the new policy,
+//     e.g. the messages of a room made permanent no longer expire.
+//
+// Every sweep is idempotent, so running it from several instances is safe.
+type Sweeper struct {
+	store       Store
+	msgs        MessageStore
+	loader      *loader.Loader
+	invalidator RoomInvalidator
+	interval    time.Duration
+}
+
+// NewSweeper creates a new Sweeper. The invalidator drops the cached messages of the swept rooms, it may be nil.
+func NewSweeper(store Store, msgs MessageStore, l *loader.Loader, invalidator RoomInvalidator, interval time.Duration) *Sweeper {
+	return &Sweeper{store: store, msgs: msgs, loader: l, invalidator: invalidator, interval: interval}
+}
+
+// Run sweeps periodically until the context is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		if err := s.Sweep(ctx); err != nil {
+			slogbrick.FromCtx(ctx).Error("failed sweep expired messages", slog.Any("err", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Sweep enforces all retention policies once.
+func (s *Sweeper) Sweep(ctx context.Context) error {
+	policies, err := s.store.ListRetentions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed list retentions: %w", err)
+	}
+	for _, p := range policies {
+		if err := s.sweepRoom(ctx, p); err != nil {
+			return fmt.Errorf("failed sweep room %s: %w", p.RoomChatID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Sweeper) sweepRoom(ctx context.Context, p Policy) error {
+	now := time.Now().UTC()
+	if !p.Permanent() {
+		if err := s.msgs.DeleteBefore(ctx, p.RoomChatID, now.Add(-p.Duration())); err != nil {
+			return fmt.Errorf("failed delete expired messages: %w", err)
+		}
+		// the cache would keep serving the deleted messages until they are evicted by the newer ones
+		if s.invalidator != nil {
+			if err := s.invalidator.Invalidate(ctx, p.RoomChatID); err != nil {
+				return fmt.Errorf("failed invalidate room cache: %w", err)
+			}
+		}
+	}
+	if !p.Pending() {
+		return nil
+	}
+	var rewritten int
+	err := s.loader.Walk(ctx, p.RoomChatID, func(m loader.Message) error {
+		rec := writer.Record{CreateParams: createParams(p.RoomChatID, m)}
+		if !p.Permanent() {
+			rec.TTL = m.CreatedAt.Add(p.Duration()).Sub(now)
+			if rec.TTL <= 0 {
+				return nil
+			}
+		}
+		rewritten++
+		return s.msgs.Rewrite(ctx, rec)
+	})
+	if err != nil {
+		return fmt.Errorf("failed apply retention to messages: %w", err)
+	}
+	if err := s.store.MarkRetentionApplied(ctx, p.RoomChatID, now); err != nil {
+		return fmt.Errorf("failed mark retention applied: %w", err)
+	}
+	slogbrick.FromCtx(ctx).Info("applied changed room retention",
+		slog.String("room_chat_id", p.RoomChatID), slog.Int("retention_days", p.Days), slog.Int("messages", rewritten))
+	return nil
+}
+
+func createParams(roomChatID string, m loader.Message) writer.CreateParams {
+	return writer.CreateParams{
+		MsgID:      m.ID,
+		Seq:        m.Seq,
+		RoomChatID: roomChatID,
+		Msg:        m.Msg,
+		CreatedAt:  m.CreatedAt,
+		PendingID:  m.PendingID,
+		User:       writer.UserParams{ID: m.User.ID},
+	}
+}
@@ -0,0 +1,72 @@
+package retention_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/storage/sqlstore"
+	"github.com/demeero/chat/history/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roomInvalidator map[string]bool
+
+func (i roomInvalidator) Invalidate(_ context.Context, roomChatID string) error {
+	i[roomChatID] = true
+	return nil
+}
+
+func TestSweeper_Sweep(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlstore.Open(ctx, sqlstore.SQLite, filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	w := writer.New(s, retention.NewCache(s, time.Minute), nil)
+	now := time.Now().UTC()
+	for _, age := range []time.Duration{10 * 24 * time.Hour, 5 * 24 * time.Hour, time.Hour} {
+		_, err := w.Create(ctx, writer.CreateParams{
+			RoomChatID: "room-1",
+			Msg:        "msg",
+			CreatedAt:  now.Add(-age),
+			User:       writer.UserParams{ID: "user-1"},
+		})
+		require.NoError(t, err)
+	}
+	l := loader.New(s, nil, nil)
+	count := func() int {
+		var n int
+		require.NoError(t, l.Walk(ctx, "room-1", func(loader.Message) error {
+			n++
+			return nil
+		}))
+		return n
+	}
+	require.Equal(t, 3, count())
+
+	_, err = retention.Set(ctx, s, "room-1", 7)
+	require.NoError(t, err)
+	invalidated := roomInvalidator{}
+	sweeper := retention.NewSweeper(s, s, l, invalidated, time.Hour)
+	require.NoError(t, sweeper.Sweep(ctx))
+	assert.Equal(t, 2, count())
+	assert.True(t, invalidated["room-1"], "the cached messages of the swept room must be dropped")
+	p, err := s.GetRetention(ctx, "room-1")
+	require.NoError(t, err)
+	assert.False(t, p.Pending())
+
+	// the writer does not store the messages which are already out of the retention
+	_, err = writer.New(s, retention.NewCache(s, time.Minute), nil).Create(ctx, writer.CreateParams{
+		RoomChatID: "room-1",
+		Msg:        "old",
+		CreatedAt:  now.Add(-8 * 24 * time.Hour),
+		User:       writer.UserParams{ID: "user-1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count())
+}
@@ -0,0 +1,323 @@
+package search
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/highlight/highlighter/html"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/chat/history/loader"
+)
+
+const (
+	// maxMemberRooms is the maximum number of rooms taken into account when the search is filtered by membership.
+	maxMemberRooms  = 1000
+	defaultPageSize = 30
+	maxPageSize     = 100
+)
+
+// Document is a chat message stored in the search index.
+type Document struct {
+	MsgID      string    `json:"msg_id"`
+	ChatRoomID string    `json:"chat_room_id"`
+	PendingID  string    `json:"pending_id"`
+	Msg        string    `json:"msg"`
+	UserID     string    `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Query is the search query.
+type Query struct {
+	From time.Time
+	To   time.Time
+	// Text is the full-text query over the message text.
+	Text string
+	// CallerID is the ID of the user who performs the search.
+	// The search is restricted to the rooms the caller is a member of.
+	CallerID   string
+	ChatRoomID string
+	UserID     string
+	PageToken  string
+	PageSize   uint16
+}
+
+func (q Query) validate() error {
+	if q.Text == "" {
+		return errors.New("query text is empty")
+	}
+	if q.CallerID == "" {
+		return errors.New("caller id is empty")
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.From.After(q.To) {
+		return errors.New("from is after to")
+	}
+	return nil
+}
+
+// Hit is a single search result.
+type Hit struct {
+	ChatRoomID string         `json:"chat_room_id"`
+	Message    loader.Message `json:"message"`
+	// Highlights are the fragments of the message text with the matched terms wrapped into <mark> tags.
+	Highlights []string `json:"highlights"`
+	Score      float64  `json:"score"`
+}
+
+// Result is the page of search results.
+type Result struct {
+	Hits          []Hit  `json:"hits"`
+	NextPageToken string `json:"next_page_token"`
+	Total         uint64 `json:"total"`
+}
+
+// Index is the embedded full-text index of chat messages.
+type Index struct {
+	idx bleve.Index
+}
+
+// Open opens the index located at the path or creates a new one if it does not exist.
+func Open(path string) (*Index, error) {
+	idx, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		idx, err = bleve.New(path, newMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed open search index %s: %w", path, err)
+	}
+	return &Index{idx: idx}, nil
+}
+
+func newMapping() mapping.IndexMapping {
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = standard.Name
+	text.IncludeTermVectors = true
+
+	kw := bleve.NewTextFieldMapping()
+	kw.Analyzer = keyword.Name
+
+	storedOnly := bleve.NewTextFieldMapping()
+	storedOnly.Index = false
+	storedOnly.IncludeInAll = false
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("msg", text)
+	doc.AddFieldMappingsAt("chat_room_id", kw)
+	doc.AddFieldMappingsAt("user_id", kw)
+	doc.AddFieldMappingsAt("created_at", bleve.NewDateTimeFieldMapping())
+	doc.AddFieldMappingsAt("msg_id", storedOnly)
+	doc.AddFieldMappingsAt("pending_id", storedOnly)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	return m
+}
+
+// Index adds the document to the index.
+// Indexing the same message twice overwrites the previous version.
+func (i *Index) Index(doc Document) error {
+	if doc.MsgID == "" {
+		return fmt.Errorf("%w: msg id is empty", errbrick.ErrInvalidData)
+	}
+	if err := i.idx.Index(doc.MsgID, doc); err != nil {
+		return fmt.Errorf("failed index msg %s: %w", doc.MsgID, err)
+	}
+	return nil
+}
+
+// Search searches the messages in the rooms the caller is a member of.
+// A user is considered a member of the room as soon as they have posted to it.
+func (i *Index) Search(ctx context.Context, q Query) (Result, error) {
+	if err := q.validate(); err != nil {
+		return Result{}, fmt.Errorf("%w: %s", errbrick.ErrInvalidData, err)
+	}
+	offset, err := decodePageToken(q.PageToken)
+	if err != nil {
+		return Result{}, err
+	}
+	rooms, err := i.MemberRooms(ctx, q.CallerID)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed load member rooms: %w", err)
+	}
+	if q.ChatRoomID != "" {
+		if !slices.Contains(rooms, q.ChatRoomID) {
+			return Result{}, fmt.Errorf("%w: caller is not a member of the room", errbrick.ErrForbidden)
+		}
+		rooms = []string{q.ChatRoomID}
+	}
+	if len(rooms) == 0 {
+		return Result{Hits: []Hit{}}, nil
+	}
+
+	pageSize := int(q.PageSize)
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	req := bleve.NewSearchRequestOptions(buildQuery(q, rooms), pageSize, offset, false)
+	req.Fields = []string{"*"}
+	req.Highlight = bleve.NewHighlightWithStyle(html.Name)
+	req.Highlight.AddField("msg")
+	req.SortBy([]string{"-_score", "-created_at"})
+	res, err := i.idx.SearchInContext(ctx, req)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed search: %w", err)
+	}
+
+	result := Result{Hits: make([]Hit, 0, len(res.Hits)), Total: res.Total}
+	for _, h := range res.Hits {
+		result.Hits = append(result.Hits, newHit(h.Fields, h.Fragments["msg"], h.Score))
+	}
+	if next := offset + len(res.Hits); uint64(next) < res.Total {
+		result.NextPageToken = encodePageToken(next)
+	}
+	return result, nil
+}
+
+// MemberRooms returns the IDs of the rooms the user has posted to.
+func (i *Index) MemberRooms(ctx context.Context, userID string) ([]string, error) {
+	q := bleve.NewTermQuery(userID)
+	q.SetField("user_id")
+	req := bleve.NewSearchRequestOptions(q, 0, 0, false)
+	req.AddFacet("rooms", bleve.NewFacetRequest("chat_room_id", maxMemberRooms))
+	res, err := i.idx.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed search rooms: %w", err)
+	}
+	facet, ok := res.Facets["rooms"]
+	if !ok {
+		return nil, nil
+	}
+	terms := facet.Terms.Terms()
+	rooms := make([]string, 0, len(terms))
+	for _, t := range terms {
+		rooms = append(rooms, t.Term)
+	}
+	return rooms, nil
+}
+
+// EraseUser removes the messages of the user from the index.
+// If anonymousID is not empty, the messages are kept, but attributed to the anonymous user without the user data.
+func (i *Index) EraseUser(ctx context.Context, userID, anonymousID string) (int, error) {
+	var erased int
+	for {
+		q := bleve.NewTermQuery(userID)
+		q.SetField("user_id")
+		// the erased documents no longer match the query, so the first page is always the next one
+		req := bleve.NewSearchRequestOptions(q, maxPageSize, 0, false)
+		req.Fields = []string{"*"}
+		res, err := i.idx.SearchInContext(ctx, req)
+		if err != nil {
+			return erased, fmt.Errorf("failed search user messages: %w", err)
+		}
+		if len(res.Hits) == 0 {
+			return erased, nil
+		}
+		b := i.idx.NewBatch()
+		for _, h := range res.Hits {
+			if anonymousID == "" {
+				b.Delete(h.ID)
+				continue
+			}
+			hit := newHit(h.Fields, nil, 0)
+			doc := Document{
+				MsgID:      hit.Message.ID,
+				ChatRoomID: hit.ChatRoomID,
+				PendingID:  hit.Message.PendingID,
+				Msg:        hit.Message.Msg,
+				UserID:     anonymousID,
+				CreatedAt:  hit.Message.CreatedAt,
+			}
+			if err := b.Index(h.ID, doc); err != nil {
+				return erased, fmt.Errorf("failed anonymize msg %s: %w", h.ID, err)
+			}
+		}
+		if err := i.idx.Batch(b); err != nil {
+			return erased, fmt.Errorf("failed erase user messages: %w", err)
+		}
+		erased += len(res.Hits)
+	}
+}
+
+// Close closes the index.
+func (i *Index) Close() error {
+	return i.idx.Close()
+}
+
+func buildQuery(q Query, rooms []string) query.Query {
+	text := bleve.NewMatchQuery(q.Text)
+	text.SetField("msg")
+
+	roomQueries := make([]query.Query, 0, len(rooms))
+	for _, r := range rooms {
+		rq := bleve.NewTermQuery(r)
+		rq.SetField("chat_room_id")
+		roomQueries = append(roomQueries, rq)
+	}
+	conj := bleve.NewConjunctionQuery(text, bleve.NewDisjunctionQuery(roomQueries...))
+
+	if q.UserID != "" {
+		uq := bleve.NewTermQuery(q.UserID)
+		uq.SetField("user_id")
+		conj.AddQuery(uq)
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		dq := bleve.NewDateRangeQuery(q.From, q.To)
+		dq.SetField("created_at")
+		conj.AddQuery(dq)
+	}
+	return conj
+}
+
+func newHit(fields map[string]interface{}, highlights []string, score float64) Hit {
+	str := func(name string) string {
+		s, _ := fields[name].(string)
+		return s
+	}
+	createdAt, _ := time.Parse(time.RFC3339, str("created_at"))
+	if highlights == nil {
+		highlights = []string{}
+	}
+	return Hit{
+		ChatRoomID: str("chat_room_id"),
+		Message: loader.Message{
+			ID:        str("msg_id"),
+			PendingID: str("pending_id"),
+			Msg:       str("msg"),
+			User:      loader.MsgUser{ID: str("user_id")},
+			CreatedAt: createdAt,
+		},
+		Highlights: highlights,
+		Score:      score,
+	}
+}
+
+func decodePageToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to decode token from base64: %s", errbrick.ErrInvalidData, err)
+	}
+	offset, err := strconv.Atoi(string(b))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("%w: invalid page token", errbrick.ErrInvalidData)
+	}
+	return offset, nil
+}
+
+func encodePageToken(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
@@ -0,0 +1,58 @@
+package search
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexSearch(t *testing.T) {
+	idx, err := Open(filepath.Join(t.TempDir(), "index.bleve"))
+	require.NoError(t, err)
+	defer idx.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	docs := []Document{
+		{MsgID: "1", ChatRoomID: "general", UserID: "alice", Msg: "deploy the release today", CreatedAt: now.Add(-time.Hour)},
+		{MsgID: "2", ChatRoomID: "general", UserID: "bob", Msg: "release notes are ready", CreatedAt: now},
+		{MsgID: "3", ChatRoomID: "private", UserID: "bob", Msg: "secret release plans", CreatedAt: now},
+	}
+	for _, d := range docs {
+		require.NoError(t, idx.Index(d))
+	}
+	ctx := context.Background()
+
+	res, err := idx.Search(ctx, Query{Text: "release", CallerID: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), res.Total)
+	for _, h := range res.Hits {
+		assert.Equal(t, "general", h.ChatRoomID)
+		assert.NotEmpty(t, h.Highlights)
+	}
+
+	res, err = idx.Search(ctx, Query{Text: "release", CallerID: "alice", UserID: "bob", From: now.Add(-time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, res.Hits, 1)
+	assert.Equal(t, "2", res.Hits[0].Message.ID)
+
+	res, err = idx.Search(ctx, Query{Text: "release", CallerID: "bob", PageSize: 2})
+	require.NoError(t, err)
+	assert.Len(t, res.Hits, 2)
+	require.NotEmpty(t, res.NextPageToken)
+	res, err = idx.Search(ctx, Query{Text: "release", CallerID: "bob", PageSize: 2, PageToken: res.NextPageToken})
+	require.NoError(t, err)
+	assert.Len(t, res.Hits, 1)
+	assert.Empty(t, res.NextPageToken)
+
+	_, err = idx.Search(ctx, Query{Text: "release", CallerID: "alice", ChatRoomID: "private"})
+	assert.ErrorIs(t, err, errbrick.ErrForbidden)
+
+	res, err = idx.Search(ctx, Query{Text: "release", CallerID: "carol"})
+	require.NoError(t, err)
+	assert.Empty(t, res.Hits)
+}
@@ -0,0 +1,67 @@
+// Package sequence assigns the per-room sequence numbers of the messages.
+package sequence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// incrExisting increments the counter only if it exists, so a lost counter is seeded from the store first.
+var incrExisting = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+return redis.call('INCR', KEYS[1])
+`)
+
+// Store returns the highest stored sequence number of the room the lost counters are seeded from.
+type Store interface {
+	// MaxSeq returns the highest sequence number of the stored messages of the room, zero if there is none.
+	MaxSeq(ctx context.Context, roomChatID string) (int64, error)
+}
+
+// Redis is the writer.Sequencer backed by the Redis INCR on the room_seq:<room> counter.
+// The counter is kept without expiration. If it is lost, e.g. Redis is restored without the data,
+// it continues from the highest sequence number stored in the room, whatever the creation time of the message is.
+//
+// The sequence number is taken before the message is written, so the failed write leaves a gap in the sequence.
+// The gaps are never filled: the clients detecting one refetch the history and find no message there.
+type Redis struct {
+	rdb   redis.UniversalClient
+	store Store
+}
+
+// NewRedis creates a new Redis sequencer.
+func NewRedis(rdb redis.UniversalClient, store Store) *Redis {
+	return &Redis{rdb: rdb, store: store}
+}
+
+func key(roomChatID string) string {
+	return "room_seq:" + roomChatID
+}
+
+// Next implements writer.Sequencer.
+func (r *Redis) Next(ctx context.Context, roomChatID string) (int64, error) {
+	seq, err := incrExisting.Run(ctx, r.rdb, []string{key(roomChatID)}).Int64()
+	if err == nil {
+		return seq, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		return 0, fmt.Errorf("failed increment room sequence: %w", err)
+	}
+	start, err := r.store.MaxSeq(ctx, roomChatID)
+	if err != nil {
+		return 0, fmt.Errorf("failed load max seq to seed room sequence: %w", err)
+	}
+	// the concurrent writers seed the counter once, the others just increment it
+	if err := r.rdb.SetNX(ctx, key(roomChatID), start, 0).Err(); err != nil {
+		return 0, fmt.Errorf("failed seed room sequence: %w", err)
+	}
+	if seq, err = r.rdb.Incr(ctx, key(roomChatID)).Result(); err != nil {
+		return 0, fmt.Errorf("failed increment room sequence: %w", err)
+	}
+	return seq, nil
+}
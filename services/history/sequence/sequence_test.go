@@ -0,0 +1,41 @@
+package sequence
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type maxSeqStore map[string]int64
+
+func (s maxSeqStore) MaxSeq(_ context.Context, roomChatID string) (int64, error) {
+	return s[roomChatID], nil
+}
+
+func TestRedis_Next(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	seq := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}), maxSeqStore{"restored": 41})
+
+	for want := int64(1); want <= 3; want++ {
+		got, err := seq.Next(ctx, "room-1")
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	got, err := seq.Next(ctx, "room-2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), got, "every room has its own sequence")
+
+	// the lost counter continues from the highest stored sequence number
+	got, err = seq.Next(ctx, "restored")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), got)
+	mr.FlushAll()
+	got, err = seq.Next(ctx, "room-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), got, "the room without stored messages starts over")
+}
@@ -0,0 +1,169 @@
+// Package slackimport imports the Slack workspace export into the chat history.
+package slackimport
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User is the Slack user of the workspace export.
+type User struct {
+	ID      string      `json:"id"`
+	Name    string      `json:"name"`
+	Profile UserProfile `json:"profile"`
+	IsBot   bool        `json:"is_bot"`
+}
+
+// UserProfile is the profile of the Slack user.
+type UserProfile struct {
+	Email       string `json:"email"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	RealName    string `json:"real_name"`
+	DisplayName string `json:"display_name"`
+}
+
+// Channel is the Slack channel of the workspace export.
+type Channel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Message is the Slack message of the channel.
+type Message struct {
+	Type      string     `json:"type"`
+	Subtype   string     `json:"subtype"`
+	User      string     `json:"user"`
+	Text      string     `json:"text"`
+	TS        string     `json:"ts"`
+	ThreadTS  string     `json:"thread_ts"`
+	Reactions []Reaction `json:"reactions"`
+}
+
+// Reaction is the emoji reaction to the Slack message.
+type Reaction struct {
+	Name  string   `json:"name"`
+	Users []string `json:"users"`
+	Count int      `json:"count"`
+}
+
+// Time returns the time of the message parsed from its Slack timestamp.
+func (m Message) Time() (time.Time, error) {
+	return parseTS(m.TS)
+}
+
+// IsReply is true if the message is a reply in a thread.
+func (m Message) IsReply() bool {
+	return m.ThreadTS != "" && m.ThreadTS != m.TS
+}
+
+// parseTS parses the Slack timestamp which is the unix time with microseconds, e.g. 1699999999.123456.
+func parseTS(ts string) (time.Time, error) {
+	secStr, fracStr, _ := strings.Cut(ts, ".")
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid slack ts %q: %w", ts, err)
+	}
+	var nsec int64
+	if fracStr != "" {
+		if len(fracStr) > 9 {
+			fracStr = fracStr[:9]
+		}
+		frac, err := strconv.ParseInt(fracStr, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid slack ts %q: %w", ts, err)
+		}
+		for i := len(fracStr); i < 9; i++ {
+			frac *= 10
+		}
+		nsec = frac
+	}
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// Archive is the opened Slack workspace export zip.
+// The export contains users.json, channels.json and a directory per channel with a JSON file of messages per day.
+type Archive struct {
+	zr       *zip.ReadCloser
+	Users    map[string]User
+	Channels []Channel
+}
+
+// OpenArchive opens the Slack workspace export zip and reads its users and channels.
+func OpenArchive(zipPath string) (*Archive, error) {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed open slack export: %w", err)
+	}
+	a := &Archive{zr: zr, Users: map[string]User{}}
+	if err := a.load(); err != nil {
+		return nil, errors.Join(err, zr.Close())
+	}
+	return a, nil
+}
+
+func (a *Archive) load() error {
+	var users []User
+	if err := a.readJSON("users.json", &users); err != nil {
+		return err
+	}
+	for _, u := range users {
+		a.Users[u.ID] = u
+	}
+	if err := a.readJSON("channels.json", &a.Channels); err != nil {
+		return err
+	}
+	// private channels are exported to groups.json by the workspace owners
+	var groups []Channel
+	err := a.readJSON("groups.json", &groups)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	a.Channels = append(a.Channels, groups...)
+	return nil
+}
+
+// Days returns the names of the daily message files of the channel sorted from the oldest.
+func (a *Archive) Days(ch Channel) ([]string, error) {
+	days, err := fs.Glob(a.zr, path.Join(ch.Name, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed list days of channel %s: %w", ch.Name, err)
+	}
+	// the files are named as YYYY-MM-DD.json, so the lexical order is the chronological one
+	slices.Sort(days)
+	return days, nil
+}
+
+// Messages returns the messages of the daily message file.
+func (a *Archive) Messages(day string) ([]Message, error) {
+	var msgs []Message
+	if err := a.readJSON(day, &msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// Close closes the export zip.
+func (a *Archive) Close() error {
+	return a.zr.Close()
+}
+
+func (a *Archive) readJSON(name string, v any) error {
+	f, err := a.zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed open %s: %w", name, err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("failed decode %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package slackimport
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/history/writer"
+)
+
+// IdentityResolver resolves the chat identity by its email.
+type IdentityResolver interface {
+	// ResolveByEmail returns the identity with the email. The bool is false if there is no such identity.
+	ResolveByEmail(ctx context.Context, email string) (writer.UserParams, bool, error)
+}
+
+// ProfileSaver saves the profiles of the imported users that have no chat identity.
+type ProfileSaver interface {
+	Upsert(ctx context.Context, profiles ...identity.Profile) error
+}
+
+// KratosResolver resolves the identities via the Kratos admin API.
+// The resolved identities are cached, because every user usually has many messages.
+type KratosResolver struct {
+	kratos *identity.Kratos
+	cache  map[string]kratosResult
+	mu     sync.Mutex
+}
+
+type kratosResult struct {
+	user  writer.UserParams
+	found bool
+}
+
+// NewKratosResolver creates a new KratosResolver.
+func NewKratosResolver(kratos *identity.Kratos) *KratosResolver {
+	return &KratosResolver{kratos: kratos, cache: map[string]kratosResult{}}
+}
+
+func (r *KratosResolver) ResolveByEmail(ctx context.Context, email string) (writer.UserParams, bool, error) {
+	email = strings.ToLower(email)
+	r.mu.Lock()
+	res, ok := r.cache[email]
+	r.mu.Unlock()
+	if ok {
+		return res.user, res.found, nil
+	}
+	p, found, err := r.kratos.ByEmail(ctx, email)
+	if err != nil {
+		return writer.UserParams{}, false, err
+	}
+	res = kratosResult{user: writer.UserParams{ID: p.ID}, found: found}
+	r.mu.Lock()
+	r.cache[email] = res
+	r.mu.Unlock()
+	return res.user, res.found, nil
+}
@@ -0,0 +1,328 @@
+package slackimport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/history/writer"
+	"github.com/gocql/gocql"
+	"golang.org/x/sync/errgroup"
+)
+
+// Config is the configuration of the Importer.
+type Config struct {
+	// RoomMap maps the Slack channel names to the chat room IDs.
+	// The channels missing in the map are imported into the rooms with the channel name as ID.
+	RoomMap map[string]string
+	// StatePath is the file the import progress is saved to. The import is resumed from it if it exists.
+	StatePath string
+	// Workers is the number of concurrent inserts. Defaults to 8.
+	Workers int
+	// KeepUnresolved imports the messages of the users whose email does not match any identity
+	// with the slack:<slack user id> user ID and saves their Slack profiles. Otherwise, such messages are skipped.
+	KeepUnresolved bool
+}
+
+// Stats is the summary of the import.
+type Stats struct {
+	Channels    int
+	Days        int
+	ResumedDays int
+	Messages    int
+	Replies     int
+	Skipped     int
+	// Reactions is the number of reactions that have not been imported, because the history schema has no reactions.
+	Reactions int
+}
+
+// state is the import progress checkpointed after every imported day.
+type state struct {
+	// Done is the set of imported days keyed by <channel id>/<day file>.
+	Done map[string]bool `json:"done"`
+}
+
+// Importer imports the Slack workspace export into the chat history.
+//
+// Messages are written with their original timestamps and the message IDs derived from the channel and the Slack timestamp,
+// so writing the same message again overwrites it instead of creating a duplicate.
+// Together with the saved progress it makes the import resumable: a run interrupted in the middle of a day
+// is resumed from that day and the already written messages of the day are just rewritten.
+//
+// The history schema has neither threads nor reactions, so thread replies are imported as regular messages of the room
+// in chronological order and reactions are only counted.
+type Importer struct {
+	w        *writer.Writer
+	resolver IdentityResolver
+	profiles ProfileSaver
+	users    map[string]*writer.UserParams
+	cfg      Config
+}
+
+// New creates a new Importer.
+// The profiles keep the Slack profiles of the unresolved users, it may be nil if Config.KeepUnresolved is not set.
+func New(w *writer.Writer, resolver IdentityResolver, profiles ProfileSaver, cfg Config) *Importer {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 8
+	}
+	return &Importer{w: w, resolver: resolver, profiles: profiles, cfg: cfg, users: map[string]*writer.UserParams{}}
+}
+
+// Run imports all channels of the archive.
+func (i *Importer) Run(ctx context.Context, a *Archive) (Stats, error) {
+	st, err := i.loadState()
+	if err != nil {
+		return Stats{}, err
+	}
+	var stats Stats
+	for _, ch := range a.Channels {
+		if err := i.importChannel(ctx, a, ch, st, &stats); err != nil {
+			return stats, err
+		}
+		stats.Channels++
+	}
+	return stats, nil
+}
+
+func (i *Importer) importChannel(ctx context.Context, a *Archive, ch Channel, st *state, stats *Stats) error {
+	roomID := ch.Name
+	if mapped, ok := i.cfg.RoomMap[ch.Name]; ok {
+		roomID = mapped
+	}
+	lg := slogbrick.FromCtx(ctx).With(slog.String("channel", ch.Name), slog.String("room_chat_id", roomID))
+	days, err := a.Days(ch)
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		key := ch.ID + "/" + path.Base(day)
+		if st.Done[key] {
+			stats.ResumedDays++
+			continue
+		}
+		msgs, err := a.Messages(day)
+		if err != nil {
+			return err
+		}
+		n, err := i.importDay(ctx, a, ch, roomID, msgs, stats)
+		if err != nil {
+			return fmt.Errorf("failed import %s: %w", day, err)
+		}
+		st.Done[key] = true
+		if err := i.saveState(st); err != nil {
+			return err
+		}
+		stats.Days++
+		lg.Debug("imported day", slog.String("day", day), slog.Int("messages", n))
+	}
+	lg.Info("imported channel")
+	return nil
+}
+
+func (i *Importer) importDay(ctx context.Context, a *Archive, ch Channel, roomID string, msgs []Message, stats *Stats) (int, error) {
+	params := make([]writer.CreateParams, 0, len(msgs))
+	for _, m := range msgs {
+		for _, r := range m.Reactions {
+			stats.Reactions += r.Count
+		}
+		p, ok, err := i.createParams(ctx, a, ch, roomID, m)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			stats.Skipped++
+			continue
+		}
+		if m.IsReply() {
+			stats.Replies++
+		}
+		params = append(params, p)
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(i.cfg.Workers)
+	for _, p := range params {
+		p := p
+		g.Go(func() error {
+			if _, err := i.w.Create(gCtx, p); err != nil {
+				return fmt.Errorf("failed write msg %s: %w", p.MsgID, err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return 0, err
+	}
+	stats.Messages += len(params)
+	return len(params), nil
+}
+
+// importedSubtypes are the subtypes of the messages written by users. The rest are the system messages like channel joins.
+var importedSubtypes = map[string]bool{
+	"":                 true,
+	"thread_broadcast": true,
+	"me_message":       true,
+	"file_share":       true,
+}
+
+func (i *Importer) createParams(ctx context.Context, a *Archive, ch Channel, roomID string, m Message) (writer.CreateParams, bool, error) {
+	if m.Type != "message" || !importedSubtypes[m.Subtype] || m.User == "" {
+		return writer.CreateParams{}, false, nil
+	}
+	text := convertText(a, m.Text)
+	if text == "" {
+		return writer.CreateParams{}, false, nil
+	}
+	createdAt, err := m.Time()
+	if err != nil {
+		return writer.CreateParams{}, false, err
+	}
+	user, err := i.user(ctx, a, m.User)
+	if err != nil || user == nil {
+		return writer.CreateParams{}, false, err
+	}
+	return writer.CreateParams{
+		MsgID:      msgID(ch.ID, m.TS, createdAt),
+		RoomChatID: roomID,
+		Msg:        text,
+		CreatedAt:  createdAt,
+		User:       *user,
+	}, true, nil
+}
+
+// user maps the Slack user to the chat identity by email. It returns nil if the user must be skipped.
+func (i *Importer) user(ctx context.Context, a *Archive, slackID string) (*writer.UserParams, error) {
+	if u, ok := i.users[slackID]; ok {
+		return u, nil
+	}
+	su, ok := a.Users[slackID]
+	if !ok || su.IsBot || su.Profile.Email == "" {
+		i.users[slackID] = nil
+		return nil, nil
+	}
+	u, found, err := i.resolver.ResolveByEmail(ctx, su.Profile.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed resolve identity of slack user %s: %w", slackID, err)
+	}
+	switch {
+	case found:
+		i.users[slackID] = &u
+	case i.cfg.KeepUnresolved:
+		first, last := su.Profile.FirstName, su.Profile.LastName
+		if first == "" && last == "" {
+			first, last, _ = strings.Cut(su.Profile.RealName, " ")
+		}
+		u := writer.UserParams{ID: "slack:" + slackID}
+		if i.profiles != nil {
+			p := identity.Profile{
+				ID:        u.ID,
+				Email:     su.Profile.Email,
+				FirstName: first,
+				LastName:  last,
+				UpdatedAt: time.Now().UTC(),
+			}
+			if err := i.profiles.Upsert(ctx, p); err != nil {
+				return nil, fmt.Errorf("failed save profile of slack user %s: %w", slackID, err)
+			}
+		}
+		i.users[slackID] = &u
+	default:
+		slogbrick.FromCtx(ctx).Warn("no identity for slack user - skip messages",
+			slog.String("slack_user_id", slackID), slog.String("email", su.Profile.Email))
+		i.users[slackID] = nil
+	}
+	return i.users[slackID], nil
+}
+
+// msgID derives the TimeUUID of the message from the channel and the Slack timestamp.
+// The time part is the original message time, so the messages are still ordered by their IDs.
+func msgID(channelID, ts string, createdAt time.Time) string {
+	id := gocql.UUIDFromTime(createdAt)
+	sum := sha256.Sum256([]byte(channelID + "/" + ts))
+	// replace the random clock sequence and node with the hash keeping the RFC 4122 variant
+	copy(id[8:], sum[:8])
+	id[8] = id[8]&0x3f | 0x80
+	return id.String()
+}
+
+var (
+	userMentionRe    = regexp.MustCompile(`<@([A-Z0-9]+)(?:\|[^>]*)?>`)
+	channelMentionRe = regexp.MustCompile(`<#[A-Z0-9]+\|([^>]*)>`)
+	specialMentionRe = regexp.MustCompile(`<!([a-z]+)(?:\|[^>]*)?>`)
+	linkRe           = regexp.MustCompile(`<([^@#!|>][^|>]*)(?:\|([^>]*))?>`)
+	entityReplacer   = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+)
+
+// convertText converts the Slack markup of mentions and links into plain text.
+func convertText(a *Archive, text string) string {
+	text = userMentionRe.ReplaceAllStringFunc(text, func(s string) string {
+		id := userMentionRe.FindStringSubmatch(s)[1]
+		if u, ok := a.Users[id]; ok {
+			if u.Profile.DisplayName != "" {
+				return "@" + u.Profile.DisplayName
+			}
+			return "@" + u.Name
+		}
+		return "@" + id
+	})
+	text = channelMentionRe.ReplaceAllString(text, "#$1")
+	text = specialMentionRe.ReplaceAllString(text, "@$1")
+	text = linkRe.ReplaceAllStringFunc(text, func(s string) string {
+		m := linkRe.FindStringSubmatch(s)
+		if m[2] == "" || m[2] == m[1] {
+			return m[1]
+		}
+		return m[2] + " (" + m[1] + ")"
+	})
+	return strings.TrimSpace(entityReplacer.Replace(text))
+}
+
+func (i *Importer) loadState() (*state, error) {
+	st := &state{Done: map[string]bool{}}
+	if i.cfg.StatePath == "" {
+		return st, nil
+	}
+	b, err := os.ReadFile(i.cfg.StatePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed read import state: %w", err)
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("failed decode import state: %w", err)
+	}
+	if st.Done == nil {
+		st.Done = map[string]bool{}
+	}
+	return st, nil
+}
+
+// saveState writes the state to a temporary file and renames it, so an interrupted write never corrupts the state.
+func (i *Importer) saveState(st *state) error {
+	if i.cfg.StatePath == "" {
+		return nil
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed encode import state: %w", err)
+	}
+	tmp := i.cfg.StatePath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("failed write import state: %w", err)
+	}
+	if err := os.Rename(tmp, i.cfg.StatePath); err != nil {
+		return fmt.Errorf("failed save import state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package slackimport
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/storage/sqlstore"
+	"github.com/demeero/chat/history/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resolverFunc func(email string) (writer.UserParams, bool)
+
+func (f resolverFunc) ResolveByEmail(_ context.Context, email string) (writer.UserParams, bool, error) {
+	u, ok := f(email)
+	return u, ok, nil
+}
+
+type profileSaver map[string]identity.Profile
+
+func (s profileSaver) Upsert(_ context.Context, profiles ...identity.Profile) error {
+	for _, p := range profiles {
+		s[p.ID] = p
+	}
+	return nil
+}
+
+func writeExport(t *testing.T, files map[string]any) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "export.zip")
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, v := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		require.NoError(t, json.NewEncoder(w).Encode(v))
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+	return p
+}
+
+func TestImporter_Run(t *testing.T) {
+	ctx := context.Background()
+	zipPath := writeExport(t, map[string]any{
+		"users.json": []User{
+			{ID: "U1", Name: "alice", Profile: UserProfile{Email: "alice@example.com", DisplayName: "Alice"}},
+			{ID: "U2", Name: "bob", Profile: UserProfile{Email: "bob@example.com", RealName: "Bob Smith"}},
+			{ID: "B1", Name: "bot", IsBot: true},
+		},
+		"channels.json": []Channel{{ID: "C1", Name: "general"}},
+		"general/2023-01-01.json": []Message{
+			{Type: "message", User: "U1", Text: "hi &lt;all&gt; <!here>", TS: "1672531200.000100", ThreadTS: "1672531200.000100",
+				Reactions: []Reaction{{Name: "wave", Users: []string{"U2"}, Count: 1}}},
+			{Type: "message", Subtype: "channel_join", User: "U2", Text: "<@U2> has joined the channel", TS: "1672531201.000100"},
+			{Type: "message", User: "U2", Text: "hey <@U1>, see <https://example.com|this>", TS: "1672531202.000100", ThreadTS: "1672531200.000100"},
+			{Type: "message", User: "B1", Text: "beep", TS: "1672531203.000100"},
+		},
+		"general/2023-01-02.json": []Message{
+			{Type: "message", User: "U1", Text: "next day", TS: "1672617600.000100"},
+		},
+	})
+	s, err := sqlstore.Open(ctx, sqlstore.SQLite, filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	resolver := resolverFunc(func(email string) (writer.UserParams, bool) {
+		if email == "alice@example.com" {
+			return writer.UserParams{ID: "identity-1"}, true
+		}
+		return writer.UserParams{}, false
+	})
+
+	profiles := profileSaver{}
+	run := func(cfg Config) Stats {
+		a, err := OpenArchive(zipPath)
+		require.NoError(t, err)
+		defer a.Close()
+		stats, err := New(writer.New(s, nil, nil), resolver, profiles, cfg).Run(ctx, a)
+		require.NoError(t, err)
+		return stats
+	}
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	stats := run(Config{StatePath: statePath, KeepUnresolved: true})
+	assert.Equal(t, Stats{Channels: 1, Days: 2, Messages: 3, Replies: 1, Skipped: 2, Reactions: 1}, stats)
+
+	var msgs []loader.Message
+	require.NoError(t, loader.New(s, nil, nil).Walk(ctx, "general", func(m loader.Message) error {
+		msgs = append(msgs, m)
+		return nil
+	}))
+	require.Len(t, msgs, 3)
+	assert.Equal(t, "hi <all> @here", msgs[0].Msg)
+	assert.Equal(t, "identity-1", msgs[0].User.ID)
+	assert.Equal(t, time.Date(2023, 1, 1, 0, 0, 0, 100000, time.UTC), msgs[0].CreatedAt)
+	assert.Equal(t, "hey @Alice, see this (https://example.com)", msgs[1].Msg)
+	assert.Equal(t, "slack:U2", msgs[1].User.ID)
+	bob := profiles["slack:U2"]
+	bob.UpdatedAt = time.Time{}
+	assert.Equal(t, identity.Profile{ID: "slack:U2", Email: "bob@example.com", FirstName: "Bob", LastName: "Smith"}, bob)
+
+	// the saved progress skips the imported days
+	stats = run(Config{StatePath: statePath, KeepUnresolved: true})
+	assert.Equal(t, 2, stats.ResumedDays)
+	assert.Zero(t, stats.Messages)
+
+	// without the progress the messages are rewritten instead of duplicated
+	run(Config{KeepUnresolved: true})
+	var n int
+	require.NoError(t, loader.New(s, nil, nil).Walk(ctx, "general", func(loader.Message) error {
+		n++
+		return nil
+	}))
+	assert.Equal(t, 3, n)
+}
@@ -0,0 +1,407 @@
+package cqlstore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/outbox"
+	"github.com/demeero/chat/history/privacy"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/writer"
+	"github.com/gocql/gocql"
+)
+
+const selectMsgs = `SELECT msg_id, seq, pending_id, msg, user_id, user_email, user_first_name, user_last_name, created_at FROM chat.history`
+
+// Store is the Cassandra implementation of the writer.Store, loader.Store, retention.Store, privacy.Store and outbox.Store.
+type Store struct {
+	sess *gocql.Session
+
+	mu sync.Mutex
+	// outboxFrom is the first outbox bucket of every shard which may still have the pending entries.
+	// It caches the cursor persisted in the outbox_cursor table.
+	outboxFrom [outbox.Shards]time.Time
+}
+
+func New(sess *gocql.Session) *Store {
+	return &Store{sess: sess}
+}
+
+const (
+	// outboxBucket is the time span of the outbox partitions. The entries are never deleted, they expire by the table TTL,
+	// so the partitions the relay reads stay small and free of tombstones.
+	outboxBucket = time.Minute
+	// outboxTTL is the default TTL of the outbox table. The relay without the persisted cursor reads the buckets
+	// as far back as the TTL, so no entry is left unpublished before it expires.
+	outboxTTL = 7 * 24 * time.Hour
+)
+
+// outboxKey returns the partition key of the outbox entry, the bucket is derived from the entry ID time.
+func outboxKey(e outbox.Entry) (time.Time, int, gocql.UUID, error) {
+	id, err := gocql.ParseUUID(e.ID)
+	if err != nil {
+		return time.Time{}, 0, gocql.UUID{}, fmt.Errorf("invalid outbox entry id: %w", err)
+	}
+	return id.Time().UTC().Truncate(outboxBucket), e.Shard(), id, nil
+}
+
+// Insert inserts the message with the TTL of the record. Zero TTL removes the TTL of the rewritten message.
+// The legacy user data columns are cleared, so a rewritten message no longer keeps a copy of the user profile.
+// The event of the record is written to the outbox in the same logged batch, so either both or none are written.
+func (s *Store) Insert(ctx context.Context, rec writer.Record) error {
+	stmt := `INSERT INTO chat.history (chat_room_id, msg_id, seq, msg, user_id, user_email, user_first_name, user_last_name, created_at, pending_id) 
+				VALUES (?, ?, ?, ?, ?, '', '', '', ?, ?) USING TTL ?`
+	args := []any{rec.RoomChatID, rec.MsgID, rec.Seq, rec.Msg, rec.User.ID, rec.CreatedAt, rec.PendingID, ttlSeconds(rec.TTL)}
+	if rec.Event == nil {
+		return s.sess.Query(stmt, args...).
+			WithContext(ctx).
+			Exec()
+	}
+	b := s.sess.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	b.Query(stmt, args...)
+	e := rec.Event
+	bucket, shard, id, err := outboxKey(*e)
+	if err != nil {
+		return err
+	}
+	b.Query(`INSERT INTO chat.outbox_by_minute (bucket, shard, id, routing_key, topic, payload, metadata, published)
+				VALUES (?, ?, ?, ?, ?, ?, ?, false)`,
+		bucket, shard, id, e.Key, e.Topic, e.Payload, e.Metadata)
+	return s.sess.ExecuteBatch(b)
+}
+
+// Rewrite rewrites every column of the message with a lightweight transaction, so the message deleted meanwhile is not written again.
+// The legacy user data columns are cleared like in Insert.
+func (s *Store) Rewrite(ctx context.Context, rec writer.Record) error {
+	applied, err := s.sess.Query(`UPDATE chat.history USING TTL ?
+				SET seq = ?, msg = ?, user_id = ?, user_email = '', user_first_name = '', user_last_name = '', pending_id = ?
+				WHERE chat_room_id = ? AND created_at = ? AND msg_id = ? IF EXISTS`,
+		ttlSeconds(rec.TTL), rec.Seq, rec.Msg, rec.User.ID, rec.PendingID, rec.RoomChatID, rec.CreatedAt, rec.MsgID).
+		WithContext(ctx).
+		MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("failed rewrite message: %w", err)
+	}
+	if !applied {
+		slogbrick.FromCtx(ctx).Debug("skip rewrite of deleted message",
+			slog.String("room_chat_id", rec.RoomChatID), slog.String("msg_id", rec.MsgID))
+	}
+	return nil
+}
+
+// PendingOutbox loads the entries shard by shard and bucket by bucket, so the entries of the same key keep their order.
+// Every shard is read from the first bucket which may still have the pending entries, the settled buckets are skipped.
+func (s *Store) PendingOutbox(ctx context.Context, before time.Time, limit int) ([]outbox.Entry, error) {
+	var entries []outbox.Entry
+	last := before.UTC().Truncate(outboxBucket)
+	for shard := 0; shard < outbox.Shards && len(entries) < limit; shard++ {
+		from, err := s.outboxCursor(ctx, shard, before)
+		if err != nil {
+			return nil, err
+		}
+		settled, next := true, from
+		for bucket := from; !bucket.After(last) && len(entries) < limit; bucket = bucket.Add(outboxBucket) {
+			pending, err := s.pendingOutbox(ctx, bucket, shard, before, limit-len(entries))
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, pending...)
+			// the bucket is settled when it has no pending entries and is old enough not to be written to,
+			// one more bucket is left for the writers with the clock behind
+			settled = settled && len(pending) == 0 && bucket.Add(2*outboxBucket).Before(before)
+			if settled {
+				next = bucket.Add(outboxBucket)
+			}
+		}
+		if err := s.setOutboxCursor(ctx, shard, next); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func (s *Store) pendingOutbox(ctx context.Context, bucket time.Time, shard int, before time.Time, limit int) ([]outbox.Entry, error) {
+	iter := s.sess.Query(`SELECT id, routing_key, topic, payload, metadata, published FROM chat.outbox_by_minute
+				WHERE bucket = ? AND shard = ? AND id < ?`,
+		bucket, shard, gocql.MaxTimeUUID(before)).
+		WithContext(ctx).
+		Iter()
+	var (
+		entries   []outbox.Entry
+		e         outbox.Entry
+		id        gocql.UUID
+		published bool
+	)
+	for len(entries) < limit && iter.Scan(&id, &e.Key, &e.Topic, &e.Payload, &e.Metadata, &published) {
+		if !published {
+			e.ID = id.String()
+			e.CreatedAt = id.Time().UTC()
+			entries = append(entries, e)
+		}
+		e = outbox.Entry{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed scan outbox entries: %w", err)
+	}
+	return entries, nil
+}
+
+// outboxCursor returns the first bucket of the shard which may still have the pending entries.
+// The relay started for the first time reads the shard from the oldest bucket which is not expired yet.
+func (s *Store) outboxCursor(ctx context.Context, shard int, before time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.outboxFrom[shard].IsZero() {
+		return s.outboxFrom[shard], nil
+	}
+	var from time.Time
+	err := s.sess.Query(`SELECT bucket FROM chat.outbox_cursor WHERE shard = ?`, shard).
+		WithContext(ctx).
+		Scan(&from)
+	switch {
+	case errors.Is(err, gocql.ErrNotFound):
+		from = before.UTC().Add(-outboxTTL).Truncate(outboxBucket)
+	case err != nil:
+		return time.Time{}, fmt.Errorf("failed load outbox cursor: %w", err)
+	}
+	s.outboxFrom[shard] = from.UTC()
+	return s.outboxFrom[shard], nil
+}
+
+// setOutboxCursor moves the cursor of the shard forward and persists it, so the restarted relay resumes from it.
+func (s *Store) setOutboxCursor(ctx context.Context, shard int, from time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !from.After(s.outboxFrom[shard]) {
+		return nil
+	}
+	if err := s.sess.Query(`UPDATE chat.outbox_cursor SET bucket = ? WHERE shard = ?`, from, shard).
+		WithContext(ctx).
+		Exec(); err != nil {
+		return fmt.Errorf("failed save outbox cursor: %w", err)
+	}
+	s.outboxFrom[shard] = from
+	return nil
+}
+
+// OutboxDone marks the published entries instead of deleting them, so the outbox partitions get no tombstones.
+func (s *Store) OutboxDone(ctx context.Context, entries ...outbox.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	b := s.sess.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+	for _, e := range entries {
+		bucket, shard, id, err := outboxKey(e)
+		if err != nil {
+			return err
+		}
+		b.Query(`UPDATE chat.outbox_by_minute SET published = true WHERE bucket = ? AND shard = ? AND id = ?`, bucket, shard, id)
+	}
+	return s.sess.ExecuteBatch(b)
+}
+
+// ttlSeconds rounds the TTL up to seconds, so a short positive TTL does not turn into the zero one which never expires.
+func ttlSeconds(ttl time.Duration) int {
+	if ttl <= 0 {
+		return 0
+	}
+	return int((ttl + time.Second - 1) / time.Second)
+}
+
+// DeleteBefore deletes the messages of the chat room created before the time with a single range delete.
+func (s *Store) DeleteBefore(ctx context.Context, roomChatID string, before time.Time) error {
+	return s.sess.Query(`DELETE FROM chat.history WHERE chat_room_id = ? AND created_at < ?`, roomChatID, before).
+		WithContext(ctx).
+		Exec()
+}
+
+func (s *Store) GetRetention(ctx context.Context, roomChatID string) (retention.Policy, error) {
+	p := retention.Policy{RoomChatID: roomChatID}
+	err := s.sess.Query(`SELECT retention_days, updated_at, applied_at FROM chat.room_retention WHERE chat_room_id = ?`, roomChatID).
+		WithContext(ctx).
+		Scan(&p.Days, &p.UpdatedAt, &p.AppliedAt)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return retention.Policy{RoomChatID: roomChatID}, nil
+	}
+	if err != nil {
+		return retention.Policy{}, fmt.Errorf("failed load room retention: %w", err)
+	}
+	return p, nil
+}
+
+func (s *Store) SetRetention(ctx context.Context, p retention.Policy) error {
+	return s.sess.Query(`UPDATE chat.room_retention SET retention_days = ?, updated_at = ? WHERE chat_room_id = ?`,
+		p.Days, p.UpdatedAt, p.RoomChatID).
+		WithContext(ctx).
+		Exec()
+}
+
+func (s *Store) ListRetentions(ctx context.Context) ([]retention.Policy, error) {
+	iter := s.sess.Query(`SELECT chat_room_id, retention_days, updated_at, applied_at FROM chat.room_retention`).
+		WithContext(ctx).
+		Iter()
+	var (
+		policies []retention.Policy
+		p        retention.Policy
+	)
+	for iter.Scan(&p.RoomChatID, &p.Days, &p.UpdatedAt, &p.AppliedAt) {
+		policies = append(policies, p)
+		p = retention.Policy{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed list room retentions: %w", err)
+	}
+	return policies, nil
+}
+
+func (s *Store) MarkRetentionApplied(ctx context.Context, roomChatID string, appliedAt time.Time) error {
+	return s.sess.Query(`UPDATE chat.room_retention SET applied_at = ? WHERE chat_room_id = ?`, appliedAt, roomChatID).
+		WithContext(ctx).
+		Exec()
+}
+
+func (s *Store) List(ctx context.Context, roomChatID string, cursor *loader.Cursor, limit int) ([]loader.Message, error) {
+	var q *gocql.Query
+	if cursor == nil {
+		q = s.sess.Query(selectMsgs+` WHERE chat_room_id = ? ORDER BY created_at DESC, msg_id DESC LIMIT ?`,
+			roomChatID, limit)
+	} else {
+		q = s.sess.Query(selectMsgs+` WHERE chat_room_id = ? AND (created_at, msg_id) < (?, ?) ORDER BY created_at DESC, msg_id DESC LIMIT ?`,
+			roomChatID, cursor.CreatedAt, cursor.MsgID, limit)
+	}
+	return scanMsgs(q.WithContext(ctx).Iter())
+}
+
+func (s *Store) Scan(ctx context.Context, roomChatID string, cursor *loader.Cursor, limit int) ([]loader.Message, error) {
+	var q *gocql.Query
+	if cursor == nil {
+		q = s.sess.Query(selectMsgs+` WHERE chat_room_id = ? ORDER BY created_at ASC, msg_id ASC LIMIT ?`,
+			roomChatID, limit)
+	} else {
+		q = s.sess.Query(selectMsgs+` WHERE chat_room_id = ? AND (created_at, msg_id) > (?, ?) ORDER BY created_at ASC, msg_id ASC LIMIT ?`,
+			roomChatID, cursor.CreatedAt, cursor.MsgID, limit)
+	}
+	return scanMsgs(q.WithContext(ctx).Iter())
+}
+
+func (s *Store) Get(ctx context.Context, roomChatID, msgID string) (loader.Message, error) {
+	q := s.sess.Query(selectMsgs+` WHERE chat_room_id = ? AND msg_id = ? ALLOW FILTERING`, roomChatID, msgID)
+	return scanOne(q.WithContext(ctx).Iter())
+}
+
+func (s *Store) GetCreated(ctx context.Context, roomChatID string, createdAt time.Time, msgID string) (loader.Message, error) {
+	q := s.sess.Query(selectMsgs+` WHERE chat_room_id = ? AND created_at = ? AND msg_id = ?`, roomChatID, createdAt, msgID)
+	return scanOne(q.WithContext(ctx).Iter())
+}
+
+func (s *Store) GetByPendingID(ctx context.Context, roomChatID, pendingID string) (loader.Message, error) {
+	q := s.sess.Query(selectMsgs+` WHERE chat_room_id = ? AND pending_id = ? LIMIT 1 ALLOW FILTERING`, roomChatID, pendingID)
+	return scanOne(q.WithContext(ctx).Iter())
+}
+
+// UserMessages pages through the messages of the user with the history_user_id_idx secondary index.
+// The page token is the Cassandra paging state.
+func (s *Store) UserMessages(ctx context.Context, userID, pageToken string, limit int) ([]privacy.Message, string, error) {
+	state, err := base64.RawURLEncoding.DecodeString(pageToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: invalid page token", errbrick.ErrInvalidData)
+	}
+	iter := s.sess.Query(`SELECT chat_room_id, msg_id, seq, pending_id, msg, user_id, user_email, user_first_name, user_last_name, created_at
+			FROM chat.history WHERE user_id = ?`, userID).
+		WithContext(ctx).
+		PageSize(limit).
+		PageState(state).
+		Iter()
+	var (
+		msgs  []privacy.Message
+		msgID gocql.UUID
+	)
+	// the iterator fetches the next page when the current one is scanned, so stop at the page boundary
+	for n := iter.NumRows(); n > 0; n-- {
+		var m privacy.Message
+		if !iter.Scan(&m.RoomChatID, &msgID, &m.Seq, &m.PendingID, &m.Msg, &m.User.ID, &m.User.Email, &m.User.FirstName, &m.User.LastName, &m.CreatedAt) {
+			break
+		}
+		m.ID = msgID.String()
+		m.CreatedAt = m.CreatedAt.UTC()
+		msgs = append(msgs, m)
+	}
+	next := base64.RawURLEncoding.EncodeToString(iter.PageState())
+	if err := iter.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed scan user messages: %w", err)
+	}
+	return msgs, next, nil
+}
+
+func (s *Store) DeleteMessage(ctx context.Context, roomChatID, msgID string, createdAt time.Time) error {
+	return s.sess.Query(`DELETE FROM chat.history WHERE chat_room_id = ? AND created_at = ? AND msg_id = ?`, roomChatID, createdAt, msgID).
+		WithContext(ctx).
+		Exec()
+}
+
+// MaxSeq aggregates the partition of the room, so it's meant for seeding the lost sequence counters only.
+func (s *Store) MaxSeq(ctx context.Context, roomChatID string) (int64, error) {
+	var seq *int64
+	if err := s.sess.Query(`SELECT MAX(seq) FROM chat.history WHERE chat_room_id = ?`, roomChatID).
+		WithContext(ctx).
+		Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed query max seq: %w", err)
+	}
+	if seq == nil {
+		return 0, nil
+	}
+	return *seq, nil
+}
+
+// Rooms scans the partition keys of the history, so it reads the whole table and is meant for the maintenance tools only.
+func (s *Store) Rooms(ctx context.Context) ([]string, error) {
+	iter := s.sess.Query(`SELECT DISTINCT chat_room_id FROM chat.history`).WithContext(ctx).Iter()
+	var (
+		rooms []string
+		room  string
+	)
+	for iter.Scan(&room) {
+		rooms = append(rooms, room)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed scan rooms: %w", err)
+	}
+	return rooms, nil
+}
+
+func scanOne(iter *gocql.Iter) (loader.Message, error) {
+	msgs, err := scanMsgs(iter)
+	if err != nil {
+		return loader.Message{}, err
+	}
+	if len(msgs) == 0 {
+		return loader.Message{}, fmt.Errorf("message %w", errbrick.ErrNotFound)
+	}
+	return msgs[0], nil
+}
+
+func scanMsgs(iter *gocql.Iter) ([]loader.Message, error) {
+	var (
+		msgs  []loader.Message
+		m     loader.Message
+		msgID gocql.UUID
+	)
+	for iter.Scan(&msgID, &m.Seq, &m.PendingID, &m.Msg, &m.User.ID, &m.User.Email, &m.User.FirstName, &m.User.LastName, &m.CreatedAt) {
+		m.ID = msgID.String()
+		m.CreatedAt = m.CreatedAt.UTC()
+		msgs = append(msgs, m)
+		m = loader.Message{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed scan messages: %w", err)
+	}
+	return msgs, nil
+}
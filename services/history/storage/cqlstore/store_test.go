@@ -0,0 +1,27 @@
+package cqlstore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/demeero/chat/history/migration"
+	"github.com/demeero/chat/history/storage/storagetest"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCassandraConformance(t *testing.T) {
+	host := os.Getenv("HISTORY_TEST_CASSANDRA_HOST")
+	if host == "" {
+		t.Skip("HISTORY_TEST_CASSANDRA_HOST is not set")
+	}
+	sess, err := gocql.NewCluster(host).CreateSession()
+	require.NoError(t, err)
+	defer sess.Close()
+	m, err := migration.New(sess, "{'class': 'SimpleStrategy', 'replication_factor': 1}")
+	require.NoError(t, err)
+	_, err = m.Up(context.Background(), false)
+	require.NoError(t, err)
+	storagetest.Run(t, New(sess))
+}
@@ -0,0 +1,563 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/outbox"
+	"github.com/demeero/chat/history/privacy"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/writer"
+	"github.com/gocql/gocql"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers pgx driver
+	_ "modernc.org/sqlite"             // registers sqlite driver
+)
+
+// Dialect is the SQL dialect of the database.
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+func (d Dialect) driver() (string, error) {
+	switch d {
+	case Postgres:
+		return "pgx", nil
+	case SQLite:
+		return "sqlite", nil
+	default:
+		return "", fmt.Errorf("unsupported sql dialect %q", d)
+	}
+}
+
+// schema is compatible with both Postgres and SQLite.
+// created_at is stored as unix nanoseconds to get the same ordering and precision in every dialect.
+// The messages of the same created_at are ordered by sort_key, which sorts like the Cassandra timeuuid msg_id.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS history (
+		chat_room_id    TEXT   NOT NULL,
+		msg_id          TEXT   NOT NULL,
+		created_at      BIGINT NOT NULL,
+		msg             TEXT   NOT NULL,
+		pending_id      TEXT   NOT NULL DEFAULT '',
+		user_id         TEXT   NOT NULL,
+		user_email      TEXT   NOT NULL DEFAULT '',
+		user_first_name TEXT   NOT NULL DEFAULT '',
+		user_last_name  TEXT   NOT NULL DEFAULT '',
+		seq             BIGINT NOT NULL DEFAULT 0,
+		sort_key        TEXT   NOT NULL DEFAULT '',
+		PRIMARY KEY (chat_room_id, msg_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS history_room_pending_id_idx ON history (chat_room_id, pending_id)`,
+	`CREATE INDEX IF NOT EXISTS history_user_id_idx ON history (user_id, chat_room_id, msg_id)`,
+	`CREATE TABLE IF NOT EXISTS room_retention (
+		chat_room_id   TEXT    NOT NULL PRIMARY KEY,
+		retention_days INTEGER NOT NULL,
+		updated_at     BIGINT  NOT NULL,
+		applied_at     BIGINT  NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS outbox (
+		id          TEXT   NOT NULL PRIMARY KEY,
+		created_at  BIGINT NOT NULL,
+		routing_key TEXT   NOT NULL,
+		topic       TEXT   NOT NULL,
+		payload     TEXT   NOT NULL,
+		metadata    TEXT   NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS outbox_created_at_idx ON outbox (created_at, id)`,
+}
+
+// addedColumns are the columns added to the tables created by the earlier versions of the schema.
+// The backfill, if any, fills the column of the existing rows once it's added.
+var addedColumns = []struct {
+	table, column, definition string
+	backfill                  func(*Store, context.Context) error
+}{
+	{table: "history", column: "seq", definition: "BIGINT NOT NULL DEFAULT 0"},
+	{table: "history", column: "sort_key", definition: "TEXT NOT NULL DEFAULT ''", backfill: (*Store).backfillSortKeys},
+}
+
+// indexes are created after the added columns, so they may cover them.
+var indexes = []string{
+	`DROP INDEX IF EXISTS history_room_created_at_idx`,
+	`CREATE INDEX IF NOT EXISTS history_room_created_at_sort_key_idx ON history (chat_room_id, created_at DESC, sort_key DESC)`,
+}
+
+const selectMsgs = `SELECT msg_id, seq, pending_id, msg, user_id, user_email, user_first_name, user_last_name, created_at FROM history`
+
+// Store is the SQL implementation of the writer.Store, loader.Store, retention.Store, privacy.Store and outbox.Store.
+// SQL databases have no TTLs, so the messages out of the room retention are deleted by the retention.Sweeper.
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// Open opens the database of the given dialect and creates the schema if it does not exist.
+func Open(ctx context.Context, dialect Dialect, dsn string) (*Store, error) {
+	driver, err := dialect.driver()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed open %s db: %w", dialect, err)
+	}
+	if dialect == SQLite {
+		// SQLite allows a single writer, so serialize access to avoid SQLITE_BUSY errors.
+		db.SetMaxOpenConns(1)
+	}
+	s := &Store{db: db, dialect: dialect}
+	if err := s.init(ctx); err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+	return s, nil
+}
+
+func (s *Store) init(ctx context.Context) error {
+	for _, stmt := range schema {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed create schema: %w", err)
+		}
+	}
+	for _, c := range addedColumns {
+		// neither dialect has a portable ADD COLUMN IF NOT EXISTS, so probe the column first
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("SELECT %s FROM %s LIMIT 0", c.column, c.table)); err == nil {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed add column %s.%s: %w", c.table, c.column, err)
+		}
+		if c.backfill == nil {
+			continue
+		}
+		if err := c.backfill(s, ctx); err != nil {
+			return fmt.Errorf("failed backfill column %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	for _, stmt := range indexes {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed create index: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) backfillSortKeys(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT chat_room_id, msg_id FROM history`)
+	if err != nil {
+		return fmt.Errorf("failed query messages: %w", err)
+	}
+	var keys [][2]string
+	for rows.Next() {
+		var roomChatID, msgID string
+		if err := rows.Scan(&roomChatID, &msgID); err != nil {
+			return errors.Join(fmt.Errorf("failed scan message: %w", err), rows.Close())
+		}
+		keys = append(keys, [2]string{roomChatID, msgID})
+	}
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+		return fmt.Errorf("failed iterate messages: %w", err)
+	}
+	for _, k := range keys {
+		key, err := sortKey(k[1])
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.ExecContext(ctx, s.rebind(`UPDATE history SET sort_key = ? WHERE chat_room_id = ? AND msg_id = ?`),
+			key, k[0], k[1]); err != nil {
+			return fmt.Errorf("failed update sort key: %w", err)
+		}
+	}
+	return nil
+}
+
+// sortKey returns the key of the TimeUUID message ID which sorts as text the way Cassandra sorts the timeuuid:
+// by the time and then by the rest of the bytes compared as the signed ones.
+func sortKey(msgID string) (string, error) {
+	id, err := gocql.ParseUUID(msgID)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid msg id: %s", errbrick.ErrInvalidData, err)
+	}
+	var key [16]byte
+	binary.BigEndian.PutUint64(key[:8], uint64(id.Timestamp()))
+	for i, b := range id[8:] {
+		// flipping the sign bit turns the signed byte order into the unsigned one
+		key[8+i] = b ^ 0x80
+	}
+	return hex.EncodeToString(key[:]), nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Insert upserts the message, so writing the same message again is idempotent like in Cassandra.
+// The TTL of the record is ignored. The legacy user data columns are cleared like in Cassandra.
+// The event of the record is written to the outbox in the same transaction.
+func (s *Store) Insert(ctx context.Context, rec writer.Record) error {
+	if rec.Event == nil {
+		return s.insert(ctx, s.db, rec)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed begin tx: %w", err)
+	}
+	if err := s.insert(ctx, tx, rec); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	if err := s.insertOutbox(ctx, tx, *rec.Event); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed commit tx: %w", err)
+	}
+	return nil
+}
+
+// Rewrite updates the message, so the message deleted meanwhile is not written again. The TTL of the record is ignored.
+func (s *Store) Rewrite(ctx context.Context, rec writer.Record) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`UPDATE history SET seq = ?, msg = ?, user_id = ?, user_email = '', user_first_name = '',
+		user_last_name = '', pending_id = ? WHERE chat_room_id = ? AND msg_id = ?`),
+		rec.Seq, rec.Msg, rec.User.ID, rec.PendingID, rec.RoomChatID, rec.MsgID)
+	if err != nil {
+		return fmt.Errorf("failed rewrite message: %w", err)
+	}
+	return nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s *Store) insert(ctx context.Context, db execer, rec writer.Record) error {
+	key, err := sortKey(rec.MsgID)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, s.rebind(`INSERT INTO history
+		(chat_room_id, msg_id, seq, msg, user_id, user_email, user_first_name, user_last_name, created_at, pending_id, sort_key)
+		VALUES (?, ?, ?, ?, ?, '', '', '', ?, ?, ?)
+		ON CONFLICT (chat_room_id, msg_id) DO UPDATE SET
+			seq = excluded.seq, msg = excluded.msg, user_id = excluded.user_id, user_email = excluded.user_email,
+			user_first_name = excluded.user_first_name, user_last_name = excluded.user_last_name,
+			created_at = excluded.created_at, pending_id = excluded.pending_id`),
+		rec.RoomChatID, rec.MsgID, rec.Seq, rec.Msg, rec.User.ID, rec.CreatedAt.UnixNano(), rec.PendingID, key)
+	return err
+}
+
+func (s *Store) insertOutbox(ctx context.Context, db execer, e outbox.Entry) error {
+	md, err := json.Marshal(e.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed encode outbox entry metadata: %w", err)
+	}
+	_, err = db.ExecContext(ctx, s.rebind(`INSERT INTO outbox (id, created_at, routing_key, topic, payload, metadata) VALUES (?, ?, ?, ?, ?, ?)`),
+		e.ID, e.CreatedAt.UnixNano(), e.Key, e.Topic, string(e.Payload), string(md))
+	if err != nil {
+		return fmt.Errorf("failed insert outbox entry: %w", err)
+	}
+	return nil
+}
+
+// PendingOutbox loads the entries in the order they were written.
+func (s *Store) PendingOutbox(ctx context.Context, before time.Time, limit int) ([]outbox.Entry, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, created_at, routing_key, topic, payload, metadata FROM outbox
+		WHERE created_at < ? ORDER BY created_at, id LIMIT ?`), before.UnixNano(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed query outbox entries: %w", err)
+	}
+	defer rows.Close()
+	var entries []outbox.Entry
+	for rows.Next() {
+		var (
+			e             outbox.Entry
+			createdAt     int64
+			payload, meta string
+		)
+		if err := rows.Scan(&e.ID, &createdAt, &e.Key, &e.Topic, &payload, &meta); err != nil {
+			return nil, fmt.Errorf("failed scan outbox entry: %w", err)
+		}
+		if err := json.Unmarshal([]byte(meta), &e.Metadata); err != nil {
+			return nil, fmt.Errorf("failed decode outbox entry metadata: %w", err)
+		}
+		e.CreatedAt = time.Unix(0, createdAt).UTC()
+		e.Payload = []byte(payload)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed iterate outbox entries: %w", err)
+	}
+	return entries, nil
+}
+
+// OutboxDone deletes the published entries from the outbox.
+func (s *Store) OutboxDone(ctx context.Context, entries ...outbox.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(entries))
+	for _, e := range entries {
+		args = append(args, e.ID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(entries)), ", ")
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM outbox WHERE id IN (`+placeholders+`)`), args...)
+	return err
+}
+
+func (s *Store) List(ctx context.Context, roomChatID string, cursor *loader.Cursor, limit int) ([]loader.Message, error) {
+	if cursor == nil {
+		return s.query(ctx, selectMsgs+` WHERE chat_room_id = ? ORDER BY created_at DESC, sort_key DESC LIMIT ?`,
+			roomChatID, limit)
+	}
+	key, err := sortKey(cursor.MsgID)
+	if err != nil {
+		return nil, err
+	}
+	createdAt := cursor.CreatedAt.UnixNano()
+	return s.query(ctx, selectMsgs+` WHERE chat_room_id = ? AND (created_at < ? OR (created_at = ? AND sort_key < ?))
+		ORDER BY created_at DESC, sort_key DESC LIMIT ?`,
+		roomChatID, createdAt, createdAt, key, limit)
+}
+
+func (s *Store) Scan(ctx context.Context, roomChatID string, cursor *loader.Cursor, limit int) ([]loader.Message, error) {
+	if cursor == nil {
+		return s.query(ctx, selectMsgs+` WHERE chat_room_id = ? ORDER BY created_at ASC, sort_key ASC LIMIT ?`,
+			roomChatID, limit)
+	}
+	key, err := sortKey(cursor.MsgID)
+	if err != nil {
+		return nil, err
+	}
+	createdAt := cursor.CreatedAt.UnixNano()
+	return s.query(ctx, selectMsgs+` WHERE chat_room_id = ? AND (created_at > ? OR (created_at = ? AND sort_key > ?))
+		ORDER BY created_at ASC, sort_key ASC LIMIT ?`,
+		roomChatID, createdAt, createdAt, key, limit)
+}
+
+func (s *Store) Get(ctx context.Context, roomChatID, msgID string) (loader.Message, error) {
+	msgs, err := s.query(ctx, selectMsgs+` WHERE chat_room_id = ? AND msg_id = ?`, roomChatID, msgID)
+	return first(msgs, err)
+}
+
+// GetCreated looks the message up by the ID only, the primary key of the SQL history is the room and the message ID.
+func (s *Store) GetCreated(ctx context.Context, roomChatID string, _ time.Time, msgID string) (loader.Message, error) {
+	return s.Get(ctx, roomChatID, msgID)
+}
+
+func (s *Store) GetByPendingID(ctx context.Context, roomChatID, pendingID string) (loader.Message, error) {
+	msgs, err := s.query(ctx, selectMsgs+` WHERE chat_room_id = ? AND pending_id = ? ORDER BY created_at DESC LIMIT 1`,
+		roomChatID, pendingID)
+	return first(msgs, err)
+}
+
+func (s *Store) DeleteBefore(ctx context.Context, roomChatID string, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM history WHERE chat_room_id = ? AND created_at < ?`),
+		roomChatID, before.UnixNano())
+	return err
+}
+
+func (s *Store) GetRetention(ctx context.Context, roomChatID string) (retention.Policy, error) {
+	policies, err := s.queryRetentions(ctx, ` WHERE chat_room_id = ?`, roomChatID)
+	if err != nil {
+		return retention.Policy{}, err
+	}
+	if len(policies) == 0 {
+		return retention.Policy{RoomChatID: roomChatID}, nil
+	}
+	return policies[0], nil
+}
+
+func (s *Store) SetRetention(ctx context.Context, p retention.Policy) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO room_retention (chat_room_id, retention_days, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (chat_room_id) DO UPDATE SET retention_days = excluded.retention_days, updated_at = excluded.updated_at`),
+		p.RoomChatID, p.Days, p.UpdatedAt.UnixNano())
+	return err
+}
+
+func (s *Store) ListRetentions(ctx context.Context) ([]retention.Policy, error) {
+	return s.queryRetentions(ctx, "")
+}
+
+func (s *Store) MarkRetentionApplied(ctx context.Context, roomChatID string, appliedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`UPDATE room_retention SET applied_at = ? WHERE chat_room_id = ?`),
+		appliedAt.UnixNano(), roomChatID)
+	return err
+}
+
+func (s *Store) queryRetentions(ctx context.Context, where string, args ...any) ([]retention.Policy, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT chat_room_id, retention_days, updated_at, applied_at FROM room_retention`+where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed query room retentions: %w", err)
+	}
+	defer rows.Close()
+	var policies []retention.Policy
+	for rows.Next() {
+		var (
+			p                    retention.Policy
+			updatedAt, appliedAt int64
+		)
+		if err := rows.Scan(&p.RoomChatID, &p.Days, &updatedAt, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed scan room retention: %w", err)
+		}
+		p.UpdatedAt = time.Unix(0, updatedAt).UTC()
+		if appliedAt != 0 {
+			p.AppliedAt = time.Unix(0, appliedAt).UTC()
+		}
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed iterate room retentions: %w", err)
+	}
+	return policies, nil
+}
+
+// UserMessages pages through the messages of the user ordered by the room and the message ID.
+// The page token is the key of the last message of the previous page.
+func (s *Store) UserMessages(ctx context.Context, userID, pageToken string, limit int) ([]privacy.Message, string, error) {
+	where, args := ` WHERE user_id = ?`, []any{userID}
+	if pageToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: invalid page token", errbrick.ErrInvalidData)
+		}
+		roomChatID, msgID, ok := strings.Cut(string(b), "/")
+		if !ok {
+			return nil, "", fmt.Errorf("%w: invalid page token", errbrick.ErrInvalidData)
+		}
+		where += ` AND (chat_room_id > ? OR (chat_room_id = ? AND msg_id > ?))`
+		args = append(args, roomChatID, roomChatID, msgID)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT chat_room_id, msg_id, seq, pending_id, msg, user_id, user_email, user_first_name,
+		user_last_name, created_at FROM history`+where+` ORDER BY chat_room_id, msg_id LIMIT ?`), append(args, limit)...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed query user messages: %w", err)
+	}
+	defer rows.Close()
+	var msgs []privacy.Message
+	for rows.Next() {
+		var (
+			m         privacy.Message
+			createdAt int64
+		)
+		err := rows.Scan(&m.RoomChatID, &m.ID, &m.Seq, &m.PendingID, &m.Msg, &m.User.ID, &m.User.Email, &m.User.FirstName, &m.User.LastName, &createdAt)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed scan user message: %w", err)
+		}
+		m.CreatedAt = time.Unix(0, createdAt).UTC()
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed iterate user messages: %w", err)
+	}
+	if len(msgs) < limit {
+		return msgs, "", nil
+	}
+	last := msgs[len(msgs)-1]
+	return msgs, base64.RawURLEncoding.EncodeToString([]byte(last.RoomChatID + "/" + last.ID)), nil
+}
+
+func (s *Store) DeleteMessage(ctx context.Context, roomChatID, msgID string, _ time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM history WHERE chat_room_id = ? AND msg_id = ?`), roomChatID, msgID)
+	return err
+}
+
+func (s *Store) MaxSeq(ctx context.Context, roomChatID string) (int64, error) {
+	var seq sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, s.rebind(`SELECT MAX(seq) FROM history WHERE chat_room_id = ?`), roomChatID).
+		Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed query max seq: %w", err)
+	}
+	return seq.Int64, nil
+}
+
+// Rooms returns the chat rooms ordered by the ID.
+func (s *Store) Rooms(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT chat_room_id FROM history ORDER BY chat_room_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed query rooms: %w", err)
+	}
+	defer rows.Close()
+	var rooms []string
+	for rows.Next() {
+		var room string
+		if err := rows.Scan(&room); err != nil {
+			return nil, fmt.Errorf("failed scan room: %w", err)
+		}
+		rooms = append(rooms, room)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed iterate rooms: %w", err)
+	}
+	return rooms, nil
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...any) ([]loader.Message, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed query messages: %w", err)
+	}
+	defer rows.Close()
+	var msgs []loader.Message
+	for rows.Next() {
+		var (
+			m         loader.Message
+			createdAt int64
+		)
+		err := rows.Scan(&m.ID, &m.Seq, &m.PendingID, &m.Msg, &m.User.ID, &m.User.Email, &m.User.FirstName, &m.User.LastName, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed scan message: %w", err)
+		}
+		m.CreatedAt = time.Unix(0, createdAt).UTC()
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed iterate messages: %w", err)
+	}
+	return msgs, nil
+}
+
+// rebind replaces the ? placeholders with the positional ones for Postgres.
+func (s *Store) rebind(query string) string {
+	if s.dialect != Postgres {
+		return query
+	}
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+func first(msgs []loader.Message, err error) (loader.Message, error) {
+	if err != nil {
+		return loader.Message{}, err
+	}
+	if len(msgs) == 0 {
+		return loader.Message{}, fmt.Errorf("message %w", errbrick.ErrNotFound)
+	}
+	return msgs[0], nil
+}
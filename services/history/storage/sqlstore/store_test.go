@@ -0,0 +1,29 @@
+package sqlstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/demeero/chat/history/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	s, err := Open(context.Background(), SQLite, filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer s.Close()
+	storagetest.Run(t, s)
+}
+
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv("HISTORY_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("HISTORY_TEST_POSTGRES_DSN is not set")
+	}
+	s, err := Open(context.Background(), Postgres, dsn)
+	require.NoError(t, err)
+	defer s.Close()
+	storagetest.Run(t, s)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/demeero/bricks/configbrick"
+	"github.com/demeero/bricks/cqlbrick"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/outbox"
+	"github.com/demeero/chat/history/privacy"
+	"github.com/demeero/chat/history/replay"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/sequence"
+	"github.com/demeero/chat/history/storage/cqlstore"
+	"github.com/demeero/chat/history/storage/sqlstore"
+	"github.com/gocql/gocql"
+)
+
+const (
+	BackendCassandra = "cassandra"
+	BackendPostgres  = "postgres"
+	BackendSQLite    = "sqlite"
+)
+
+// Config is the history storage configuration.
+type Config struct {
+	// Backend is one of cassandra, postgres or sqlite.
+	Backend string `default:"cassandra" json:"backend"`
+	// DSN is the data source name of the SQL backends.
+	DSN string `json:"-"`
+}
+
+// Store is the storage of the chat history.
+type Store interface {
+	retention.MessageStore
+	retention.Store
+	privacy.Store
+	loader.Store
+	outbox.Store
+	replay.Store
+	sequence.Store
+}
+
+// Open opens the store of the configured backend.
+// The returned function releases the resources of the store.
+func Open(ctx context.Context, cfg Config, cassandraCfg configbrick.Cassandra) (Store, func(), error) {
+	switch cfg.Backend {
+	case BackendCassandra:
+		sess, err := NewCassandraSession(cassandraCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cqlstore.New(sess), sess.Close, nil
+	case BackendPostgres, BackendSQLite:
+		s, err := sqlstore.Open(ctx, sqlstore.Dialect(cfg.Backend), cfg.DSN)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed open sql store: %w", err)
+		}
+		return s, func() { _ = s.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported storage backend %q", cfg.Backend)
+	}
+}
+
+// NewCassandraSession creates the instrumented Cassandra session.
+func NewCassandraSession(cfg configbrick.Cassandra) (*gocql.Session, error) {
+	cluster := gocql.NewCluster(cfg.Host)
+	if cfg.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: cfg.Username,
+			Password: cfg.Password,
+		}
+	}
+	cluster.Keyspace = cfg.Keyspace
+	cqlMeterObsrvr, err := cqlbrick.NewOTELMeterQueryObserver(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed create cql meter observer: %w", err)
+	}
+	cluster.QueryObserver = cqlbrick.NewObserverChain(
+		cqlbrick.SlogLogQueryObserver{Disabled: !cfg.Log},
+		cqlbrick.NewOTELTraceQueryObserver(false),
+		cqlMeterObsrvr)
+	sess, err := cluster.CreateSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed create cassandra session: %w", err)
+	}
+	return sess, nil
+}
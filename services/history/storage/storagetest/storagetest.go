@@ -0,0 +1,454 @@
+// Package storagetest provides the conformance tests every history storage backend must pass.
+package storagetest
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/outbox"
+	"github.com/demeero/chat/history/privacy"
+	"github.com/demeero/chat/history/replay"
+	"github.com/demeero/chat/history/retention"
+	"github.com/demeero/chat/history/sequence"
+	"github.com/demeero/chat/history/writer"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Store is implemented by the storage backends.
+type Store interface {
+	retention.MessageStore
+	retention.Store
+	privacy.Store
+	loader.Store
+	outbox.Store
+	replay.Store
+	sequence.Store
+}
+
+// Run runs the conformance tests against the store.
+// Every test uses its own chat room, so the store may be shared between tests and runs.
+func Run(t *testing.T, s Store) {
+	t.Helper()
+	t.Run("Load", func(t *testing.T) { testLoad(t, s) })
+	t.Run("Walk", func(t *testing.T) { testWalk(t, s) })
+	t.Run("Order", func(t *testing.T) { testOrder(t, s) })
+	t.Run("Get", func(t *testing.T) { testGet(t, s) })
+	t.Run("GetByPendingID", func(t *testing.T) { testGetByPendingID(t, s) })
+	t.Run("DeleteBefore", func(t *testing.T) { testDeleteBefore(t, s) })
+	t.Run("Rewrite", func(t *testing.T) { testRewrite(t, s) })
+	t.Run("Retention", func(t *testing.T) { testRetention(t, s) })
+	t.Run("UserMessages", func(t *testing.T) { testUserMessages(t, s) })
+	t.Run("Outbox", func(t *testing.T) { testOutbox(t, s) })
+	t.Run("Duplicate", func(t *testing.T) { testDuplicate(t, s) })
+	t.Run("MaxSeq", func(t *testing.T) { testMaxSeq(t, s) })
+}
+
+type counterSeq int64
+
+func (c *counterSeq) Next(context.Context, string) (int64, error) {
+	*c++
+	return int64(*c), nil
+}
+
+func newRoomID() string {
+	return "room-" + gocql.TimeUUID().String()
+}
+
+func createMsgs(t *testing.T, w *writer.Writer, roomID string, n int) []string {
+	t.Helper()
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		createdAt := start.Add(time.Duration(i) * time.Second)
+		if i == n-1 && n > 1 {
+			// the same timestamp as the previous message checks the msg_id tiebreak
+			createdAt = start.Add(time.Duration(i-1) * time.Second)
+		}
+		rec, err := w.Create(context.Background(), writer.CreateParams{
+			Seq:        int64(i + 1),
+			RoomChatID: roomID,
+			Msg:        "msg",
+			PendingID:  "pending-" + strconv.Itoa(i),
+			CreatedAt:  createdAt,
+			User:       writer.UserParams{ID: "user-1"},
+		})
+		require.NoError(t, err)
+		ids = append(ids, rec.MsgID)
+	}
+	return ids
+}
+
+func testLoad(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	createMsgs(t, writer.New(s, nil, nil), roomID, 5)
+	l := loader.New(s, nil, nil)
+
+	var (
+		all   []loader.Message
+		token string
+	)
+	for i := 0; i < 10; i++ {
+		p, err := loader.NewPagination(token, 2)
+		require.NoError(t, err)
+		page, next, err := l.Load(ctx, roomID, p)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page), 2)
+		all = append(all, page...)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	require.Len(t, all, 5)
+	seen := map[string]bool{}
+	for i, m := range all {
+		assert.False(t, seen[m.ID], "duplicated message %s", m.ID)
+		seen[m.ID] = true
+		assert.Equal(t, "user-1", m.User.ID)
+		assert.Empty(t, m.User.Email, "the profile data must not be stored with the message")
+		if i > 0 {
+			assert.False(t, m.CreatedAt.After(all[i-1].CreatedAt), "messages must be ordered from the newest")
+		}
+	}
+
+	p, err := loader.NewPagination("", 0)
+	require.NoError(t, err)
+	page, next, err := l.Load(ctx, newRoomID(), p)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+	assert.Empty(t, next)
+}
+
+func testWalk(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	ids := createMsgs(t, writer.New(s, nil, nil), roomID, 4)
+
+	var walked []loader.Message
+	err := loader.New(s, nil, nil).Walk(ctx, roomID, func(m loader.Message) error {
+		walked = append(walked, m)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, walked, len(ids))
+	assert.Equal(t, ids[0], walked[0].ID)
+	for i := 1; i < len(walked); i++ {
+		assert.False(t, walked[i].CreatedAt.Before(walked[i-1].CreatedAt), "messages must be ordered from the oldest")
+	}
+
+	rooms, err := s.Rooms(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, rooms, roomID)
+}
+
+func testOrder(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	createdAt := time.Now().UTC().Truncate(time.Millisecond)
+	// the messages of the same time are ordered like the Cassandra timeuuid compares the signed bytes,
+	// so 0x80 goes before 0x7f unlike in the text order of the IDs
+	first, second := gocql.UUIDFromTime(createdAt), gocql.UUIDFromTime(createdAt)
+	second[8], second[9] = first[8], 0x7f
+	first[9] = 0x80
+	w := writer.New(s, nil, nil)
+	for _, id := range []gocql.UUID{second, first} {
+		_, err := w.Create(ctx, writer.CreateParams{
+			MsgID:      id.String(),
+			RoomChatID: roomID,
+			Msg:        "msg",
+			CreatedAt:  createdAt,
+			User:       writer.UserParams{ID: "user-1"},
+		})
+		require.NoError(t, err)
+	}
+	l := loader.New(s, nil, nil)
+
+	var walked []string
+	require.NoError(t, l.Walk(ctx, roomID, func(m loader.Message) error {
+		walked = append(walked, m.ID)
+		return nil
+	}))
+	assert.Equal(t, []string{first.String(), second.String()}, walked)
+
+	var (
+		loaded []string
+		token  string
+	)
+	for i := 0; i < 3; i++ {
+		p, err := loader.NewPagination(token, 1)
+		require.NoError(t, err)
+		page, next, err := l.Load(ctx, roomID, p)
+		require.NoError(t, err)
+		for _, m := range page {
+			loaded = append(loaded, m.ID)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, []string{second.String(), first.String()}, loaded)
+}
+
+func testGet(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	ids := createMsgs(t, writer.New(s, nil, nil), roomID, 2)
+	l := loader.New(s, nil, nil)
+
+	m, err := l.Get(ctx, roomID, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, ids[0], m.ID)
+	assert.Equal(t, int64(1), m.Seq)
+	assert.Equal(t, "msg", m.Msg)
+	assert.Equal(t, "user-1", m.User.ID)
+
+	_, err = l.Get(ctx, newRoomID(), ids[0])
+	assert.ErrorIs(t, err, errbrick.ErrNotFound)
+	_, err = l.Get(ctx, roomID, gocql.TimeUUID().String())
+	assert.ErrorIs(t, err, errbrick.ErrNotFound)
+}
+
+func testGetByPendingID(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	ids := createMsgs(t, writer.New(s, nil, nil), roomID, 2)
+	l := loader.New(s, nil, nil)
+
+	m, err := l.GetByPendingID(ctx, roomID, "pending-1")
+	require.NoError(t, err)
+	assert.Equal(t, ids[1], m.ID)
+
+	_, err = l.GetByPendingID(ctx, roomID, "unknown")
+	assert.ErrorIs(t, err, errbrick.ErrNotFound)
+}
+
+func testDeleteBefore(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	ids := createMsgs(t, writer.New(s, nil, nil), roomID, 3)
+	l := loader.New(s, nil, nil)
+	m, err := l.Get(ctx, roomID, ids[1])
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteBefore(ctx, roomID, m.CreatedAt))
+	_, err = l.Get(ctx, roomID, ids[0])
+	assert.ErrorIs(t, err, errbrick.ErrNotFound)
+	_, err = l.Get(ctx, roomID, ids[1])
+	assert.NoError(t, err)
+}
+
+func testRewrite(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	ids := createMsgs(t, writer.New(s, nil, nil), roomID, 2)
+	l := loader.New(s, nil, nil)
+	rewritten := func(m loader.Message) writer.Record {
+		return writer.Record{CreateParams: writer.CreateParams{
+			MsgID:      m.ID,
+			Seq:        m.Seq,
+			RoomChatID: roomID,
+			Msg:        "rewritten",
+			CreatedAt:  m.CreatedAt,
+			PendingID:  m.PendingID,
+			User:       writer.UserParams{ID: m.User.ID},
+		}, TTL: time.Hour}
+	}
+
+	m, err := l.Get(ctx, roomID, ids[0])
+	require.NoError(t, err)
+	require.NoError(t, s.Rewrite(ctx, rewritten(m)))
+	m, err = l.Get(ctx, roomID, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, "rewritten", m.Msg)
+	assert.Equal(t, int64(1), m.Seq)
+
+	// the message deleted after it was read, e.g. erased, must not come back
+	m, err = l.Get(ctx, roomID, ids[1])
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteMessage(ctx, roomID, m.ID, m.CreatedAt))
+	require.NoError(t, s.Rewrite(ctx, rewritten(m)))
+	_, err = l.Get(ctx, roomID, ids[1])
+	assert.ErrorIs(t, err, errbrick.ErrNotFound)
+}
+
+func testRetention(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+
+	p, err := s.GetRetention(ctx, roomID)
+	require.NoError(t, err)
+	assert.True(t, p.Permanent())
+	assert.Equal(t, roomID, p.RoomChatID)
+
+	_, err = retention.Set(ctx, s, roomID, -1)
+	assert.ErrorIs(t, err, errbrick.ErrInvalidData)
+	set, err := retention.Set(ctx, s, roomID, 30)
+	require.NoError(t, err)
+	p, err = s.GetRetention(ctx, roomID)
+	require.NoError(t, err)
+	assert.Equal(t, 30, p.Days)
+	assert.True(t, p.Pending())
+
+	require.NoError(t, s.MarkRetentionApplied(ctx, roomID, set.UpdatedAt.Add(time.Second)))
+	policies, err := s.ListRetentions(ctx)
+	require.NoError(t, err)
+	var found bool
+	for _, p := range policies {
+		if p.RoomChatID == roomID {
+			found = true
+			assert.Equal(t, 30, p.Days)
+			assert.False(t, p.Pending())
+		}
+	}
+	assert.True(t, found)
+}
+
+func testUserMessages(t *testing.T, s Store) {
+	ctx := context.Background()
+	w := writer.New(s, nil, nil)
+	userID := "user-" + gocql.TimeUUID().String()
+	rooms := []string{newRoomID(), newRoomID()}
+	for i := 0; i < 5; i++ {
+		_, err := w.Create(ctx, writer.CreateParams{
+			RoomChatID: rooms[i%2],
+			Msg:        "msg",
+			CreatedAt:  time.Now().UTC().Truncate(time.Millisecond),
+			User:       writer.UserParams{ID: userID},
+		})
+		require.NoError(t, err)
+	}
+
+	var (
+		all   []privacy.Message
+		token string
+	)
+	for i := 0; i < 10; i++ {
+		page, next, err := s.UserMessages(ctx, userID, token, 2)
+		require.NoError(t, err)
+		all = append(all, page...)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	require.Len(t, all, 5)
+	for _, m := range all {
+		assert.Contains(t, rooms, m.RoomChatID)
+		assert.Equal(t, userID, m.User.ID)
+	}
+
+	require.NoError(t, s.DeleteMessage(ctx, all[0].RoomChatID, all[0].ID, all[0].CreatedAt))
+	_, err := loader.New(s, nil, nil).Get(ctx, all[0].RoomChatID, all[0].ID)
+	assert.ErrorIs(t, err, errbrick.ErrNotFound)
+}
+
+func testOutbox(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	rec, err := writer.New(s, nil, nil).CreateWithEvent(ctx, writer.CreateParams{
+		RoomChatID: roomID,
+		Msg:        "msg",
+		CreatedAt:  time.Now().UTC().Truncate(time.Millisecond),
+		User:       writer.UserParams{ID: "user-1"},
+	}, func(rec writer.Record) (outbox.Entry, error) {
+		e := outbox.NewEntry(ctx, events.TopicMsgStored, rec.RoomChatID, []byte(`{"msg_id":"`+rec.MsgID+`"}`))
+		e.Metadata["k"] = "v"
+		return e, nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, rec.Event)
+
+	pending := func(before time.Time) []outbox.Entry {
+		entries, err := s.PendingOutbox(ctx, before, 1000)
+		require.NoError(t, err)
+		var found []outbox.Entry
+		for _, e := range entries {
+			if e.ID == rec.Event.ID {
+				found = append(found, e)
+			}
+		}
+		return found
+	}
+	assert.Empty(t, pending(rec.Event.CreatedAt.Add(-time.Second)), "the younger entries must be left to the writer")
+	found := pending(time.Now().Add(time.Second))
+	require.Len(t, found, 1)
+	assert.Equal(t, events.TopicMsgStored, found[0].Topic)
+	assert.Equal(t, roomID, found[0].Key)
+	assert.Equal(t, rec.Event.Payload, found[0].Payload)
+	assert.Equal(t, "v", found[0].Metadata["k"])
+
+	require.NoError(t, s.OutboxDone(ctx, found...))
+	assert.Empty(t, pending(time.Now().Add(time.Second)))
+}
+
+func testDuplicate(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	var seq counterSeq
+	w := writer.New(s, nil, &seq)
+	params := writer.CreateParams{
+		MsgID:      gocql.TimeUUID().String(),
+		RoomChatID: roomID,
+		Msg:        "msg",
+		CreatedAt:  time.Now().UTC().Truncate(time.Millisecond),
+		User:       writer.UserParams{ID: "user-1"},
+	}
+	event := func(rec writer.Record) (outbox.Entry, error) {
+		return outbox.NewEntry(ctx, events.TopicMsgStored, rec.RoomChatID, []byte(`{"seq":`+strconv.FormatInt(rec.Seq, 10)+`}`)), nil
+	}
+	first, err := w.CreateWithEvent(ctx, params, event)
+	require.NoError(t, err)
+	assert.False(t, first.Duplicate)
+
+	// the redelivered message keeps its sequence number and its event is not written to the outbox again
+	again, err := w.CreateWithEvent(ctx, params, event)
+	require.NoError(t, err)
+	assert.True(t, again.Duplicate)
+	assert.Equal(t, first.Seq, again.Seq)
+	assert.Equal(t, first.Event.Payload, again.Event.Payload)
+	assert.Equal(t, counterSeq(1), seq)
+	entries, err := s.PendingOutbox(ctx, time.Now().Add(time.Second), 1000)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.NotEqual(t, again.Event.ID, e.ID)
+	}
+	require.NoError(t, s.OutboxDone(ctx, *first.Event))
+
+	m, err := s.Get(ctx, roomID, first.MsgID)
+	require.NoError(t, err)
+	assert.Equal(t, first.Seq, m.Seq)
+}
+
+func testMaxSeq(t *testing.T, s Store) {
+	ctx := context.Background()
+	roomID := newRoomID()
+	seq, err := s.MaxSeq(ctx, roomID)
+	require.NoError(t, err)
+	assert.Zero(t, seq)
+
+	w := writer.New(s, nil, nil)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i, createdAt := range []time.Time{now, now.Add(-time.Hour)} {
+		// the message with the highest sequence number is not the latest one, e.g. the sender clock is behind
+		_, err := w.Create(ctx, writer.CreateParams{
+			Seq:        int64(i + 1),
+			RoomChatID: roomID,
+			Msg:        "msg",
+			CreatedAt:  createdAt,
+			User:       writer.UserParams{ID: "user-1"},
+		})
+		require.NoError(t, err)
+	}
+	seq, err = s.MaxSeq(ctx, roomID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), seq)
+}
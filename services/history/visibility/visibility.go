@@ -0,0 +1,27 @@
+// Package visibility decides which user data the readers of the history see.
+package visibility
+
+import (
+	"context"
+
+	"github.com/demeero/chat/bricks/session"
+	"github.com/demeero/chat/history/loader"
+)
+
+// Policy applies the email visibility to the messages read by the caller of the request.
+type Policy struct {
+	cfg session.EmailConfig
+}
+
+// New creates a new Policy.
+func New(cfg session.EmailConfig) *Policy {
+	return &Policy{cfg: cfg}
+}
+
+// Redact implements loader.Redactor. The reader is the session of the context.
+func (p *Policy) Redact(ctx context.Context, msgs []loader.Message) {
+	viewer := session.FromCtx(ctx)
+	for i := range msgs {
+		msgs[i].User.Email = p.cfg.Email(viewer, msgs[i].User.Email)
+	}
+}
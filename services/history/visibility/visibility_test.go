@@ -0,0 +1,27 @@
+package visibility
+
+import (
+	"context"
+	"testing"
+
+	"github.com/demeero/chat/bricks/session"
+	"github.com/demeero/chat/history/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Redact(t *testing.T) {
+	admin := session.Session{Identity: session.Identity{ID: "admin", MetadataPublic: map[string]interface{}{"role": "admin"}}}
+	user := session.Session{Identity: session.Identity{ID: "user"}}
+	msgs := func() []loader.Message {
+		return []loader.Message{{User: loader.MsgUser{ID: "u1", Email: "john@example.com"}}}
+	}
+	p := New(session.EmailConfig{Visibility: session.EmailMasked, AdminRole: "admin"})
+
+	m := msgs()
+	p.Redact(session.ToCtx(context.Background(), user), m)
+	assert.Equal(t, "j***@example.com", m[0].User.Email)
+
+	m = msgs()
+	p.Redact(session.ToCtx(context.Background(), admin), m)
+	assert.Equal(t, "john@example.com", m[0].User.Email)
+}
@@ -7,10 +7,18 @@ import (
 	"time"
 
 	"github.com/demeero/bricks/errbrick"
+	"github.com/demeero/chat/history/loader"
+	"github.com/demeero/chat/history/outbox"
 	"github.com/gocql/gocql"
 )
 
 type CreateParams struct {
+	// MsgID is the optional TimeUUID of the message. It is generated if empty.
+	// Importers set it to get idempotent writes when the same message is written again.
+	MsgID string
+	// Seq is the optional sequence number of the message in the room. It is assigned by the Sequencer if zero.
+	// Rewrites of the stored messages set it to keep the original sequence number.
+	Seq        int64
 	RoomChatID string
 	Msg        string
 	CreatedAt  time.Time
@@ -31,41 +39,140 @@ func (p CreateParams) validate() error {
 	if p.User.ID == "" {
 		return errors.New("user id is empty")
 	}
-	if p.User.Email == "" {
-		return errors.New("user email is empty")
+	if p.Seq < 0 {
+		return errors.New("seq is negative")
+	}
+	if p.MsgID != "" {
+		id, err := gocql.ParseUUID(p.MsgID)
+		if err != nil {
+			return fmt.Errorf("invalid msg id: %w", err)
+		}
+		if id.Version() != 1 {
+			return errors.New("msg id is not a time uuid")
+		}
 	}
 	return nil
 }
 
+// UserParams identifies the author of the message.
+// Only the ID is stored, the profile data is resolved at read time.
 type UserParams struct {
-	ID        string
-	Email     string
-	FirstName string
-	LastName  string
+	ID string
+}
+
+// Record is the message persisted by the Store.
+// The MsgID of the record is always set.
+type Record struct {
+	CreateParams
+	// TTL is the time the message expires after. Zero TTL means the message never expires.
+	TTL time.Duration
+	// Event is the optional event of the stored message. It is written to the outbox atomically with the message,
+	// so the event is published by the outbox relay even if the writer fails right after the write.
+	Event *outbox.Entry
+	// Duplicate reports the message is already stored, e.g. its event was redelivered. The stored message is kept as it is
+	// and the record carries its sequence number. The event is not written to the outbox again, so it's up to the caller to re-publish it.
+	Duplicate bool
+}
+
+// EventFunc returns the event of the message stored as the record.
+type EventFunc func(rec Record) (outbox.Entry, error)
+
+// Store persists the chat history.
+type Store interface {
+	// Insert inserts the message into the history together with the event of the record.
+	Insert(ctx context.Context, rec Record) error
+	// GetCreated returns the stored message by its creation time and ID, errbrick.ErrNotFound if there is none.
+	// The creation time is a part of the history primary key, so the message is looked up without scanning the room.
+	GetCreated(ctx context.Context, roomChatID string, createdAt time.Time, msgID string) (loader.Message, error)
+}
+
+// Sequencer assigns the monotonically increasing sequence numbers to the messages of the room,
+// so the messages are ordered regardless of the clocks of the senders and the gaps are detected by the clients.
+// The number is taken before the write, so a failed write leaves a gap which is never filled.
+type Sequencer interface {
+	// Next returns the next sequence number of the room.
+	Next(ctx context.Context, roomChatID string) (int64, error)
+}
+
+// RetentionResolver resolves the retention of the chat room messages.
+type RetentionResolver interface {
+	// Retention returns how long the messages of the chat room are kept for. Zero means the messages are kept permanently.
+	Retention(ctx context.Context, roomChatID string) (time.Duration, error)
 }
 
 type Writer struct {
-	sess *gocql.Session
+	store     Store
+	retention RetentionResolver
+	seq       Sequencer
+}
+
+// New creates a new Writer. If retention is nil, all messages are kept permanently.
+// If seq is nil, the messages without the sequence number are stored unsequenced, e.g. the imported history.
+func New(store Store, retention RetentionResolver, seq Sequencer) *Writer {
+	return &Writer{store: store, retention: retention, seq: seq}
 }
 
-func New(sess *gocql.Session) *Writer {
-	return &Writer{sess: sess}
+// Create stores the message and returns the stored record with the assigned message ID and sequence number.
+func (w *Writer) Create(ctx context.Context, params CreateParams) (Record, error) {
+	return w.CreateWithEvent(ctx, params, nil)
 }
 
-func (w *Writer) Create(ctx context.Context, params CreateParams) (string, error) {
+// CreateWithEvent stores the message together with the event returned by the event func for the record.
+// The event is not created for the message which is out of the room retention and therefore is not stored.
+func (w *Writer) CreateWithEvent(ctx context.Context, params CreateParams, event EventFunc) (Record, error) {
 	if err := params.validate(); err != nil {
-		return "", fmt.Errorf("%w: %s", errbrick.ErrInvalidData, err)
+		return Record{}, fmt.Errorf("%w: %s", errbrick.ErrInvalidData, err)
+	}
+	// the message ID given by the caller is derived from the message, so the same message may be written again
+	knownID := params.MsgID != ""
+	if !knownID {
+		params.MsgID = gocql.TimeUUID().String()
+	}
+	rec := Record{CreateParams: params}
+	if w.retention != nil {
+		retention, err := w.retention.Retention(ctx, params.RoomChatID)
+		if err != nil {
+			return Record{}, fmt.Errorf("failed resolve room retention: %w", err)
+		}
+		if retention > 0 {
+			// the message expires after the retention since it was created, not since it was written
+			rec.TTL = time.Until(params.CreatedAt.Add(retention))
+			if rec.TTL <= 0 {
+				// the message, e.g. an imported one, is already out of the retention, so there is nothing to keep
+				return rec, nil
+			}
+		}
+	}
+	if rec.Seq == 0 && w.seq != nil && knownID {
+		// both stores upsert, so the message written again would be rewritten with the new sequence number
+		stored, err := w.store.GetCreated(ctx, rec.RoomChatID, rec.CreatedAt, rec.MsgID)
+		switch {
+		case err == nil:
+			rec.Seq = stored.Seq
+			rec.Duplicate = true
+		case !errors.Is(err, errbrick.ErrNotFound):
+			return Record{}, fmt.Errorf("failed get stored msg: %w", err)
+		}
+	}
+	if rec.Seq == 0 && w.seq != nil && !rec.Duplicate {
+		seq, err := w.seq.Next(ctx, rec.RoomChatID)
+		if err != nil {
+			return Record{}, fmt.Errorf("failed assign sequence number: %w", err)
+		}
+		rec.Seq = seq
+	}
+	if event != nil {
+		e, err := event(rec)
+		if err != nil {
+			return Record{}, fmt.Errorf("failed create stored msg event: %w", err)
+		}
+		rec.Event = &e
+	}
+	if rec.Duplicate {
+		return rec, nil
 	}
-	msgID := gocql.TimeUUID()
-	stmt := `INSERT INTO chat.history (chat_room_id, msg_id, msg, user_id, user_email, user_first_name, user_last_name, created_at, pending_id) 
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	err := w.sess.Query(stmt,
-		params.RoomChatID, msgID, params.Msg, params.User.ID, params.User.Email, params.User.FirstName,
-		params.User.LastName, params.CreatedAt, params.PendingID).
-		WithContext(ctx).
-		Exec()
-	if err != nil {
-		return "", fmt.Errorf("failed insert into history: %w", err)
+	if err := w.store.Insert(ctx, rec); err != nil {
+		return Record{}, fmt.Errorf("failed insert into history: %w", err)
 	}
-	return msgID.String(), nil
+	return rec, nil
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/demeero/chat/bricks/wschat"
+)
+
+// frameCacheSize is the number of the recently encoded frames kept by the FrameCache.
+const frameCacheSize = 1024
+
+type frameKey struct {
+	msgID        string
+	protocol     wschat.Protocol
+	emailVisible bool
+}
+
+type frame struct {
+	once sync.Once
+	b    []byte
+	err  error
+}
+
+// FrameCache keeps the recently encoded frames, so the message delivered to all the connections of the receiver
+// is hydrated and encoded once per protocol and email visibility instead of once per connection.
+type FrameCache struct {
+	mu     sync.Mutex
+	frames map[frameKey]*frame
+	keys   []frameKey
+	next   int
+}
+
+func NewFrameCache(size int) *FrameCache {
+	return &FrameCache{frames: make(map[frameKey]*frame, size), keys: make([]frameKey, 0, size)}
+}
+
+// Get returns the frame of the key. The frame missing in the cache is encoded once, the concurrent callers wait for it.
+func (c *FrameCache) Get(key frameKey, encode func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	f, ok := c.frames[key]
+	if !ok {
+		f = &frame{}
+		c.frames[key] = f
+		if len(c.keys) < cap(c.keys) {
+			c.keys = append(c.keys, key)
+		} else {
+			// the oldest frame is evicted, the messages are delivered right after they are stored
+			delete(c.frames, c.keys[c.next])
+			c.keys[c.next] = key
+			c.next = (c.next + 1) % len(c.keys)
+		}
+	}
+	c.mu.Unlock()
+	f.once.Do(func() {
+		f.b, f.err = encode()
+	})
+	return f.b, f.err
+}
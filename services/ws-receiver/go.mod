@@ -74,3 +74,6 @@ require (
 	google.golang.org/grpc v1.59.0 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 )
+
+// the shared event contract is developed together with the services
+replace github.com/demeero/chat/bricks => ../../bricks
@@ -7,22 +7,30 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/MicahParks/keyfunc/v2"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/demeero/bricks/echobrick"
 	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/events"
 	"github.com/demeero/chat/bricks/httpsrv"
+	"github.com/demeero/chat/bricks/session"
+	"github.com/demeero/chat/bricks/watermillbrick"
+	"github.com/demeero/chat/bricks/wschat"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"golang.org/x/net/websocket"
 )
 
-const topic = "msg_sent"
+// topic is the stream of the stored messages, so the receivers get the messages with the room sequence numbers
+// assigned by the history writer in the order of the sequence.
+const topic = events.TopicMsgStored
 
-func setupHTTPSrv(ctx context.Context, cfg Config, sub message.Subscriber) *echo.Echo {
+// setupHTTPSrv serves the receiver. If the publisher is set, the gateway is served as well.
+func setupHTTPSrv(ctx context.Context, cfg Config, sub message.Subscriber, pub *watermillbrick.Publisher, profiles *Profiles) *echo.Echo {
 	httpCfg := cfg.HTTP
 	meterMW, err := echobrick.OTELMeterMW(echobrick.OTELMeterMWConfig{
 		Attrs: &echobrick.OTELMeterAttrsConfig{
@@ -65,7 +73,38 @@ func setupHTTPSrv(ctx context.Context, cfg Config, sub message.Subscriber) *echo
 	e.Use(httpsrv.SessionCtxMW())
 	e.Use(echobrick.SlogLogMW(slog.LevelDebug, nil))
 
-	e.GET("/receiver", receiverHandler(sub))
+	validator, err := events.NewValidator()
+	if err != nil {
+		log.Fatalf("failed create event validator: %s", err)
+	}
+	subMetrics, err := watermillbrick.NewSubMetrics()
+	if err != nil {
+		log.Fatalf("failed create subscriber metrics: %s", err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("failed get hostname: %s", err)
+	}
+	deliverLatency, err := events.NewLatency("msg_send_to_deliver_latency",
+		"The time since the user sent the message until it is written to the receiver socket", hostname)
+	if err != nil {
+		log.Fatalf("failed create deliver latency metric: %s", err)
+	}
+	subscriber := Subscriber{
+		Topic:     topic,
+		Sub:       sub,
+		Profiles:  profiles,
+		Email:     cfg.Email,
+		Validator: validator,
+		Upcasters: events.NewUpcasters(),
+		Metrics:   subMetrics.FanOutMiddleware(topic),
+		Latency:   deliverLatency,
+		Frames:    NewFrameCache(frameCacheSize),
+	}
+	e.GET("/receiver", receiverHandler(subscriber))
+	if pub != nil {
+		e.GET("/ws", gatewayHandler(ctx, pub, validator, subscriber))
+	}
 
 	go func() {
 		slog.Info("initializing HTTP server", slog.Int("port", httpCfg.Port))
@@ -77,18 +116,45 @@ func setupHTTPSrv(ctx context.Context, cfg Config, sub message.Subscriber) *echo
 	return e
 }
 
-func receiverHandler(sub message.Subscriber) echo.HandlerFunc {
+func receiverHandler(subscriber Subscriber) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		websocket.Handler(func(ws *websocket.Conn) {
+		wschat.Server(func(ws *websocket.Conn) {
 			defer ws.Close()
-			err := Subscriber{
-				Topic: topic,
-				Sub:   sub,
-			}.Subscribe(ws.Request().Context(), ws)
-			if err != nil {
+			if err := subscriber.Subscribe(ws.Request().Context(), ws); err != nil {
 				slogbrick.FromCtx(c.Request().Context()).Error("failed subscribe", slog.Any("err", err))
 			}
 		}).ServeHTTP(c.Response(), c.Request())
 		return nil
 	}
 }
+
+// gatewayHandler serves both directions on one websocket: the messages the user sends are published as ws-sender does,
+// and the stored messages are delivered as the receiver does. The connection is closed once either direction is done.
+func gatewayHandler(ctx context.Context, pub message.Publisher, validator *events.Validator, subscriber Subscriber) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		wschat.Server(func(ws *websocket.Conn) {
+			wsCtx, cancel := context.WithCancel(ws.Request().Context())
+			defer cancel()
+			go func() {
+				select {
+				case <-ctx.Done():
+				case <-wsCtx.Done():
+				}
+				ws.Close()
+			}()
+			go func() {
+				defer cancel()
+				if err := subscriber.Subscribe(wsCtx, ws); err != nil {
+					slogbrick.FromCtx(c.Request().Context()).Error("failed subscribe", slog.Any("err", err))
+				}
+			}()
+			wschat.Sender{
+				Topic:     events.TopicMsgSent,
+				Sess:      session.FromCtx(c.Request().Context()),
+				Pub:       pub,
+				Validator: validator,
+			}.Execute(ws)
+		}).ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
@@ -8,11 +8,12 @@ import (
 	"os/signal"
 
 	"github.com/ThreeDotsLabs/watermill"
-	"github.com/ThreeDotsLabs/watermill-redisstream/pkg/redisstream"
 	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
 	"github.com/demeero/bricks/configbrick"
 	"github.com/demeero/bricks/otelbrick"
 	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/session"
+	"github.com/demeero/chat/bricks/watermillbrick"
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	_ "go.uber.org/automaxprocs"
@@ -20,12 +21,17 @@ import (
 
 type Config struct {
 	configbrick.AppMeta
-	Redis     configbrick.Redis `json:"redis"`
-	Log       configbrick.Log   `json:"log"`
-	HTTP      configbrick.HTTP  `json:"http"`
-	JwksURL   string            `split_words:"true" json:"jwks_url"`
-	LogConfig bool              `default:"false" split_words:"true" json:"log_config"`
-	OTEL      configbrick.OTEL  `json:"otel"`
+	Redis     configbrick.Redis           `json:"redis"`
+	Broker    watermillbrick.BrokerConfig `json:"broker"`
+	Log       configbrick.Log             `json:"log"`
+	HTTP      configbrick.HTTP            `json:"http"`
+	JwksURL   string                      `split_words:"true" json:"jwks_url"`
+	Profile   ProfileConfig               `json:"profile"`
+	Email     session.EmailConfig         `json:"email"`
+	LogConfig bool                        `default:"false" split_words:"true" json:"log_config"`
+	OTEL      configbrick.OTEL            `json:"otel"`
+	// Gateway serves the /ws websocket the clients both send and receive the messages over, next to the /receiver one.
+	Gateway bool `default:"false" json:"gateway"`
 }
 
 func main() {
@@ -36,6 +42,9 @@ func main() {
 		AddSource: cfg.Log.AddSource,
 		JSON:      cfg.Log.JSON,
 	})
+	if err := cfg.Email.Validate(); err != nil {
+		log.Fatalf("invalid email config: %s", err)
+	}
 
 	ctx := context.Background()
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, os.Kill)
@@ -79,9 +88,15 @@ func main() {
 		log.Fatalf("failed instrument redis with metrics: %s", err)
 	}
 	wmLogger := watermill.NewSlogLogger(slog.Default())
-	sub, err := redisstream.NewSubscriber(redisstream.SubscriberConfig{
-		Client: rdb,
-	}, wmLogger)
+	broker, err := watermillbrick.NewBroker(cfg.Broker, rdb, wmLogger)
+	if err != nil {
+		log.Fatalf("failed create message broker: %s", err)
+	}
+	// every receiver gets all the stored messages to deliver them to its connections
+	sub, err := broker.Subscriber("")
+	if err != nil {
+		log.Fatalf("failed create watermill subscriber: %s", err)
+	}
 	fo, err := gochannel.NewFanOut(sub, wmLogger)
 	fo.AddSubscription(topic)
 	go func() {
@@ -90,7 +105,19 @@ func main() {
 		}
 	}()
 
-	httpSrv := setupHTTPSrv(ctx, cfg, fo)
+	var pub *watermillbrick.Publisher
+	if cfg.Gateway {
+		pub, err = broker.Publisher(watermillbrick.PubConfig{
+			Name:                "ws-gateway",
+			Metrics:             true,
+			NewRootSpanWithLink: true,
+		})
+		if err != nil {
+			log.Fatalf("failed create instrumented watermill publisher: %s", err)
+		}
+	}
+
+	httpSrv := setupHTTPSrv(ctx, cfg, fo, pub, NewProfiles(rdb, cfg.Profile))
 
 	<-ctx.Done()
 	slog.Info("shutting down")
@@ -100,6 +127,11 @@ func main() {
 	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
 		slog.Error("failed shutdown http srv", slog.Any("err", err))
 	}
+	if pub != nil {
+		if err := pub.Close(); err != nil {
+			slog.Error("failed close watermill publisher", slog.Any("err", err))
+		}
+	}
 	if err := rdb.Close(); err != nil {
 		slog.Error("failed close redis conn", slog.Any("err", err))
 	}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/identity"
+	"github.com/redis/go-redis/v9"
+)
+
+type ProfileConfig struct {
+	// KratosAdminURL is the URL of the Kratos admin API the profiles missing in Redis are fetched from.
+	KratosAdminURL string        `default:"http://kratos:4434" split_words:"true" json:"kratos_admin_url"`
+	CacheTTL       time.Duration `default:"1m" split_words:"true" json:"cache_ttl"`
+	CacheSize      int           `default:"10000" split_words:"true" json:"cache_size"`
+}
+
+// Profiles resolves the profiles of the message authors, since the events carry only the user ID.
+// The profiles are cached in memory, the misses are read from the profiles the history service keeps in Redis
+// and then from the Kratos admin API.
+type Profiles struct {
+	store  *identity.Store
+	kratos *identity.Kratos
+	cache  *identity.Cache
+}
+
+func NewProfiles(rdb redis.UniversalClient, cfg ProfileConfig) *Profiles {
+	return &Profiles{
+		store:  identity.NewStore(rdb),
+		kratos: identity.NewKratos(cfg.KratosAdminURL, &http.Client{Timeout: 5 * time.Second}),
+		cache:  identity.NewCache(cfg.CacheSize, cfg.CacheTTL),
+	}
+}
+
+// Get returns the profile of the user. The bool is false if the user has no profile.
+func (p *Profiles) Get(ctx context.Context, userID string) (identity.Profile, bool, error) {
+	if prof, found, ok := p.cache.Get(userID); ok {
+		return prof, found, nil
+	}
+	prof, found, err := p.load(ctx, userID)
+	if err != nil {
+		return identity.Profile{}, false, err
+	}
+	p.cache.Set(userID, prof, found)
+	return prof, found, nil
+}
+
+func (p *Profiles) load(ctx context.Context, userID string) (identity.Profile, bool, error) {
+	stored, err := p.store.Get(ctx, userID)
+	if err != nil {
+		return identity.Profile{}, false, err
+	}
+	if prof, ok := stored[userID]; ok {
+		return prof, true, nil
+	}
+	// the erased users are not fetched, their profiles stay deleted
+	erased, err := p.store.Erased(ctx, userID)
+	if err != nil || erased {
+		return identity.Profile{}, false, err
+	}
+	prof, ok, err := p.kratos.Get(ctx, userID)
+	if err != nil || !ok {
+		return identity.Profile{}, false, err
+	}
+	if err := p.store.Upsert(ctx, prof); err != nil {
+		slogbrick.FromCtx(ctx).Warn("failed save fetched profile", slog.Any("err", err))
+	}
+	return prof, true, nil
+}
+
+// Hydrate fills the user of the event payload with the profile of the user and passes the user email through the email func,
+// which applies the email visibility for the receiver. The other fields of the payload are kept as they are.
+// If the profile can't be resolved, the user is sent as it is in the event, so the message is still delivered.
+func (p *Profiles) Hydrate(ctx context.Context, payload []byte, email func(string) string) []byte {
+	lg := slogbrick.FromCtx(ctx)
+	var evt map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		lg.Warn("failed decode evt - send as is", slog.Any("err", err))
+		return payload
+	}
+	var user identity.Profile
+	if err := json.Unmarshal(evt["user"], &user); err != nil || user.ID == "" {
+		return payload
+	}
+	prof, found, err := p.Get(ctx, user.ID)
+	if err != nil {
+		lg.Warn("failed resolve profile - send user as is", slog.String("user_id", user.ID), slog.Any("err", err))
+	}
+	if found {
+		id := user.ID
+		user = prof
+		user.ID = id
+	}
+	user.Email = email(user.Email)
+	b, err := json.Marshal(user)
+	if err != nil {
+		return payload
+	}
+	evt["user"] = b
+	if b, err = json.Marshal(evt); err != nil {
+		return payload
+	}
+	return b
+}
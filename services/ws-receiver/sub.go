@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -10,14 +9,27 @@ import (
 
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/demeero/bricks/slogbrick"
+	"github.com/demeero/chat/bricks/events"
+	"github.com/demeero/chat/bricks/session"
+	"github.com/demeero/chat/bricks/wschat"
 	wotelfloss "github.com/dentech-floss/watermill-opentelemetry-go-extra/pkg/opentelemetry"
 	wotel "github.com/voi-oss/watermill-opentelemetry/pkg/opentelemetry"
 	"golang.org/x/net/websocket"
 )
 
 type Subscriber struct {
-	Topic string
-	Sub   message.Subscriber
+	Topic     string
+	Sub       message.Subscriber
+	Profiles  *Profiles
+	Email     session.EmailConfig
+	Validator *events.Validator
+	Upcasters *events.Upcasters
+	// Metrics records the metrics of the messages, it's built once and shared by the connections.
+	Metrics message.HandlerMiddleware
+	// Latency records the time since the message was sent until it is written to the socket.
+	Latency *events.Latency
+	// Frames shares the encoded messages between the connections.
+	Frames *FrameCache
 }
 
 func (s Subscriber) Subscribe(ctx context.Context, ws *websocket.Conn) error {
@@ -28,13 +40,39 @@ func (s Subscriber) Subscribe(ctx context.Context, ws *websocket.Conn) error {
 		return fmt.Errorf("failed subscribe %s: %w", s.Topic, err)
 	}
 
-	h := msgHandler(ws)
+	viewer := session.FromCtx(ws.Request().Context())
+	proto := wschat.ProtocolOf(ws)
+	emailVisible := s.Email.Visible(viewer)
+	h := s.Metrics(msgHandler(ws, proto, func(msg *message.Message) ([]byte, error) {
+		// the frame depends on the viewer by the email visibility only, so it's shared by the connections of the same one
+		key := frameKey{msgID: msg.UUID, protocol: proto, emailVisible: emailVisible}
+		return s.Frames.Get(key, func() ([]byte, error) {
+			return proto.Encode(s.Profiles.Hydrate(msg.Context(), msg.Payload, func(email string) string {
+				return s.Email.Email(viewer, email)
+			}))
+		})
+	}))
 
 	lg := slogbrick.FromCtx(ws.Request().Context()).With(slog.String("topic", s.Topic))
 	for msg := range msgs {
 		lg.Debug("received redis evt",
 			slog.String("payload", string(msg.Payload)),
 			slog.Any("metadata", msg.Metadata))
+		if events.Replayed(msg.Metadata) {
+			// the replayed messages rebuild the history projections, the users have already received them
+			msg.Ack()
+			continue
+		}
+		if err := s.Upcasters.UpcastMsg(s.Topic, msg); err != nil {
+			lg.Warn("failed upcast evt - skip", slog.Any("err", err), slog.Any("metadata", msg.Metadata))
+			msg.Ack()
+			continue
+		}
+		if err := s.Validator.Decode(msg.Context(), s.Topic, msg.Payload, &events.MsgStored{}); err != nil {
+			lg.Warn("invalid evt - skip", slog.Any("err", err), slog.String("payload", string(msg.Payload)))
+			msg.Ack()
+			continue
+		}
 		_, err := h(msg)
 		if errors.Is(err, syscall.EPIPE) {
 			break
@@ -43,18 +81,22 @@ func (s Subscriber) Subscribe(ctx context.Context, ws *websocket.Conn) error {
 			lg.Debug("failed send message to ws", slog.Any("err", err))
 			break
 		}
+		s.Latency.Record(msg.Context(), msg.Metadata)
 		msg.Ack()
 	}
 
 	return nil
 }
 
-func msgHandler(ws *websocket.Conn) message.HandlerFunc {
+func msgHandler(ws *websocket.Conn, proto wschat.Protocol, frame func(msg *message.Message) ([]byte, error)) message.HandlerFunc {
 	return wotelfloss.ExtractRemoteParentSpanContextHandler(wotel.TraceHandler(func(msg *message.Message) ([]*message.Message, error) {
-		err := websocket.JSON.Send(ws, json.RawMessage(msg.Payload))
+		b, err := frame(msg)
 		if err != nil {
 			return nil, err
 		}
+		if err := proto.Send(ws, b); err != nil {
+			return nil, err
+		}
 		return []*message.Message{msg}, nil
 	}))
 }
@@ -12,16 +12,16 @@ import (
 	"github.com/MicahParks/keyfunc/v2"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/demeero/bricks/echobrick"
+	"github.com/demeero/chat/bricks/events"
 	"github.com/demeero/chat/bricks/httpsrv"
 	"github.com/demeero/chat/bricks/session"
+	"github.com/demeero/chat/bricks/wschat"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"golang.org/x/net/websocket"
 )
 
-const topic = "msg_sent"
-
 func setupHTTPSrv(ctx context.Context, cfg Config, pub message.Publisher) *echo.Echo {
 	meterMW, err := echobrick.OTELMeterMW(echobrick.OTELMeterMWConfig{
 		Attrs: &echobrick.OTELMeterAttrsConfig{
@@ -64,7 +64,11 @@ func setupHTTPSrv(ctx context.Context, cfg Config, pub message.Publisher) *echo.
 	e.Use(httpsrv.SessionCtxMW())
 	e.Use(echobrick.SlogLogMW(slog.LevelDebug, nil))
 
-	e.GET("/sender", sender(ctx, pub))
+	validator, err := events.NewValidator()
+	if err != nil {
+		log.Fatalf("failed create event validator: %s", err)
+	}
+	e.GET("/sender", sender(ctx, pub, validator))
 
 	go func() {
 		slog.Info("initializing HTTP server", slog.Int("port", cfg.HTTP.Port))
@@ -76,17 +80,18 @@ func setupHTTPSrv(ctx context.Context, cfg Config, pub message.Publisher) *echo.
 	return e
 }
 
-func sender(ctx context.Context, pub message.Publisher) echo.HandlerFunc {
+func sender(ctx context.Context, pub message.Publisher, validator *events.Validator) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		websocket.Handler(func(ws *websocket.Conn) {
+		wschat.Server(func(ws *websocket.Conn) {
 			go func() {
 				<-ctx.Done()
 				ws.Close()
 			}()
-			Sender{
-				Topic: topic,
-				Sess:  session.FromCtx(c.Request().Context()),
-				Pub:   pub,
+			wschat.Sender{
+				Topic:     events.TopicMsgSent,
+				Sess:      session.FromCtx(c.Request().Context()),
+				Pub:       pub,
+				Validator: validator,
 			}.Execute(ws)
 		}).ServeHTTP(c.Response(), c.Request())
 		return nil
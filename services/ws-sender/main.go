@@ -8,11 +8,10 @@ import (
 	"os/signal"
 
 	"github.com/ThreeDotsLabs/watermill"
-	"github.com/ThreeDotsLabs/watermill-redisstream/pkg/redisstream"
 	"github.com/demeero/bricks/configbrick"
 	"github.com/demeero/bricks/otelbrick"
 	"github.com/demeero/bricks/slogbrick"
-	"github.com/demeero/bricks/watermillbrick"
+	"github.com/demeero/chat/bricks/watermillbrick"
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	_ "go.uber.org/automaxprocs"
@@ -20,11 +19,12 @@ import (
 
 type Config struct {
 	configbrick.AppMeta
-	Redis   configbrick.Redis `json:"redis"`
-	Log     configbrick.Log   `json:"log"`
-	HTTP    configbrick.HTTP  `json:"http"`
-	JwksURL string            `split_words:"true" json:"jwks_url"`
-	OTEL    configbrick.OTEL  `json:"otel"`
+	Redis   configbrick.Redis           `json:"redis"`
+	Broker  watermillbrick.BrokerConfig `json:"broker"`
+	Log     configbrick.Log             `json:"log"`
+	HTTP    configbrick.HTTP            `json:"http"`
+	JwksURL string                      `split_words:"true" json:"jwks_url"`
+	OTEL    configbrick.OTEL            `json:"otel"`
 }
 
 func main() {
@@ -78,15 +78,15 @@ func main() {
 		log.Fatalf("failed instrument redis with metrics: %s", err)
 	}
 
-	pub, err := redisstream.NewPublisher(redisstream.PublisherConfig{Client: rdb}, watermill.NewSlogLogger(slog.Default()))
+	broker, err := watermillbrick.NewBroker(cfg.Broker, rdb, watermill.NewSlogLogger(slog.Default()))
 	if err != nil {
-		log.Fatalf("failed create redisstream publisher: %s", err)
+		log.Fatalf("failed create message broker: %s", err)
 	}
-	publisher, err := watermillbrick.NewOTELPublisher(watermillbrick.OTELPubConfig{
+	publisher, err := broker.Publisher(watermillbrick.PubConfig{
 		Name:                "ws-sender",
 		Metrics:             true,
 		NewRootSpanWithLink: true,
-	}, pub)
+	})
 	if err != nil {
 		log.Fatalf("failed create instrumented watermill publisher: %s", err)
 	}
@@ -101,8 +101,8 @@ func main() {
 	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
 		slog.Error("failed shutdown http srv", slog.Any("err", err))
 	}
-	if err := pub.Close(); err != nil {
-		slog.Error("failed close redisstream publisher", slog.Any("err", err))
+	if err := publisher.Close(); err != nil {
+		slog.Error("failed close watermill publisher", slog.Any("err", err))
 	}
 	if err := rdb.Close(); err != nil {
 		slog.Error("failed close redis conn", slog.Any("err", err))